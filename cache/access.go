@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"errors"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+)
+
+const (
+	ActionRead  uint8 = 0
+	ActionWrite uint8 = 1
+)
+
+const (
+	FolderAccessScene   uint8 = 0 //场景内的成员可以访问
+	FolderAccessPrivate uint8 = 1 //只有创建者和指定的用户可以访问
+	FolderAccessPublic  uint8 = 2 //所有人可读
+)
+
+const RoleAdmin = "admin"
+
+//调用者的身份
+type Principal struct {
+	User    string
+	Service string   //通过HMAC签名认证的内部服务
+	Scenes  []string //所属的场景（组织）
+	Admin   bool
+}
+
+func AccessEnable() bool {
+	return config.Schema.Access.Enable
+}
+
+//开启访问控制时必须开启认证，否则调用者的身份和角色都来自没有校验的请求头
+func CheckAccess() error {
+	if AccessEnable() && !AuthEnable() {
+		return errors.New("the access control needs the auth enabled")
+	}
+	return nil
+}
+
+func NewPrincipal(user string, scenes, roles []string) *Principal {
+	tmp := &Principal{
+		User:   user,
+		Scenes: scenes,
+	}
+	if tmp.Scenes == nil {
+		tmp.Scenes = make([]string, 0, 1)
+	}
+	if len(user) > 0 && tool.HasItem(config.Schema.Access.Admins, user) {
+		tmp.Admin = true
+	}
+	if tool.HasItem(roles, RoleAdmin) {
+		tmp.Admin = true
+	}
+	return tmp
+}
+
+//没有开启访问控制或者是管理员时不做限制
+func (mine *Principal) allow() bool {
+	return !AccessEnable() || mine.Admin
+}
+
+func (mine *Principal) Anonymous() bool {
+	return len(mine.User) < 1
+}
+
+func (mine *Principal) InScene(scene string) bool {
+	if len(scene) < 1 {
+		return false
+	}
+	return scene == mine.User || tool.HasItem(mine.Scenes, scene)
+}
+
+func (mine *Principal) isSelf(uid string) bool {
+	return !mine.Anonymous() && uid == mine.User
+}
+
+//管理员专属的操作
+func (mine *Principal) CanManage() bool {
+	return mine.allow()
+}
+
+//在场景（或者实体）下创建、修改数据，system场景只有管理员可以写
+func (mine *Principal) CanScene(scene string, action uint8) bool {
+	if mine.allow() {
+		return true
+	}
+	if scene == "" || scene == DefaultScene {
+		return action == ActionRead
+	}
+	return mine.InScene(scene)
+}
+
+//默认人脸库所有人可以搜索，机构的人脸库以场景命名，需要场景的读权限
+func (mine *Principal) CanFaceGroup(group string) bool {
+	if group == FaceGroupDefault {
+		return true
+	}
+	return mine.CanScene(group, ActionRead)
+}
+
+//系统资源对非管理员只读；已发布的资源所有人可读；个人资源只有所有者和创建者可以访问
+func (mine *Principal) CanAsset(info *AssetInfo, action uint8) bool {
+	if mine.allow() {
+		return true
+	}
+	if info == nil {
+		return false
+	}
+	if info.Scope == AssetScopeSystem {
+		return action == ActionRead
+	}
+	if mine.isSelf(info.Creator) || mine.isSelf(info.Owner) {
+		return true
+	}
+	if action == ActionRead && (info.Status == StatusPublish || info.Status == StatusVisible) {
+		return true
+	}
+	if info.Scope == AssetScopeOrg {
+		return mine.InScene(info.Owner)
+	}
+	return false
+}
+
+//人脸缩略图跟随所属资源的权限
+func (mine *Principal) CanThumb(info *ThumbInfo, action uint8) bool {
+	if mine.allow() {
+		return true
+	}
+	if info == nil {
+		return false
+	}
+	asset := Context().GetAsset(info.Asset)
+	if asset == nil {
+		return mine.isSelf(info.Creator)
+	}
+	return mine.CanAsset(asset, action)
+}
+
+//人脸用户关联的所有资源都需要有写权限
+func (mine *Principal) CanFaceUser(user string) bool {
+	if mine.allow() {
+		return true
+	}
+	dbs, err := nosql.GetThumbsByUser(user)
+	if err != nil {
+		return false
+	}
+	for _, db := range dbs {
+		info := new(ThumbInfo)
+		info.initInfo(db)
+		if !mine.CanThumb(info, ActionWrite) {
+			return false
+		}
+	}
+	return true
+}
+
+//Folder.Access决定可见范围，Folder.Users里的用户拥有读写权限
+func (mine *Principal) CanFolder(info *FolderInfo, action uint8) bool {
+	if mine.allow() {
+		return true
+	}
+	if info == nil {
+		return false
+	}
+	if mine.isSelf(info.Creator) || (!mine.Anonymous() && tool.HasItem(info.Users, mine.User)) {
+		return true
+	}
+	if action == ActionRead && info.Access == FolderAccessPublic {
+		return true
+	}
+	if info.Access == FolderAccessPrivate {
+		return false
+	}
+	return mine.CanScene(info.Scene, action)
+}
+
+func (mine *Principal) CanLabel(info *LabelInfo, action uint8) bool {
+	if mine.allow() {
+		return true
+	}
+	if info == nil {
+		return false
+	}
+	if mine.isSelf(info.Creator) && info.Scene != DefaultScene {
+		return true
+	}
+	return mine.CanScene(info.Scene, action)
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"omo.msa.asset/config"
+	"testing"
+)
+
+func TestCanFaceGroup(t *testing.T) {
+	old := config.Schema.Access
+	defer func() {
+		config.Schema.Access = old
+	}()
+	config.Schema.Access.Enable = true
+	config.Schema.Access.Admins = []string{"root"}
+	who := NewPrincipal("alice", []string{"scene-a"}, nil)
+	cases := []struct {
+		group string
+		want  bool
+	}{
+		{FaceGroupDefault, true},
+		{"scene-a", true},
+		{"alice", true},
+		{"scene-b", false},
+	}
+	for _, item := range cases {
+		if got := who.CanFaceGroup(item.group); got != item.want {
+			t.Errorf("CanFaceGroup(%q) = %v, want %v", item.group, got, item.want)
+		}
+	}
+	if !NewPrincipal("root", nil, nil).CanFaceGroup("scene-b") {
+		t.Error("the admin should search any face group")
+	}
+	query := &FaceQuery{Owner: "scene-b"}
+	if groups := query.SearchGroups(); len(groups) != 2 || groups[1] != "scene-b" {
+		t.Errorf("SearchGroups() = %v, want the default and owner groups", groups)
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"github.com/qiniu/api.v7/v7/auth/qbox"
 	"github.com/qiniu/api.v7/v7/storage"
@@ -51,6 +52,7 @@ type AssetInfo struct {
 	Created  int64
 	Updated  int64
 	Code     int    //内部状态码
+	Scan     uint8  //病毒扫描结果
 	ID       uint64 `json:"-"`
 	UID      string `json:"uid"`
 	Name     string `json:"name"`
@@ -58,11 +60,14 @@ type AssetInfo struct {
 	Meta     string
 	Creator  string
 	Operator string
+	Revision uint64
 
 	Owner    string
 	UUID     string //file 云存储文件名
 	Version  string
 	Format   string
+	Mime     string //嗅探到的真实类型
+	Virus    string
 	MD5      string
 	Language string
 	Quote    string //引用的对象
@@ -74,6 +79,8 @@ type AssetInfo struct {
 
 	Links []string //关联的实体
 	Tags  []string
+
+	Quarantine bool //被隔离的资源不提供访问地址
 }
 
 func (mine *cacheContext) CreateAsset(info *pb.ReqAssetAdd) (*AssetInfo, error) {
@@ -108,14 +115,20 @@ func (mine *cacheContext) CreateAsset(info *pb.ReqAssetAdd) (*AssetInfo, error)
 	if db.Tags == nil {
 		db.Tags = make([]string, 0, 1)
 	}
+	//配置了病毒扫描时，扫描结果保存之前不出现在列表中，也不提供访问地址
+	db.Quarantine = scanEnable()
 
-	err := nosql.CreateAsset(db)
-	if err == nil {
-		tmp := new(AssetInfo)
-		tmp.initInfo(db)
-		if tmp.SupportFace() {
-			go validateAsset(tmp)
+	tmp := new(AssetInfo)
+	tmp.initInfo(db)
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.CreateAsset(ctx, db)
+		if er != nil {
+			return er
 		}
+		return recordAudit(ctx, info.Operator, AuditCreate, AuditAsset, tmp.UID, nil, tmp.auditData())
+	})
+	if err == nil {
+		go inspectAsset(tmp)
 		return tmp, nil
 	}
 	return nil, err
@@ -297,34 +310,64 @@ func (mine *cacheContext) UpdateAssetsStatus(arr []string, st uint32, operator s
 	if arr == nil {
 		return nil
 	}
+	failed := make([]string, 0, 2)
 	for _, uid := range arr {
-		_ = nosql.UpdateAssetStatus(uid, operator, uint8(st))
+		info := mine.GetAsset(uid)
+		if info == nil {
+			failed = append(failed, uid+": not found")
+			continue
+		}
+		er := info.UpdateStatus(uint8(st), operator, RevisionAny)
+		if er != nil {
+			failed = append(failed, uid+": "+er.Error())
+		}
 	}
-	return nil
+	return batchError(failed, len(arr))
 }
 
 func (mine *cacheContext) PublishAssetsEntity(entity, operator string) error {
 	if entity == "" {
 		return errors.New("the entity is empty")
 	}
-	assets, _ := nosql.GetAssetsByOwner(entity)
+	assets, err := nosql.GetAssetsByOwner(entity)
+	if err != nil {
+		return err
+	}
+	failed := make([]string, 0, 2)
 	for _, asset := range assets {
 		if asset.Status != StatusPublish {
-			_ = nosql.UpdateAssetStatus(asset.UID.Hex(), operator, StatusVisible)
+			er := commitUpdate(func(ctx context.Context) error {
+				return nosql.UpdateAssetStatus(ctx, asset.UID.Hex(), operator, StatusVisible, RevisionAny)
+			}, operator, AuditAsset, asset.UID.Hex(), "status", asset.Status, StatusVisible)
+			if er == nil {
+			} else {
+				failed = append(failed, asset.UID.Hex()+": "+er.Error())
+			}
 		}
 	}
-	return nil
+	return batchError(failed, len(assets))
 }
 
 func (mine *cacheContext) BatchUpdateScope(list []string) error {
+	failed := make([]string, 0, 2)
+	total := 0
 	for _, owner := range list {
-		assets, _ := nosql.GetAssetsByOwner(owner)
+		assets, err := nosql.GetAssetsByOwner(owner)
+		if err != nil {
+			failed = append(failed, owner+": "+err.Error())
+			continue
+		}
+		total += len(assets)
 		for _, asset := range assets {
-			_ = nosql.UpdateAssetScope(asset.UID.Hex(), asset.Operator, uint8(AssetScopeOrg))
+			info := new(AssetInfo)
+			info.initInfo(asset)
+			er := info.UpdateScope(uint8(AssetScopeOrg), asset.Operator)
+			if er != nil {
+				failed = append(failed, asset.UID.Hex()+": "+er.Error())
+			}
 		}
 	}
-
-	return nil
+	return batchError(failed, total)
 }
 
 func (mine *cacheContext) GetAssetsByRegex(key string, from, to int64) []*AssetInfo {
@@ -362,7 +405,7 @@ func (mine *cacheContext) GetAssetsByException(page, number uint32) (uint32, uin
 	}
 	list := make([]*AssetInfo, 0, len(array))
 	for _, asset := range array {
-		if asset.Creator == asset.Owner {
+		if asset.Creator == asset.Owner && !asset.Quarantine {
 			info := new(AssetInfo)
 			info.initInfo(asset)
 			list = append(list, info)
@@ -392,6 +435,7 @@ func (mine *AssetInfo) initInfo(db *nosql.Asset) {
 	mine.Updated = db.Updated
 	mine.Creator = db.Creator
 	mine.Operator = db.Operator
+	mine.Revision = db.Revision
 	mine.Name = db.Name
 	mine.Remark = db.Remark
 	mine.Meta = db.Meta
@@ -405,6 +449,7 @@ func (mine *AssetInfo) initInfo(db *nosql.Asset) {
 	mine.Version = db.Version
 	mine.MD5 = db.MD5
 	mine.Format = db.Format
+	mine.Mime = db.Mime
 	mine.Language = db.Language
 	mine.Snapshot = db.Snapshot
 	mine.Small = db.Small
@@ -417,6 +462,9 @@ func (mine *AssetInfo) initInfo(db *nosql.Asset) {
 	mine.Links = db.Links
 	mine.Tags = db.Tags
 	mine.Code = db.Code
+	mine.Scan = db.Scan
+	mine.Virus = db.Virus
+	mine.Quarantine = db.Quarantine
 
 	//if mine.Code == BD_Conclusion {
 	//	if mine.GetThumbCount() > 0 {
@@ -458,11 +506,24 @@ func (mine *AssetInfo) Remove(operator string) error {
 	if mine.Type == AssetTypePortrait || mine.Type == AssetTypeIcon {
 		return errors.New("the asset of type can not remove")
 	}
-	err := mine.ToRecycle(operator)
-	if err == nil {
-		_ = nosql.RemoveAsset(mine.UID)
-		_ = nosql.RemoveThumbsByAsset(mine.UID, operator)
+	db, err := nosql.GetAsset(mine.UID)
+	if err != nil {
+		return err
 	}
+	//回收站记录、删除资源和人脸要么全部完成，要么全部撤销
+	_, err = nosql.RemoveAssetWithThumbs(mine.recycleData(operator), db, operator, func(ctx context.Context, thumbs []*nosql.Thumb) error {
+		er := recordAudit(ctx, operator, AuditRemove, AuditAsset, mine.UID, mine.auditData(), nil)
+		if er != nil {
+			return er
+		}
+		for _, thumb := range thumbs {
+			er = recordAudit(ctx, operator, AuditRemove, AuditThumb, thumb.UID.Hex(), map[string]interface{}{"asset": thumb.Asset, "user": thumb.User}, nil)
+			if er != nil {
+				return er
+			}
+		}
+		return nil
+	})
 	return err
 }
 
@@ -478,6 +539,9 @@ func (mine *AssetInfo) SupportFace() bool {
 	if mine.Type > AssetTypeWindowModel {
 		return false
 	}
+	if len(mine.Mime) > 0 {
+		return mine.Mime == "image/png" || mine.Mime == "image/jpeg" || mine.Mime == "image/bmp"
+	}
 	arr := []string{"png", "jpg", "jpeg", "bmp"}
 	for _, s := range arr {
 		format := strings.ToLower(mine.Format)
@@ -489,6 +553,10 @@ func (mine *AssetInfo) SupportFace() bool {
 }
 
 func (mine *AssetInfo) ToRecycle(operator string) error {
+	return nosql.CreateRecycle(mine.recycleData(operator))
+}
+
+func (mine *AssetInfo) recycleData(operator string) *nosql.Recycle {
 	db := new(nosql.Recycle)
 	db.UID = primitive.NewObjectID()
 	db.ID = nosql.GetRecycleNextID()
@@ -515,11 +583,13 @@ func (mine *AssetInfo) ToRecycle(operator string) error {
 	db.Status = mine.Status
 	db.Quote = mine.Quote
 	db.Links = mine.Links
-	return nosql.CreateRecycle(db)
+	return db
 }
 
 func (mine *AssetInfo) UpdateSnapshot(operator, snapshot string) error {
-	err := nosql.UpdateAssetSnapshot(mine.UID, snapshot, operator)
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetSnapshot(ctx, mine.UID, snapshot, operator)
+	}, operator, AuditAsset, mine.UID, "snapshot", mine.Snapshot, snapshot)
 	if err == nil {
 		mine.Snapshot = snapshot
 	}
@@ -527,7 +597,9 @@ func (mine *AssetInfo) UpdateSnapshot(operator, snapshot string) error {
 }
 
 func (mine *AssetInfo) UpdateSmall(operator, small string) error {
-	err := nosql.UpdateAssetSmall(mine.UID, small, operator)
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetSmall(ctx, mine.UID, small, operator)
+	}, operator, AuditAsset, mine.UID, "small", mine.Small, small)
 	if err == nil {
 		mine.Small = small
 		mine.Operator = operator
@@ -535,8 +607,15 @@ func (mine *AssetInfo) UpdateSmall(operator, small string) error {
 	return err
 }
 
-func (mine *AssetInfo) UpdateBase(operator, name, remark string) error {
-	err := nosql.UpdateAssetBase(mine.UID, name, remark, operator)
+//revision为预期的修订号，小于0时不检查
+func (mine *AssetInfo) UpdateBase(operator, name, remark string, revision int64) error {
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateAssetBase(ctx, mine.UID, name, remark, operator, revision)
+		if er != nil {
+			return er
+		}
+		return recordAudit(ctx, operator, "update_base", AuditAsset, mine.UID, map[string]interface{}{"name": mine.Name, "remark": mine.Remark}, map[string]interface{}{"name": name, "remark": remark})
+	})
 	if err == nil {
 		mine.Name = name
 		mine.Remark = remark
@@ -546,7 +625,9 @@ func (mine *AssetInfo) UpdateBase(operator, name, remark string) error {
 }
 
 func (mine *AssetInfo) UpdateMeta(operator, meta string) error {
-	err := nosql.UpdateAssetMeta(mine.UID, meta, operator)
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetMeta(ctx, mine.UID, meta, operator)
+	}, operator, AuditAsset, mine.UID, "meta", mine.Meta, meta)
 	if err == nil {
 		mine.Meta = meta
 		mine.Operator = operator
@@ -555,7 +636,9 @@ func (mine *AssetInfo) UpdateMeta(operator, meta string) error {
 }
 
 func (mine *AssetInfo) UpdateWeight(weight uint32, operator string) error {
-	err := nosql.UpdateAssetWeight(mine.UID, operator, weight)
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetWeight(ctx, mine.UID, operator, weight)
+	}, operator, AuditAsset, mine.UID, "weight", mine.Weight, weight)
 	if err == nil {
 		mine.Weight = weight
 		mine.Operator = operator
@@ -563,8 +646,10 @@ func (mine *AssetInfo) UpdateWeight(weight uint32, operator string) error {
 	return err
 }
 
-func (mine *AssetInfo) UpdateStatus(st uint8, operator string) error {
-	err := nosql.UpdateAssetStatus(mine.UID, operator, st)
+func (mine *AssetInfo) UpdateStatus(st uint8, operator string, revision int64) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetStatus(ctx, mine.UID, operator, st, revision)
+	}, operator, AuditAsset, mine.UID, "status", mine.Status, st)
 	if err == nil {
 		mine.Status = st
 		mine.Operator = operator
@@ -572,8 +657,10 @@ func (mine *AssetInfo) UpdateStatus(st uint8, operator string) error {
 	return err
 }
 
-func (mine *AssetInfo) UpdateLinks(operator string, links []string) error {
-	err := nosql.UpdateAssetLinks(mine.UID, operator, links)
+func (mine *AssetInfo) UpdateLinks(operator string, links []string, revision int64) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetLinks(ctx, mine.UID, operator, links, revision)
+	}, operator, AuditAsset, mine.UID, "links", mine.Links, links)
 	if err == nil {
 		mine.Links = links
 		mine.Operator = operator
@@ -581,8 +668,10 @@ func (mine *AssetInfo) UpdateLinks(operator string, links []string) error {
 	return err
 }
 
-func (mine *AssetInfo) UpdateType(st uint8, operator string) error {
-	err := nosql.UpdateAssetType(mine.UID, operator, st)
+func (mine *AssetInfo) UpdateType(st uint8, operator string, revision int64) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetType(ctx, mine.UID, operator, st, revision)
+	}, operator, AuditAsset, mine.UID, "type", mine.Type, st)
 	if err == nil {
 		mine.Type = st
 		mine.Operator = operator
@@ -590,8 +679,10 @@ func (mine *AssetInfo) UpdateType(st uint8, operator string) error {
 	return err
 }
 
-func (mine *AssetInfo) UpdateTags(operator string, tags []string) error {
-	err := nosql.UpdateAssetTags(mine.UID, operator, tags)
+func (mine *AssetInfo) UpdateTags(operator string, tags []string, revision int64) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetTags(ctx, mine.UID, operator, tags, revision)
+	}, operator, AuditAsset, mine.UID, "tags", mine.Tags, tags)
 	if err == nil {
 		mine.Tags = tags
 		mine.Operator = operator
@@ -599,17 +690,74 @@ func (mine *AssetInfo) UpdateTags(operator string, tags []string) error {
 	return err
 }
 
-func (mine *AssetInfo) UpdateOwner(operator, owner string) error {
-	err := nosql.UpdateAssetOwner(mine.UID, owner, operator)
+//只修改资源的所有者，不转移人脸
+func updateAssetOwner(uid, before, owner, operator string) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetOwner(ctx, uid, owner, operator)
+	}, operator, AuditAsset, uid, "owner", before, owner)
 	if err == nil {
+		ownerChanged(uid, before, owner, operator)
+	}
+	return err
+}
+
+//修改所有者的地方（转移、匹配、绑定实体、删除生物特征数据）都要调用，机构范围的资源的人脸库跟着改变
+func ownerChanged(uid, before, owner, operator string) {
+	if before == owner {
+		return
+	}
+	db, err := nosql.GetAsset(uid)
+	if err != nil || db.Scope != AssetScopeOrg {
+		return
+	}
+	info := new(AssetInfo)
+	info.initInfo(db)
+	info.migrateFaces(operator)
+}
+
+//资源和属于原所有者的人脸一起转移
+func (mine *AssetInfo) UpdateOwner(operator, owner string, revision int64) error {
+	_, err := nosql.TransferAssetOwner(mine.UID, mine.Owner, owner, operator, revision, func(ctx context.Context, thumbs []string) error {
+		er := recordUpdate(ctx, operator, AuditAsset, mine.UID, "owner", mine.Owner, owner)
+		if er != nil {
+			return er
+		}
+		for _, uid := range thumbs {
+			er = recordUpdate(ctx, operator, AuditThumb, uid, "owner", mine.Owner, owner)
+			if er != nil {
+				return er
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		before := mine.Owner
 		mine.Owner = owner
 		mine.Operator = operator
+		ownerChanged(mine.UID, before, owner, operator)
+	}
+	return err
+}
+
+func (mine *AssetInfo) UpdateScope(scope uint8, operator string) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetScope(ctx, mine.UID, operator, scope)
+	}, operator, AuditAsset, mine.UID, "scope", mine.Scope, scope)
+	if err == nil {
+		group := mine.CheckFaceGroup()
+		mine.Scope = scope
+		mine.Operator = operator
+		if group != mine.CheckFaceGroup() {
+			mine.migrateFaces(operator)
+		}
 	}
 	return err
 }
 
-func (mine *AssetInfo) UpdateQuote(operator, quote string) error {
-	err := nosql.UpdateAssetQuote(mine.UID, quote, operator)
+func (mine *AssetInfo) UpdateQuote(operator, quote string, revision int64) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetQuote(ctx, mine.UID, quote, operator, revision)
+	}, operator, AuditAsset, mine.UID, "quote", mine.Quote, quote)
 	if err == nil {
 		mine.Quote = quote
 		mine.Operator = operator
@@ -617,8 +765,10 @@ func (mine *AssetInfo) UpdateQuote(operator, quote string) error {
 	return err
 }
 
-func (mine *AssetInfo) UpdateLanguage(lan, operator string) error {
-	err := nosql.UpdateAssetLanguage(mine.UID, operator, lan)
+func (mine *AssetInfo) UpdateLanguage(lan, operator string, revision int64) error {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetLanguage(ctx, mine.UID, operator, lan, revision)
+	}, operator, AuditAsset, mine.UID, "language", mine.Language, lan)
 	if err == nil {
 		mine.Language = lan
 		mine.Operator = operator
@@ -650,10 +800,16 @@ func GetURL(key string, cdn bool) string {
 }
 
 func (mine *AssetInfo) URL() string {
+	if mine.Quarantine {
+		return ""
+	}
 	return GetURL(mine.UUID, true)
 }
 
 func (mine *AssetInfo) SourceURL() string {
+	if mine.Quarantine {
+		return ""
+	}
 	if len(mine.Small) > 0 {
 		return GetURL(mine.Small, false)
 	}
@@ -664,10 +820,16 @@ func (mine *AssetInfo) SourceURL() string {
 }
 
 func (mine *AssetInfo) SnapshotURL() string {
+	if mine.Quarantine {
+		return ""
+	}
 	return GetURL(mine.Snapshot, true)
 }
 
 func (mine *AssetInfo) SmallImageURL() string {
+	if mine.Quarantine {
+		return ""
+	}
 	return GetURL(mine.Small, false)
 }
 
@@ -708,10 +870,15 @@ func (mine *AssetInfo) hadThumb(uid string) bool {
 }
 
 func (mine *AssetInfo) RemoveThumb(uid, operator string) error {
-	if !mine.hadThumb(uid) {
+	info := mine.GetThumb(uid)
+	if info == nil {
 		return nil
 	}
-	return nosql.RemoveThumb(uid, operator)
+	err := nosql.RemoveThumb(uid, operator)
+	if err == nil {
+		writeAudit(operator, AuditRemove, AuditThumb, uid, info.auditData(), nil)
+	}
+	return err
 }
 
 func (mine *AssetInfo) CreateThumb(file, operator, owner string, score, similar, blur float32) (*ThumbInfo, error) {
@@ -727,11 +894,17 @@ func (mine *AssetInfo) CreateThumb(file, operator, owner string, score, similar,
 	db.Owner = owner
 	db.Probably = score
 	db.Similar = similar
-	err := nosql.CreateThumb(db)
-	if err == nil {
-		info := new(ThumbInfo)
-		info.initInfo(db)
-		return info, nil
+	info := new(ThumbInfo)
+	info.initInfo(db)
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.CreateThumb(ctx, db)
+		if er != nil {
+			return er
+		}
+		return recordAudit(ctx, operator, AuditCreate, AuditThumb, info.UID, nil, info.auditData())
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return info, nil
 }
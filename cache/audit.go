@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"math"
+	"omo.msa.asset/proxy/nosql"
+	"reflect"
+	"time"
+)
+
+const (
+	AuditAsset   = "asset"
+	AuditThumb   = "thumb"
+	AuditFolder  = "folder"
+	AuditLabel   = "label"
+	AuditRecycle = "recycle"
+	AuditWebhook = "webhook"
+	AuditPerson  = "person"
+	AuditReview  = "review"
+	//删除生物特征数据的回执
+	AuditErasure = "erasure"
+	AuditConsent = "consent"
+	AuditGroup   = "group"
+)
+
+const (
+	AuditCreate = "create"
+	AuditRemove = "remove"
+)
+
+//系统内部流程（检测、扫描等）的操作者
+const AuditSystem = "system"
+
+type AuditInfo struct {
+	UID     string                 `json:"uid"`
+	Created int64                  `json:"created"`
+	Actor   string                 `json:"actor"`
+	Action  string                 `json:"action"`
+	Kind    string                 `json:"kind"`
+	Target  string                 `json:"target"`
+	Before  map[string]interface{} `json:"before,omitempty"`
+	After   map[string]interface{} `json:"after,omitempty"`
+}
+
+//记录一次写操作，审计记录和对应的事件一起提交
+func writeAudit(actor, action, kind, target string, before, after map[string]interface{}) {
+	err := commitWrite(func(ctx context.Context) error {
+		return recordAudit(ctx, actor, action, kind, target, before, after)
+	})
+	if err != nil {
+		logger.Warn("write audit failed that target = " + target + " and msg = " + err.Error())
+	}
+}
+
+//单个字段的修改
+func auditUpdate(actor, kind, target, field string, before, after interface{}) {
+	writeAudit(actor, "update_"+field, kind, target, map[string]interface{}{field: before}, map[string]interface{}{field: after})
+}
+
+//在一个事务里执行写操作，写操作里通过recordAudit写入审计记录和对应的事件，任何一步失败都不生效；
+//单机部署不支持事务时依次执行
+func commitWrite(fn func(ctx context.Context) error) error {
+	return nosql.RunWrite(fn)
+}
+
+//修改一个字段，审计记录和事件在同一个事务里提交
+func commitUpdate(write func(ctx context.Context) error, actor, kind, target, field string, before, after interface{}) error {
+	return commitWrite(func(ctx context.Context) error {
+		err := write(ctx)
+		if err != nil {
+			return err
+		}
+		return recordUpdate(ctx, actor, kind, target, field, before, after)
+	})
+}
+
+//在事务里写入审计记录和对应的事件，before和after只保留有变化的字段
+func recordAudit(ctx context.Context, actor, action, kind, target string, before, after map[string]interface{}) error {
+	if before != nil && after != nil {
+		for key, val := range before {
+			if reflect.DeepEqual(val, after[key]) {
+				delete(before, key)
+				delete(after, key)
+			}
+		}
+		if len(before) < 1 && len(after) < 1 {
+			return nil
+		}
+	}
+	db := new(nosql.Audit)
+	db.UID = primitive.NewObjectID()
+	db.Created = time.Now().Unix()
+	db.Actor = actor
+	db.Action = action
+	db.Kind = kind
+	db.Target = target
+	db.Before = before
+	db.After = after
+	err := nosql.CreateAudit(ctx, db)
+	if err != nil {
+		return err
+	}
+	return stageAuditEvent(ctx, db)
+}
+
+func recordUpdate(ctx context.Context, actor, kind, target, field string, before, after interface{}) error {
+	return recordAudit(ctx, actor, "update_"+field, kind, target, map[string]interface{}{field: before}, map[string]interface{}{field: after})
+}
+
+func (mine *AuditInfo) initInfo(db *nosql.Audit) {
+	mine.UID = db.UID.Hex()
+	mine.Created = db.Created
+	mine.Actor = db.Actor
+	mine.Action = db.Action
+	mine.Kind = db.Kind
+	mine.Target = db.Target
+	mine.Before = db.Before
+	mine.After = db.After
+}
+
+func (mine *cacheContext) GetAudits(target, actor string, from, to int64, page, num uint32) (uint32, uint32, []*AuditInfo) {
+	start, number := getPageStart(page, num)
+	total := nosql.GetAuditsCount(target, actor, from, to)
+	pages := math.Ceil(float64(total) / float64(number))
+	dbs, err := nosql.GetAudits(target, actor, from, to, int64(start), int64(number))
+	if err != nil {
+		return 0, 0, make([]*AuditInfo, 0, 1)
+	}
+	list := make([]*AuditInfo, 0, len(dbs))
+	for _, db := range dbs {
+		info := new(AuditInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	return uint32(total), uint32(pages), list
+}
+
+//以NDJSON（每行一条JSON）的格式导出审计记录
+func (mine *cacheContext) ExportAudits(w io.Writer, target, actor string, from, to int64) error {
+	encoder := json.NewEncoder(w)
+	return nosql.EachAudits(target, actor, from, to, func(db *nosql.Audit) error {
+		info := new(AuditInfo)
+		info.initInfo(db)
+		return encoder.Encode(info)
+	})
+}
+
+func (mine *AssetInfo) auditData() map[string]interface{} {
+	return map[string]interface{}{
+		"name":     mine.Name,
+		"owner":    mine.Owner,
+		"creator":  mine.Creator,
+		"uuid":     mine.UUID,
+		"type":     mine.Type,
+		"scope":    mine.Scope,
+		"status":   mine.Status,
+		"quote":    mine.Quote,
+		"format":   mine.Format,
+		"md5":      mine.MD5,
+		"snapshot": mine.Snapshot,
+		"small":    mine.Small,
+		"links":    mine.Links,
+		"tags":     mine.Tags,
+		"remark":   mine.Remark,
+		"meta":     mine.Meta,
+		"language": mine.Language,
+		"weight":   mine.Weight,
+	}
+}
+
+func (mine *ThumbInfo) auditData() map[string]interface{} {
+	return map[string]interface{}{
+		"asset":   mine.Asset,
+		"owner":   mine.Owner,
+		"user":    mine.User,
+		"quote":   mine.Quote,
+		"file":    mine.File,
+		"similar": mine.Similar,
+		"meta":    mine.Meta,
+	}
+}
+
+func (mine *FolderInfo) auditData() map[string]interface{} {
+	return map[string]interface{}{
+		"name":   mine.Name,
+		"scene":  mine.Scene,
+		"parent": mine.Parent,
+		"cover":  mine.Cover,
+		"access": mine.Access,
+		"users":  mine.Users,
+		"remark": mine.Remark,
+		"tags":   mine.Tags,
+	}
+}
+
+func (mine *LabelInfo) auditData() map[string]interface{} {
+	return map[string]interface{}{
+		"name":   mine.Name,
+		"remark": mine.Remark,
+		"scene":  mine.Scene,
+		"type":   mine.Type,
+	}
+}
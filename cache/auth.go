@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/micro/go-micro/v2/logger"
+	"omo.msa.asset/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//请求头（metadata）里面的认证信息
+const (
+	HeaderAuthorization = "Authorization"
+	HeaderService       = "X-Service"
+	HeaderTimestamp     = "X-Timestamp"
+	HeaderSignature     = "X-Signature"
+	HeaderUser          = "X-User"
+	HeaderScenes        = "X-Scenes"
+	HeaderRoles         = "X-Roles"
+)
+
+type authClaims struct {
+	jwt.RegisteredClaims
+	Scenes []string `json:"scenes"`
+	Roles  []string `json:"roles"`
+}
+
+type authKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+var (
+	authOnce sync.Once
+	authKeys []*authKey
+)
+
+func AuthEnable() bool {
+	return config.Schema.Auth.Enable
+}
+
+func loadAuthKeys() {
+	authKeys = make([]*authKey, 0, len(config.Schema.Auth.Keys))
+	for _, item := range config.Schema.Auth.Keys {
+		tmp := &authKey{kid: item.Kid}
+		var err error
+		switch strings.ToUpper(item.Alg) {
+		case "HS256", "":
+			tmp.method = jwt.SigningMethodHS256
+			tmp.key = []byte(item.Key)
+		case "RS256":
+			tmp.method = jwt.SigningMethodRS256
+			tmp.key, err = jwt.ParseRSAPublicKeyFromPEM([]byte(item.Key))
+		case "ES256":
+			tmp.method = jwt.SigningMethodES256
+			tmp.key, err = jwt.ParseECPublicKeyFromPEM([]byte(item.Key))
+		default:
+			err = errors.New("not support the alg of " + item.Alg)
+		}
+		if err != nil {
+			logger.Warn("load the auth key(" + item.Kid + ") failed that msg = " + err.Error())
+			continue
+		}
+		authKeys = append(authKeys, tmp)
+	}
+}
+
+//从请求头里认证调用者，支持Bearer JWT和内部服务的HMAC签名
+func Authenticate(get func(key string) string, method string) (*Principal, error) {
+	if len(get(HeaderSignature)) > 0 {
+		return authService(get, method)
+	}
+	token := strings.TrimSpace(get(HeaderAuthorization))
+	if len(token) < 1 {
+		return nil, errors.New("the caller is unauthenticated")
+	}
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	return authToken(token)
+}
+
+func authToken(token string) (*Principal, error) {
+	authOnce.Do(loadAuthKeys)
+	claims := new(authClaims)
+	_, err := jwt.ParseWithClaims(token, claims, func(tk *jwt.Token) (interface{}, error) {
+		kid, _ := tk.Header["kid"].(string)
+		for _, item := range authKeys {
+			if item.method.Alg() != tk.Method.Alg() {
+				continue
+			}
+			if len(kid) < 1 || item.kid == kid {
+				return item.key, nil
+			}
+		}
+		return nil, fmt.Errorf("not found the key of alg(%s) and kid(%s)", tk.Method.Alg(), kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	//没有过期时间的令牌一直有效，不接受
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("the token has no expiration")
+	}
+	issuer := config.Schema.Auth.Issuer
+	if len(issuer) > 0 && !claims.VerifyIssuer(issuer, true) {
+		return nil, errors.New("the token issuer is invalid")
+	}
+	if len(claims.Subject) < 1 {
+		return nil, errors.New("the token subject is empty")
+	}
+	return NewPrincipal(claims.Subject, claims.Scenes, claims.Roles), nil
+}
+
+//签名内容为 service + "\n" + timestamp + "\n" + method + "\n" + user + "\n" + scenes，使用HMAC-SHA256，
+//代表的用户和场景也要签名，避免截获签名后冒充其他用户
+func authService(get func(key string) string, method string) (*Principal, error) {
+	name := get(HeaderService)
+	var service *config.AuthService
+	for i := 0; i < len(config.Schema.Auth.Services); i += 1 {
+		if config.Schema.Auth.Services[i].Name == name {
+			service = &config.Schema.Auth.Services[i]
+			break
+		}
+	}
+	if service == nil || len(service.Secret) < 1 {
+		return nil, errors.New("the service is unknown")
+	}
+	stamp, err := strconv.ParseInt(get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, errors.New("the timestamp is invalid")
+	}
+	skew := config.Schema.Auth.Skew
+	if skew < 1 {
+		skew = 300
+	}
+	diff := time.Now().Unix() - stamp
+	if diff > skew || diff < -skew {
+		return nil, errors.New("the timestamp is expired")
+	}
+	mac := hmac.New(sha256.New, []byte(service.Secret))
+	mac.Write([]byte(strings.Join([]string{name, get(HeaderTimestamp), method, get(HeaderUser), get(HeaderScenes)}, "\n")))
+	sign, err := hex.DecodeString(get(HeaderSignature))
+	if err != nil || !hmac.Equal(sign, mac.Sum(nil)) {
+		return nil, errors.New("the signature is invalid")
+	}
+	//内部服务可以代表用户调用
+	user := get(HeaderUser)
+	if len(user) < 1 {
+		user = name
+	}
+	roles := make([]string, 0, 1)
+	if service.Admin {
+		roles = append(roles, RoleAdmin)
+	}
+	who := NewPrincipal(user, SplitHeader(get(HeaderScenes)), roles)
+	who.Service = name
+	return who, nil
+}
+
+func SplitHeader(val string) []string {
+	list := make([]string, 0, 2)
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -29,6 +29,9 @@ func InitData() error {
 		//nosql.CheckTimes()
 	}
 	go checkFaceGroup(FaceGroupDefault)
+	if err == nil {
+		go backfillPersons()
+	}
 	return err
 }
 
@@ -0,0 +1,416 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BatchStatus    = "status"
+	BatchTagAdd    = "tag_add"
+	BatchTagRemove = "tag_remove"
+	BatchMove      = "move"
+	BatchOwner     = "owner"
+	BatchQuote     = "quote"
+	BatchScope     = "scope"
+	BatchRecycle   = "recycle"
+)
+
+//批量操作的参数不合法
+var ErrBatchInvalid = errors.New("the batch is invalid")
+
+//后台任务每处理多少个资源保存一次进度
+const batchProgressStep = 50
+
+//后台任务更新心跳的间隔
+const jobHeartbeat = 30 * time.Second
+
+//批量操作，Assets为空时按Owner和Quote选择资源
+type BatchSpec struct {
+	Op     string   `json:"op"`
+	Value  string   `json:"value"`
+	Values []string `json:"values"`
+	DryRun bool     `json:"dry_run"`
+	From   string   `json:"from"`
+	Owner  string   `json:"owner"`
+	Quote  string   `json:"quote"`
+	Assets []string `json:"-"`
+}
+
+//Job不为空时表示记录为任务，结果通过GetBatchJob查询
+type BatchReport struct {
+	Job     string
+	Creator string
+	Error   string
+	Status  uint8
+	Total   uint32
+	Done    uint32
+	Failed  uint32
+	Results []nosql.BatchResult
+}
+
+//执行前需要准备好的目标，避免对每个资源重复读取
+type batchTarget struct {
+	status uint8
+	scope  uint8
+	folder *FolderInfo
+	from   *FolderInfo
+}
+
+//合并多个资源的失败原因
+func batchError(failed []string, total int) error {
+	if len(failed) < 1 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d assets failed: %s", len(failed), total, strings.Join(failed, "; "))
+}
+
+func batchLimit() int {
+	if config.Schema.Batch.Limit < 1 {
+		return 200
+	}
+	return config.Schema.Batch.Limit
+}
+
+func batchStale() int64 {
+	if config.Schema.Batch.Stale < 1 {
+		return 300
+	}
+	return config.Schema.Batch.Stale
+}
+
+//定时执行beat，直到调用返回的stop
+func startHeartbeat(beat func()) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				beat()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+//进程退出时执行中的任务不会再更新心跳，定时把心跳超时的任务标记为失败
+func StartBatches() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			failStaleBatches()
+			<-ticker.C
+		}
+	}()
+}
+
+func failStaleBatches() {
+	num, err := nosql.FailStaleBatches(time.Now().Unix()-batchStale(), "the batch job is stale")
+	if err != nil {
+		logger.Warn("fail the stale batch jobs failed that msg = " + err.Error())
+	} else if num > 0 {
+		logger.Warnf("mark %d stale batch jobs as failed", num)
+	}
+}
+
+func batchMax() int {
+	if config.Schema.Batch.Max < 1 {
+		return 10000
+	}
+	return config.Schema.Batch.Max
+}
+
+//校验参数和目标的权限，然后对每个资源执行操作；dry_run只检查不修改，直接返回结果；
+//其他的操作都记录为任务，资源数量超过限制时在后台执行，结果通过GetBatchJob查询
+func (mine *cacheContext) RunBatch(who *Principal, operator string, spec *BatchSpec) (*BatchReport, error) {
+	target, err := mine.prepareBatch(who, spec)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := mine.selectBatchAssets(spec)
+	if err != nil {
+		return nil, err
+	}
+	report := &BatchReport{Total: uint32(len(assets)), Status: nosql.BatchFinished}
+	if spec.DryRun {
+		report.Results = make([]nosql.BatchResult, 0, len(assets))
+		for _, uid := range assets {
+			result := mine.applyBatch(who, operator, spec, target, uid)
+			report.Done += 1
+			if !result.OK {
+				report.Failed += 1
+			}
+			report.Results = append(report.Results, result)
+		}
+		return report, nil
+	}
+	db := new(nosql.Batch)
+	db.UID = primitive.NewObjectID()
+	db.Created = time.Now().Unix()
+	db.Updated = db.Created
+	db.Creator = operator
+	db.Operation = spec.Op
+	bts, _ := json.Marshal(spec)
+	db.Spec = string(bts)
+	db.Status = nosql.BatchRunning
+	db.Total = report.Total
+	db.Results = make([]nosql.BatchResult, 0, 1)
+	err = nosql.CreateBatch(db)
+	if err != nil {
+		return nil, err
+	}
+	report.Job = db.UID.Hex()
+	if len(assets) <= batchLimit() {
+		mine.runBatchJob(who, operator, spec, target, report.Job, assets)
+		return mine.GetBatchJob(report.Job)
+	}
+	report.Status = nosql.BatchRunning
+	go mine.runBatchJob(who, operator, spec, target, report.Job, assets)
+	return report, nil
+}
+
+func (mine *cacheContext) runBatchJob(who *Principal, operator string, spec *BatchSpec, target *batchTarget, job string, assets []string) {
+	stop := startHeartbeat(func() {
+		_ = nosql.UpdateBatchHeartbeat(job)
+	})
+	defer stop()
+	results := make([]nosql.BatchResult, 0, len(assets))
+	var done, failed uint32
+	for i, uid := range assets {
+		result := mine.applyBatch(who, operator, spec, target, uid)
+		done += 1
+		if !result.OK {
+			failed += 1
+		}
+		results = append(results, result)
+		if (i+1)%batchProgressStep == 0 && i+1 < len(assets) {
+			_ = nosql.UpdateBatchProgress(job, nosql.BatchRunning, done, failed, results)
+		}
+	}
+	_ = nosql.UpdateBatchProgress(job, nosql.BatchFinished, done, failed, results)
+}
+
+func (mine *cacheContext) GetBatchJob(uid string) (*BatchReport, error) {
+	db, err := nosql.GetBatch(uid)
+	if err != nil {
+		return nil, err
+	}
+	report := &BatchReport{
+		Creator: db.Creator,
+		Error:   db.Error,
+		Job:     db.UID.Hex(),
+		Status:  db.Status,
+		Total:   db.Total,
+		Done:    db.Done,
+		Failed:  db.Failed,
+		Results: db.Results,
+	}
+	//查询时心跳已经超时的任务直接返回失败，不等待定时任务标记
+	if db.Status == nosql.BatchRunning && db.Updated < time.Now().Unix()-batchStale() {
+		report.Status = nosql.BatchFailed
+		report.Error = "the batch job is stale"
+	}
+	return report, nil
+}
+
+func (mine *cacheContext) prepareBatch(who *Principal, spec *BatchSpec) (*batchTarget, error) {
+	target := new(batchTarget)
+	switch spec.Op {
+	case BatchStatus:
+		st, err := parseBatchUint8(spec.Value, StatusVisible)
+		if err != nil {
+			return nil, err
+		}
+		target.status = st
+	case BatchScope:
+		st, err := parseBatchUint8(spec.Value, AssetScopeSystem)
+		if err != nil {
+			return nil, err
+		}
+		if !who.CanManage() {
+			return nil, errors.New("the operator has no permission")
+		}
+		target.scope = st
+	case BatchTagAdd, BatchTagRemove:
+		if len(spec.Values) < 1 {
+			return nil, fmt.Errorf("%w that the tags is empty", ErrBatchInvalid)
+		}
+	case BatchOwner:
+		if len(spec.Value) < 1 {
+			return nil, fmt.Errorf("%w that the owner is empty", ErrBatchInvalid)
+		}
+		if !who.CanScene(spec.Value, ActionWrite) {
+			return nil, errors.New("the operator has no permission")
+		}
+	case BatchQuote, BatchRecycle:
+	case BatchMove:
+		folder, err := mine.GetFolder(spec.Value)
+		if err != nil || folder == nil {
+			return nil, fmt.Errorf("%w that the folder not found", ErrBatchInvalid)
+		}
+		if !who.CanFolder(folder, ActionWrite) {
+			return nil, errors.New("the operator has no permission")
+		}
+		target.folder = folder
+		if len(spec.From) > 0 {
+			from, err := mine.GetFolder(spec.From)
+			if err != nil || from == nil {
+				return nil, fmt.Errorf("%w that the source folder not found", ErrBatchInvalid)
+			}
+			if !who.CanFolder(from, ActionWrite) {
+				return nil, errors.New("the operator has no permission")
+			}
+			target.from = from
+		}
+	default:
+		return nil, fmt.Errorf("%w that the op(%s) is not supported", ErrBatchInvalid, spec.Op)
+	}
+	return target, nil
+}
+
+func parseBatchUint8(val string, max uint8) (uint8, error) {
+	num, err := strconv.ParseUint(strings.TrimSpace(val), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w that the value need an unsigned integer", ErrBatchInvalid)
+	}
+	if num > uint64(max) {
+		return 0, fmt.Errorf("%w that the value must not be greater than %d", ErrBatchInvalid, max)
+	}
+	return uint8(num), nil
+}
+
+//去掉重复的资源，超过最大数量时不执行
+func (mine *cacheContext) selectBatchAssets(spec *BatchSpec) ([]string, error) {
+	list := make([]string, 0, len(spec.Assets))
+	if len(spec.Assets) > 0 {
+		for _, uid := range spec.Assets {
+			if len(uid) > 0 && !tool.HasItem(list, uid) {
+				list = append(list, uid)
+			}
+		}
+	} else if len(spec.Owner) > 0 || len(spec.Quote) > 0 {
+		var array []*nosql.Asset
+		var err error
+		num := int64(batchMax() + 1)
+		if len(spec.Owner) > 0 && len(spec.Quote) > 0 {
+			array, err = nosql.GetAssetsByOwnerQuote(spec.Owner, spec.Quote, 0, num)
+		} else if len(spec.Owner) > 0 {
+			array, err = nosql.GetAssetsByOwner(spec.Owner)
+		} else {
+			array, err = nosql.GetAssetsByQuote(spec.Quote, 0, num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range array {
+			list = append(list, item.UID.Hex())
+		}
+	} else {
+		return nil, fmt.Errorf("%w that the assets is empty", ErrBatchInvalid)
+	}
+	if len(list) > batchMax() {
+		return nil, fmt.Errorf("%w that the assets is more than %d", ErrBatchInvalid, batchMax())
+	}
+	return list, nil
+}
+
+//对单个资源执行操作，DryRun时只做检查并返回预期的结果
+func (mine *cacheContext) applyBatch(who *Principal, operator string, spec *BatchSpec, target *batchTarget, uid string) nosql.BatchResult {
+	result := nosql.BatchResult{UID: uid}
+	info := mine.GetAsset(uid)
+	if info == nil {
+		result.Error = "the asset not found"
+		return result
+	}
+	if !who.CanAsset(info, ActionWrite) {
+		result.Error = "the operator has no permission"
+		return result
+	}
+	var err error
+	switch spec.Op {
+	case BatchStatus:
+		result.Before, result.After = info.Status, target.status
+		if !spec.DryRun {
+			err = info.UpdateStatus(target.status, operator, RevisionAny)
+		}
+	case BatchScope:
+		result.Before, result.After = info.Scope, target.scope
+		if !spec.DryRun {
+			err = info.UpdateScope(target.scope, operator)
+		}
+	case BatchOwner:
+		result.Before, result.After = info.Owner, spec.Value
+		if !spec.DryRun {
+			err = info.UpdateOwner(operator, spec.Value, RevisionAny)
+		}
+	case BatchQuote:
+		result.Before, result.After = info.Quote, spec.Value
+		if !spec.DryRun {
+			err = info.UpdateQuote(operator, spec.Value, RevisionAny)
+		}
+	case BatchTagAdd, BatchTagRemove:
+		tags := make([]string, 0, len(info.Tags)+len(spec.Values))
+		for _, tag := range info.Tags {
+			if spec.Op == BatchTagAdd || !tool.HasItem(spec.Values, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if spec.Op == BatchTagAdd {
+			for _, tag := range spec.Values {
+				if len(tag) > 0 && !tool.HasItem(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		result.Before, result.After = info.Tags, tags
+		if !spec.DryRun {
+			err = info.UpdateTags(operator, tags, RevisionAny)
+		}
+	case BatchMove:
+		if target.from != nil {
+			if !target.from.HadContent(uid) {
+				result.Error = "the asset not in the source folder"
+				return result
+			}
+			result.Before = target.from.UID
+		}
+		result.After = target.folder.UID
+		if !spec.DryRun {
+			err = target.folder.AppendContent(uid, info.Name, operator, RevisionAny)
+			if err == nil && target.from != nil && target.from.UID != target.folder.UID {
+				err = target.from.RemoveContent(uid, operator)
+			}
+		}
+	case BatchRecycle:
+		if info.Type == AssetTypePortrait || info.Type == AssetTypeIcon {
+			result.Error = "the asset of type can not remove"
+			return result
+		}
+		result.Before = info.Status
+		if !spec.DryRun {
+			err = info.Remove(operator)
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.OK = true
+	return result
+}
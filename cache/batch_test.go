@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseBatchUint8(t *testing.T) {
+	cases := []struct {
+		val  string
+		max  uint8
+		want uint8
+		ok   bool
+	}{
+		{"0", 2, 0, true},
+		{"2", 2, 2, true},
+		{" 1 ", 2, 1, true},
+		{"3", 2, 0, false},
+		{"300", 255, 0, false},
+		{"-1", 2, 0, false},
+		{"1.5", 2, 0, false},
+		{"2x", 2, 0, false},
+		{"", 2, 0, false},
+	}
+	for _, item := range cases {
+		got, err := parseBatchUint8(item.val, item.max)
+		if item.ok {
+			if err != nil || got != item.want {
+				t.Errorf("parseBatchUint8(%q, %d) = %d %v, want %d", item.val, item.max, got, err, item.want)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrBatchInvalid) {
+			t.Errorf("parseBatchUint8(%q, %d) error = %v, want ErrBatchInvalid", item.val, item.max, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"github.com/micro/go-micro/v2/logger"
 	"omo.msa.asset/proxy/nosql"
@@ -93,15 +94,24 @@ func (mine *cacheContext) CheckThumbs() {
 func (mine *cacheContext) CheckStatus() {
 	dbs, _ := nosql.GetAssetsByStatus(StatusPublish)
 	for _, db := range dbs {
-		_ = nosql.UpdateAssetStatus(db.UID.Hex(), db.Operator, StatusVisible)
+		publishAsset(db)
 	}
 	dbs2, _ := nosql.GetAssetsByType(AssetTypeWindowModel)
 	for _, db := range dbs2 {
-		_ = nosql.UpdateAssetStatus(db.UID.Hex(), db.Operator, StatusVisible)
+		publishAsset(db)
 	}
 	dbs3, _ := nosql.GetAssetsByType(AssetTypeAndroidModel)
 	for _, db := range dbs3 {
-		_ = nosql.UpdateAssetStatus(db.UID.Hex(), db.Operator, StatusVisible)
+		publishAsset(db)
+	}
+}
+
+func publishAsset(db *nosql.Asset) {
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetStatus(ctx, db.UID.Hex(), db.Operator, StatusVisible, RevisionAny)
+	}, AuditSystem, AuditAsset, db.UID.Hex(), "status", db.Status, StatusVisible)
+	if err != nil {
+		logger.Warn("publish asset failed that uid = " + db.UID.Hex() + " and msg = " + err.Error())
 	}
 }
 
@@ -109,7 +119,7 @@ func PublishSystemAssets() {
 	dbs, _ := nosql.GetAssetsByOwner("system")
 	for _, db := range dbs {
 		if db.Status != StatusPublish {
-			_ = nosql.UpdateAssetStatus(db.UID.Hex(), db.Operator, StatusVisible)
+			publishAsset(db)
 		}
 	}
 }
@@ -127,6 +137,10 @@ func (mine *cacheContext) addPendingAsset(asset *AssetInfo) {
 		mine.assetIndex = 0
 		mine.assets = make([]*AssetInfo, 0, 100)
 	}
+	if !asset.consented() {
+		logger.Warn(fmt.Sprintf("addPendingAsset... skip the asset uid = %s without face consent", asset.UID))
+		return
+	}
 	for _, item := range mine.assets {
 		if item.UID == asset.UID {
 			return
@@ -156,7 +170,7 @@ func (mine *cacheContext) checkPendingAsset() {
 	_, url := info.getMinURL()
 	group := info.CheckFaceGroup()
 	_ = checkFaceGroup(group)
-	er, code := checkFaces(info.UID, info.Owner, url, group, info.Quote, info.Creator)
+	er, code := checkFaces(info, url, group)
 	if er != nil {
 		if code == ErrorCodeQPSLimit {
 			mine.addPendingAsset(info)
@@ -220,17 +234,26 @@ func (mine *cacheContext) checkPendingThumb() {
 
 	logger.Warn(fmt.Sprintf("checkPendingThumb... the id = %d and uid = %s", mine.thumbIndex, info.UID))
 	mine.thumbIndex += 1
-	users, er := info.SearchUsers()
+	//没有人脸识别授权的人脸不搜索也不保存
+	var users []*UserResult
+	er := ErrNoConsent
+	if info.consented() {
+		users, er = info.SearchUsers()
+	}
 	if er != nil {
 		//fmt.Println(fmt.Sprintf("search user face (%s) from group of %s, that err = %s", info.UID, info.Group, er.Error()))
 		logger.Warn(fmt.Sprintf("search asset(%s) user face (%s) from group of %s, that err = %s", info.Asset, info.UID, info.Group, er.Error()))
 	} else {
-		if len(users) > 0 {
+		if len(users) > 0 && acceptFaceMatch(users[0]) {
 			_ = info.RegisterFace(users[0].ID, users[0].Group)
+			_ = info.save()
 		} else {
+			//不确定的匹配先注册为新的用户，等待人工确认
 			_ = info.RegisterFace(info.User, info.Group)
+			if err := info.save(); err == nil && len(users) > 0 {
+				queueReview(info, users)
+			}
 		}
-		_ = info.save()
 	}
 	time.Sleep(time.Second * 1)
 	mine.thumbIndex -= 1
@@ -0,0 +1,276 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"strconv"
+	"strings"
+)
+
+const (
+	ConsentOwner = "owner"
+	ConsentScope = "scope"
+	ConsentQuote = "quote"
+)
+
+const (
+	ConsentOptIn   = "opt_in"
+	ConsentOptOut  = "opt_out"
+	ConsentPending = "pending"
+)
+
+var ErrNoConsent = errors.New("the face recognition is not consented")
+
+//授权的种类
+var consentOrder = []string{ConsentQuote, ConsentOwner, ConsentScope}
+
+//同时匹配多个授权时，任何一个拒绝就拒绝，其次是待定，都允许时才允许
+var consentPrecedence = []string{ConsentOptOut, ConsentPending, ConsentOptIn}
+
+type ConsentInfo struct {
+	UID      string `json:"uid"`
+	Created  int64  `json:"created"`
+	Updated  int64  `json:"updated"`
+	Operator string `json:"operator"`
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	State    string `json:"state"`
+	Remark   string `json:"remark"`
+}
+
+func (mine *ConsentInfo) initInfo(db *nosql.Consent) {
+	mine.UID = db.UID.Hex()
+	mine.Created = db.Created
+	mine.Updated = db.Updated
+	mine.Operator = db.Operator
+	mine.Kind = db.Kind
+	mine.Key = db.Key
+	mine.State = db.State
+	mine.Remark = db.Remark
+}
+
+func defaultConsent() string {
+	st := config.Schema.Consent.Default
+	if st == ConsentOptOut || st == ConsentPending {
+		return st
+	}
+	return ConsentOptIn
+}
+
+//资源的人脸识别授权状态
+func consentState(owner string, scope uint8, quote string) string {
+	keys := map[string]string{ConsentScope: strconv.Itoa(int(scope))}
+	if len(owner) > 0 {
+		keys[ConsentOwner] = owner
+	}
+	if len(quote) > 0 {
+		keys[ConsentQuote] = quote
+	}
+	dbs, err := nosql.GetConsentsByKeys(keys)
+	if err != nil {
+		logger.Warn("get the consents failed that msg = " + err.Error())
+		return ConsentPending
+	}
+	return pickConsent(dbs)
+}
+
+//按照优先级选择匹配的授权状态，没有登记时使用默认的
+func pickConsent(dbs []*nosql.Consent) string {
+	states := make(map[string]bool, len(dbs))
+	for _, db := range dbs {
+		if tool.HasItem(consentOrder, db.Kind) {
+			states[db.State] = true
+		}
+	}
+	for _, st := range consentPrecedence {
+		if states[st] {
+			return st
+		}
+	}
+	return defaultConsent()
+}
+
+func (mine *AssetInfo) consented() bool {
+	return consentState(mine.Owner, mine.Scope, mine.Quote) == ConsentOptIn
+}
+
+func (mine *ThumbInfo) consented() bool {
+	asset := cacheCtx.GetAsset(mine.Asset)
+	if asset != nil {
+		return asset.consented()
+	}
+	return consentState(mine.Owner, AssetScopePersonal, mine.Quote) == ConsentOptIn
+}
+
+func (mine *cacheContext) GetConsents(kind string) ([]*ConsentInfo, error) {
+	dbs, err := nosql.GetConsentsByKind(kind)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*ConsentInfo, 0, len(dbs))
+	for _, db := range dbs {
+		info := new(ConsentInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+//修改授权，改为拒绝时在后台清除已经注册的人脸
+func (mine *cacheContext) SetConsent(kind, key, state, remark, operator string) error {
+	if !tool.HasItem(consentOrder, kind) {
+		return errors.New("the consent kind is invalid")
+	}
+	if state != ConsentOptIn && state != ConsentOptOut && state != ConsentPending {
+		return errors.New("the consent state is invalid")
+	}
+	if kind == ConsentScope {
+		if _, err := strconv.Atoi(key); err != nil {
+			return errors.New("the consent scope is invalid")
+		}
+	} else if len(key) < 1 {
+		return errors.New("the consent key is empty")
+	}
+	old, err := nosql.UpsertConsent(&nosql.Consent{Kind: kind, Key: key, State: state, Remark: remark, Operator: operator})
+	if err != nil {
+		return err
+	}
+	before := map[string]interface{}{"state": ""}
+	if old != nil {
+		before["state"] = old.State
+	}
+	writeAudit(operator, "update_state", AuditConsent, kind+":"+key, before, map[string]interface{}{"state": state})
+	if state == ConsentOptOut && (old == nil || old.State != ConsentOptOut) {
+		go func() {
+			er := cleanupConsentFaces(kind, key, operator)
+			if er != nil {
+				logger.Warn(fmt.Sprintf("clean the faces of consent(%s:%s) failed that msg = %s", kind, key, er.Error()))
+			}
+		}()
+	}
+	return nil
+}
+
+//删除拒绝授权的资源里的人脸：人脸库里的人脸、人脸记录和裁剪的图片
+func cleanupConsentFaces(kind, key, operator string) error {
+	filter := bson.M{}
+	switch kind {
+	case ConsentOwner:
+		filter["owner"] = key
+	case ConsentQuote:
+		filter["quote"] = key
+	case ConsentScope:
+		scope, _ := strconv.Atoi(key)
+		filter["scope"] = scope
+	}
+	assets := make([]string, 0, 100)
+	err := nosql.Each[nosql.Asset](nosql.TableAssets, filter, func(item *nosql.Asset) error {
+		//其他授权使资源仍然允许时保留
+		if consentState(item.Owner, item.Scope, item.Quote) == ConsentOptIn {
+			return nil
+		}
+		assets = append(assets, item.UID.Hex())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(assets) < 1 {
+		return nil
+	}
+	items := make([]*nosql.Thumb, 0, len(assets))
+	persons := make(map[[2]string]bool)
+	//人脸库的用户在这些资源里没有删除的人脸数量，以及是否有没有face_token的人脸
+	counts := make(map[[2]string]uint32)
+	untokened := make(map[[2]string]bool)
+	err = nosql.Each[nosql.Thumb](nosql.TableThumbs, bson.M{"asset": bson.M{"$in": assets}}, func(item *nosql.Thumb) error {
+		items = append(items, item)
+		if len(item.User) < 1 {
+			return nil
+		}
+		pair := [2]string{item.Group, item.User}
+		persons[pair] = true
+		if item.Deleted == 0 {
+			counts[pair] += 1
+		}
+		if len(item.Face) < 1 {
+			untokened[pair] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	errs := make([]string, 0, 1)
+	faces, files, kept := 0, 0, 0
+	//没有face_token的旧人脸无法单独从人脸库删除：用户的人脸都在这些资源里时，删除人脸库里这个用户的所有人脸，否则保留这些人脸
+	cleared := make(map[[2]string]bool)
+	for pair := range untokened {
+		if counts[pair] < nosql.GetThumbCountByPerson(pair[0], pair[1]) {
+			continue
+		}
+		face, _ := resolveFaceUser(pair[0], pair[1])
+		num, er := removeProviderUser(pair[0], face)
+		faces += num
+		if er != nil {
+			errs = append(errs, er.Error())
+			continue
+		}
+		cleared[pair] = true
+	}
+	uids := make([]string, 0, len(items))
+	for _, item := range items {
+		pair := [2]string{item.Group, item.User}
+		if len(item.User) > 0 && !cleared[pair] {
+			if len(item.Face) < 1 {
+				kept += 1
+				continue
+			}
+			face, _ := resolveFaceUser(item.Group, item.User)
+			er := removeFace(0, item.Face, face, item.Group)
+			if er != nil {
+				errs = append(errs, er.Error())
+			} else {
+				faces += 1
+			}
+		}
+		uids = append(uids, item.UID.Hex())
+		if len(item.File) > 0 && !strings.Contains(item.File, "http") {
+			if er := deleteContentFromCloud(item.File); er == nil {
+				files += 1
+			}
+		}
+	}
+	thumbs, err := nosql.DeleteThumbs(uids)
+	if err != nil {
+		return err
+	}
+	_, _ = nosql.DeleteReviewsByThumbs(uids)
+	refreshPersons(persons, operator)
+	writeAudit(operator, "cleanup", AuditConsent, kind+":"+key, nil, map[string]interface{}{
+		"assets": len(assets), "thumbs": thumbs, "faces": faces, "files": files, "kept": kept, "errors": errs})
+	logger.Infof("clean the faces of consent(%s:%s) that thumbs = %d, faces = %d", kind, key, thumbs, faces)
+	return nil
+}
+
+//删除人脸库里用户的所有人脸，返回删除的数量
+func removeProviderUser(group, user string) (int, error) {
+	tokens, err := getProviderTokens(group, user)
+	if err != nil {
+		return 0, err
+	}
+	num := 0
+	for _, token := range tokens {
+		err = removeFace(0, token, user, group)
+		if err != nil {
+			return num, err
+		}
+		num += 1
+	}
+	return num, nil
+}
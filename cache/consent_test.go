@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"testing"
+)
+
+func TestPickConsent(t *testing.T) {
+	old := config.Schema.Consent
+	defer func() {
+		config.Schema.Consent = old
+	}()
+	consent := func(kind, state string) *nosql.Consent {
+		return &nosql.Consent{Kind: kind, State: state}
+	}
+	cases := []struct {
+		name string
+		def  string
+		dbs  []*nosql.Consent
+		want string
+	}{
+		{"default", "", nil, ConsentOptIn},
+		{"default opt_out", ConsentOptOut, nil, ConsentOptOut},
+		{"opt_in", "", []*nosql.Consent{consent(ConsentOwner, ConsentOptIn), consent(ConsentScope, ConsentOptIn)}, ConsentOptIn},
+		{"scope opt_out wins quote", "", []*nosql.Consent{consent(ConsentQuote, ConsentOptIn), consent(ConsentScope, ConsentOptOut)}, ConsentOptOut},
+		{"owner opt_out wins quote", "", []*nosql.Consent{consent(ConsentQuote, ConsentOptIn), consent(ConsentOwner, ConsentOptOut)}, ConsentOptOut},
+		{"opt_out wins pending", "", []*nosql.Consent{consent(ConsentQuote, ConsentPending), consent(ConsentOwner, ConsentOptOut)}, ConsentOptOut},
+		{"pending wins opt_in", "", []*nosql.Consent{consent(ConsentScope, ConsentPending), consent(ConsentQuote, ConsentOptIn)}, ConsentPending},
+		{"registered wins default", ConsentOptOut, []*nosql.Consent{consent(ConsentOwner, ConsentOptIn)}, ConsentOptIn},
+		{"unknown kind", "", []*nosql.Consent{consent("folder", ConsentOptOut), consent(ConsentOwner, ConsentOptIn)}, ConsentOptIn},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			config.Schema.Consent.Default = item.def
+			if got := pickConsent(item.dbs); got != item.want {
+				t.Errorf("pickConsent() = %s, want %s", got, item.want)
+			}
+		})
+	}
+}
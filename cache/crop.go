@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"github.com/disintegration/imaging"
+	"github.com/micro/go-micro/v2/logger"
+	"image"
+	"image/jpeg"
+	"math"
+	"omo.msa.asset/proxy"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"strings"
+)
+
+//智能裁剪的方式
+const (
+	CropFaces    = "faces"    //包含所有人脸
+	CropFace     = "face"     //所有人脸放不下时只包含最大的人脸
+	CropSaliency = "saliency" //没有人脸时选择细节最丰富的区域
+)
+
+//生成图片的最大边长
+const cropMaxSize = 2048
+
+//计算细节丰富程度时缩小后的最大边长
+const saliencySize = 64
+
+//裁剪区域，坐标相对于资源的小图（快照）
+type CropInfo struct {
+	Asset  string `json:"asset"`
+	Mode   string `json:"mode"`
+	Faces  int    `json:"faces"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Left   int    `json:"left"`
+	Top    int    `json:"top"`
+	Right  int    `json:"right"`
+	Bottom int    `json:"bottom"`
+	Key    string `json:"key"`
+}
+
+//按照宽高比计算资源的裁剪区域，不生成图片
+func (mine *AssetInfo) SmartCrop(ratio float64) (*CropInfo, error) {
+	info, _, err := mine.smartCrop(ratio)
+	return info, err
+}
+
+//生成资源的小图：智能裁剪后缩放到指定的宽高，替换原来的小图
+func (mine *AssetInfo) CreateSmall(operator string, width, height int) (*CropInfo, error) {
+	info, err := mine.renderCrop(width, height)
+	if err != nil {
+		return nil, err
+	}
+	old := mine.Small
+	err = mine.UpdateSmall(operator, info.Key)
+	if err != nil {
+		_ = deleteContentFromCloud(info.Key)
+		return nil, err
+	}
+	removeReplacedKey(old)
+	return info, nil
+}
+
+//用资源智能裁剪后的图片作为文件夹的封面，封面为存储的key
+func (mine *FolderInfo) CreateCover(operator string, asset *AssetInfo, width, height int, revision int64) (*CropInfo, error) {
+	if asset == nil {
+		return nil, errors.New("the asset not found")
+	}
+	info, err := asset.renderCrop(width, height)
+	if err != nil {
+		return nil, err
+	}
+	old := mine.Cover
+	err = mine.UpdateCover(operator, info.Key, revision)
+	if err != nil {
+		_ = deleteContentFromCloud(info.Key)
+		return nil, err
+	}
+	removeReplacedKey(old)
+	return info, nil
+}
+
+//替换成功后删除原来的图片，其他资源、文件夹或者人脸还在使用时保留
+func removeReplacedKey(key string) {
+	if len(key) < 1 || strings.Contains(key, "http") || nosql.IsStorageReferenced(key) {
+		return
+	}
+	err := deleteContentFromCloud(key)
+	if err != nil {
+		logger.Warn("delete the replaced file(" + key + ") failed that msg = " + err.Error())
+	}
+}
+
+//裁剪并缩放后上传到存储
+func (mine *AssetInfo) renderCrop(width, height int) (*CropInfo, error) {
+	if width < 1 || height < 1 || width > cropMaxSize || height > cropMaxSize {
+		return nil, errors.New("the crop size is invalid")
+	}
+	info, img, err := mine.smartCrop(float64(width) / float64(height))
+	if err != nil {
+		return nil, err
+	}
+	rect := image.Rect(info.Left, info.Top, info.Right, info.Bottom).Add(img.Bounds().Min)
+	dst := imaging.Resize(imaging.Crop(img, rect), width, height, imaging.Lanczos)
+	buf := bytes.NewBuffer(nil)
+	err = jpeg.Encode(buf, dst, &jpeg.Options{Quality: 90})
+	if err != nil {
+		return nil, err
+	}
+	key := tool.CreateUUID()
+	_, _, err = uploadToQiNiu(key, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	info.Key = key
+	return info, nil
+}
+
+func (mine *AssetInfo) smartCrop(ratio float64) (*CropInfo, image.Image, error) {
+	if ratio <= 0 || math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+		return nil, nil, errors.New("the crop ratio is invalid")
+	}
+	//人脸的位置是在小图上检测的
+	_, url := mine.getMinURL()
+	if len(url) < 1 {
+		return nil, nil, errors.New("the asset has no image")
+	}
+	_, buf, err := downloadAsset(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := decodeImage(buf.Bytes(), 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbs, err := nosql.GetThumbsByAsset(mine.UID)
+	if err != nil {
+		return nil, nil, err
+	}
+	bounds := img.Bounds()
+	faces := make([]image.Rectangle, 0, len(dbs))
+	for _, db := range dbs {
+		if db.Location.Width < 1 || db.Location.Height < 1 {
+			continue
+		}
+		face := faceBounds(db.Location).Add(bounds.Min).Intersect(bounds)
+		if !face.Empty() {
+			faces = append(faces, face)
+		}
+	}
+	rect, mode := cropRect(img, faces, ratio)
+	rect = rect.Sub(bounds.Min)
+	info := &CropInfo{Asset: mine.UID, Mode: mode, Faces: len(faces), Width: bounds.Dx(), Height: bounds.Dy()}
+	info.Left, info.Top, info.Right, info.Bottom = rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y
+	return info, img, nil
+}
+
+//人脸框向上扩展到头发，向下扩展到下巴，左右留出耳朵
+func faceBounds(loc proxy.LocationInfo) image.Rectangle {
+	wid, hei := float64(loc.Width), float64(loc.Height)
+	left, top := float64(loc.Left), float64(loc.Top)
+	return image.Rect(int(left-wid*0.3), int(top-hei*0.6), int(math.Ceil(left+wid*1.3)), int(math.Ceil(top+hei*1.3)))
+}
+
+//在图片里按照宽高比取最大的裁剪区域，优先包含所有人脸，放不下时包含最大的人脸，没有人脸时按照细节丰富程度
+func cropRect(img image.Image, faces []image.Rectangle, ratio float64) (image.Rectangle, string) {
+	bounds := img.Bounds()
+	wid, hei := bounds.Dx(), bounds.Dy()
+	cw, ch := wid, int(math.Round(float64(wid)/ratio))
+	if ch > hei {
+		cw, ch = int(math.Round(float64(hei)*ratio)), hei
+	}
+	cw = clampInt(cw, 1, wid)
+	ch = clampInt(ch, 1, hei)
+	var union, largest image.Rectangle
+	for _, face := range faces {
+		union = union.Union(face)
+		if face.Dx()*face.Dy() > largest.Dx()*largest.Dy() {
+			largest = face
+		}
+	}
+	if union.Empty() {
+		return saliencyRect(img, cw, ch), CropSaliency
+	}
+	if union.Dx() <= cw && union.Dy() <= ch {
+		return placeRect(bounds, union, cw, ch), CropFaces
+	}
+	return placeRect(bounds, largest, cw, ch), CropFace
+}
+
+//裁剪区域的中心对齐目标区域的中心，超出图片时移回图片内
+func placeRect(bounds, target image.Rectangle, cw, ch int) image.Rectangle {
+	x := (target.Min.X+target.Max.X)/2 - cw/2
+	y := (target.Min.Y+target.Max.Y)/2 - ch/2
+	x = clampInt(x, bounds.Min.X, bounds.Max.X-cw)
+	y = clampInt(y, bounds.Min.Y, bounds.Max.Y-ch)
+	return image.Rect(x, y, x+cw, y+ch)
+}
+
+//在缩小的灰度图上用相邻像素的差值表示细节丰富程度，裁剪区域只在一个方向上移动
+func saliencyRect(img image.Image, cw, ch int) image.Rectangle {
+	bounds := img.Bounds()
+	gray := imaging.Grayscale(imaging.Fit(img, saliencySize, saliencySize, imaging.Box))
+	sw, sh := gray.Bounds().Dx(), gray.Bounds().Dy()
+	cols := make([]float64, sw)
+	rows := make([]float64, sh)
+	at := func(x, y int) float64 {
+		return float64(gray.Pix[y*gray.Stride+x*4])
+	}
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			var diff float64
+			if x+1 < sw {
+				diff += math.Abs(at(x, y) - at(x+1, y))
+			}
+			if y+1 < sh {
+				diff += math.Abs(at(x, y) - at(x, y+1))
+			}
+			cols[x] += diff
+			rows[y] += diff
+		}
+	}
+	x, y := bounds.Min.X, bounds.Min.Y
+	if cw < bounds.Dx() {
+		scale := float64(bounds.Dx()) / float64(sw)
+		start := bestWindow(cols, int(math.Round(float64(cw)/scale)))
+		x = clampInt(bounds.Min.X+int(math.Round(float64(start)*scale)), bounds.Min.X, bounds.Max.X-cw)
+	} else if ch < bounds.Dy() {
+		scale := float64(bounds.Dy()) / float64(sh)
+		start := bestWindow(rows, int(math.Round(float64(ch)/scale)))
+		y = clampInt(bounds.Min.Y+int(math.Round(float64(start)*scale)), bounds.Min.Y, bounds.Max.Y-ch)
+	}
+	return image.Rect(x, y, x+cw, y+ch)
+}
+
+//窗口内的和最大的起始位置，相同时取靠近中间的
+func bestWindow(sums []float64, size int) int {
+	size = clampInt(size, 1, len(sums))
+	center := float64(len(sums)-size) / 2
+	var current float64
+	for i := 0; i < size; i++ {
+		current += sums[i]
+	}
+	best, score := 0, current
+	for i := 1; i+size <= len(sums); i++ {
+		current += sums[i+size-1] - sums[i-1]
+		if current > score || (current == score && math.Abs(float64(i)-center) < math.Abs(float64(best)-center)) {
+			best, score = i, current
+		}
+	}
+	return best
+}
+
+func clampInt(val, min, max int) int {
+	if val > max {
+		val = max
+	}
+	if val < min {
+		val = min
+	}
+	return val
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCropRect(t *testing.T) {
+	cases := []struct {
+		name  string
+		size  image.Rectangle
+		faces []image.Rectangle
+		ratio float64
+		rect  image.Rectangle
+		mode  string
+	}{
+		{"faces", image.Rect(0, 0, 200, 100), []image.Rectangle{image.Rect(10, 10, 40, 40)}, 1, image.Rect(0, 0, 100, 100), CropFaces},
+		{"faces center", image.Rect(0, 0, 200, 100), []image.Rectangle{image.Rect(80, 20, 120, 60)}, 1, image.Rect(50, 0, 150, 100), CropFaces},
+		{"largest face", image.Rect(0, 0, 200, 100), []image.Rectangle{image.Rect(0, 0, 30, 30), image.Rect(150, 0, 200, 50)}, 1, image.Rect(100, 0, 200, 100), CropFace},
+		{"whole image", image.Rect(0, 0, 200, 100), []image.Rectangle{image.Rect(10, 10, 40, 40)}, 2, image.Rect(0, 0, 200, 100), CropFaces},
+		{"tall", image.Rect(0, 0, 100, 200), []image.Rectangle{image.Rect(10, 150, 40, 190)}, 1, image.Rect(0, 100, 100, 200), CropFaces},
+		{"saliency", image.Rect(0, 0, 200, 100), nil, 1, image.Rect(50, 0, 150, 100), CropSaliency},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			rect, mode := cropRect(image.NewGray(item.size), item.faces, item.ratio)
+			if rect != item.rect || mode != item.mode {
+				t.Errorf("cropRect() = %v %s, want %v %s", rect, mode, item.rect, item.mode)
+			}
+		})
+	}
+}
+
+func TestBestWindow(t *testing.T) {
+	cases := []struct {
+		name string
+		sums []float64
+		size int
+		want int
+	}{
+		{"max", []float64{1, 5, 5, 1}, 2, 1},
+		{"equal center", []float64{0, 0, 0, 0, 0}, 1, 2},
+		{"equal keep first", []float64{3, 0, 0, 3}, 1, 0},
+		{"size too large", []float64{1, 2}, 5, 0},
+		{"size zero", []float64{0, 9, 0}, 0, 1},
+		{"tail", []float64{1, 0, 0, 4, 4}, 2, 3},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			if got := bestWindow(item.sums, item.size); got != item.want {
+				t.Errorf("bestWindow() = %d, want %d", got, item.want)
+			}
+		})
+	}
+}
+
+func TestClampInt(t *testing.T) {
+	cases := []struct {
+		val, min, max, want int
+	}{
+		{5, 0, 10, 5},
+		{-1, 0, 10, 0},
+		{11, 0, 10, 10},
+		//最大值小于最小值时取最小值
+		{5, 3, 1, 3},
+	}
+	for _, item := range cases {
+		if got := clampInt(item.val, item.min, item.max); got != item.want {
+			t.Errorf("clampInt(%d, %d, %d) = %d, want %d", item.val, item.min, item.max, got, item.want)
+		}
+	}
+}
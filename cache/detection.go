@@ -37,12 +37,31 @@ type DetectFace struct {
 	Glasses  *ProbabilityInfo   `json:"glasses"`    //是否带眼镜，none:无眼镜，common:普通眼镜，sun:墨镜
 	Shape    *ProbabilityInfo   `json:"face_shape"` //情绪，angry:愤怒 disgust:厌恶 fear:恐惧 happy:高兴 sad:伤心 surprise:惊讶 neutral:无表情 pouty: 撅嘴 grimace:鬼脸
 	Kind     *ProbabilityInfo   `json:"face_type"`  //真实人脸、卡通人脸；human: 真实人脸 cartoon: 卡通人脸
-	Mask     *ProbabilityInfo   `json:"mask"`       //口罩识别，取值0或1； 0代表没戴口罩 1 代表戴口罩
+	Mask     *MaskInfo          `json:"mask"`       //口罩识别，取值0或1； 0代表没戴口罩 1 代表戴口罩
 	Emotion  *ProbabilityInfo   `json:"emotion"`    //表情
 }
 
+type MaskInfo struct {
+	Type        int     `json:"type"`
+	Probability float32 `json:"probability"`
+}
+
 type ImageQuality struct {
-	Blur float32 `json:"blur"`
+	Blur         float32        `json:"blur"`
+	Illumination float32        `json:"illumination"`
+	Completeness int            `json:"completeness"`
+	Occlusion    *OcclusionInfo `json:"occlusion"`
+}
+
+//人脸各部分的遮挡比例，[0, 1]
+type OcclusionInfo struct {
+	LeftEye    float32 `json:"left_eye"`
+	RightEye   float32 `json:"right_eye"`
+	Nose       float32 `json:"nose"`
+	Mouth      float32 `json:"mouth"`
+	LeftCheek  float32 `json:"left_cheek"`
+	RightCheek float32 `json:"right_cheek"`
+	Chin       float32 `json:"chin_contour"`
 }
 
 type AngleInfo struct {
@@ -124,7 +143,7 @@ func detectFaces(img string) (*DetectFaceResponse, error, int) {
 		return nil, er, 0
 	}
 	addr := fmt.Sprintf("%s?access_token=%s", config.Schema.Detection.Address, token)
-	data := fmt.Sprintf(`{"image":"%s","image_type":"URL","face_type":"LIVE", "max_face_num":50,"liveness_control":"NONE", "face_field":"age,gender,glasses,face_shape,face_type,quality"}`, img)
+	data := fmt.Sprintf(`{"image":"%s","image_type":"URL","face_type":"LIVE", "max_face_num":50,"liveness_control":"NONE", "face_field":"age,gender,glasses,face_shape,face_type,quality,emotion,mask"}`, img)
 	bts, er := httpPost(addr, data)
 	if er != nil {
 		return nil, er, 0
@@ -138,6 +157,35 @@ func detectFaces(img string) (*DetectFaceResponse, error, int) {
 	return reply, er, 0
 }
 
+//检测结果里的人脸属性
+func (mine *DetectFace) attribute() proxy.FaceAttribute {
+	attr := proxy.FaceAttribute{Age: uint32(mine.Age)}
+	if mine.Gender != nil {
+		attr.Gender = mine.Gender.Type
+	}
+	if mine.Glasses != nil {
+		attr.Glasses = mine.Glasses.Type
+	}
+	if mine.Emotion != nil {
+		attr.Emotion = mine.Emotion.Type
+	}
+	if mine.Shape != nil {
+		attr.Shape = mine.Shape.Type
+	}
+	if mine.Kind != nil {
+		attr.Kind = mine.Kind.Type
+	}
+	if mine.Mask != nil {
+		attr.Mask = mine.Mask.Type == 1
+	}
+	if mine.Angle != nil {
+		attr.Yaw = mine.Angle.Yaw
+		attr.Pitch = mine.Angle.Pitch
+		attr.Roll = mine.Angle.Roll
+	}
+	return attr
+}
+
 //对比人脸
 func compareImages(one *ImageMatchReq, two *ImageMatchReq) (*ImageMatchResp, error) {
 	token, er := getDetectAccessToken()
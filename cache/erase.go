@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"strings"
+	"time"
+)
+
+//删除生物特征数据的回执，只记录数量和标识，不包含人脸数据；审计和事件里用Subject代替用户
+type ErasureReceipt struct {
+	User       string   `json:"user"`
+	Subject    string   `json:"subject"`
+	Users      []string `json:"users"`
+	Groups     []string `json:"groups"`
+	Faces      int      `json:"faces"`
+	Thumbs     int64    `json:"thumbs"`
+	Files      int      `json:"files"`
+	Persons    int64    `json:"persons"`
+	Reviews    int64    `json:"reviews"`
+	Audits     int64    `json:"audits"`
+	Events     int64    `json:"events"`
+	Deliveries int64    `json:"deliveries"`
+	Assets     []string `json:"assets"`
+	Errors     []string `json:"errors"`
+	Created    int64    `json:"created"`
+}
+
+//审计记录里被脱敏的字段
+var erasureFields = []string{"owner", "user", "key", "entity", "decision"}
+
+//用户的sha256，订阅者可以用同样的方法找到被删除的用户
+func erasureSubject(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return hex.EncodeToString(sum[:])
+}
+
+func (mine *ErasureReceipt) fail(msg string, err error) {
+	mine.Errors = append(mine.Errors, fmt.Sprintf("%s: %s", msg, err.Error()))
+}
+
+func (mine *ErasureReceipt) auditData() map[string]interface{} {
+	return map[string]interface{}{
+		"users":      len(mine.Users),
+		"groups":     mine.Groups,
+		"faces":      mine.Faces,
+		"thumbs":     mine.Thumbs,
+		"files":      mine.Files,
+		"persons":    mine.Persons,
+		"reviews":    mine.Reviews,
+		"audits":     mine.Audits,
+		"events":     mine.Events,
+		"deliveries": mine.Deliveries,
+		"assets":     mine.Assets,
+		"errors":     len(mine.Errors),
+	}
+}
+
+//删除人脸用户或者绑定的实体的所有生物特征数据：人脸库里的人脸、人脸记录和裁剪的图片、人、待确认的匹配，
+//恢复匹配时设置的资源所有者，脱敏审计记录，删除提到这些人脸和用户的事件和webhook投递；部分失败时继续执行，可以重复调用
+func (mine *cacheContext) EraseFaceUser(user, operator string) (*ErasureReceipt, error) {
+	if len(user) < 2 {
+		return nil, errors.New("the user is empty")
+	}
+	receipt := &ErasureReceipt{User: user, Subject: erasureSubject(user), Created: time.Now().Unix()}
+	receipt.Users = []string{user}
+	receipt.Errors = make([]string, 0, 1)
+	persons, err := nosql.GetPersonsByUser(user)
+	if err != nil {
+		return nil, err
+	}
+	personIDs := make([]string, 0, len(persons))
+	for _, person := range persons {
+		personIDs = append(personIDs, person.UID.Hex())
+		for _, id := range []string{person.Key, person.Face} {
+			if len(id) > 0 && !tool.HasItem(receipt.Users, id) {
+				receipt.Users = append(receipt.Users, id)
+			}
+		}
+	}
+	thumbs, err := nosql.GetAllThumbsByUsers(receipt.Users)
+	if err != nil {
+		return nil, err
+	}
+
+	//人脸库里的所有分组都删除这些用户的人脸
+	groups, err := getFaceGroups()
+	if err != nil {
+		receipt.fail("list face groups", err)
+	}
+	receipt.Groups = make([]string, 0, 2)
+	for _, group := range groups {
+		for _, id := range receipt.Users {
+			resp, code, er := getFacesByGroup(group, id)
+			if er != nil {
+				if code != ErrorCodeUserNone {
+					receipt.fail("list faces of "+group, er)
+				}
+				continue
+			}
+			if !tool.HasItem(receipt.Groups, group) {
+				receipt.Groups = append(receipt.Groups, group)
+			}
+			for _, face := range resp.FaceList {
+				er = removeFace(resp.LogID, face.Token, id, group)
+				if er != nil {
+					receipt.fail("remove face of "+group, er)
+				} else {
+					receipt.Faces += 1
+				}
+			}
+		}
+	}
+
+	uids := make([]string, 0, len(thumbs))
+	assets := make([]string, 0, len(thumbs))
+	//人脸检测时资源的所有者，匹配和绑定实体只修改资源的所有者，不修改人脸的所有者
+	owners := make(map[string][]string, len(thumbs))
+	for _, thumb := range thumbs {
+		uids = append(uids, thumb.UID.Hex())
+		if !tool.HasItem(assets, thumb.Asset) {
+			assets = append(assets, thumb.Asset)
+		}
+		if !tool.HasItem(owners[thumb.Asset], thumb.Owner) {
+			owners[thumb.Asset] = append(owners[thumb.Asset], thumb.Owner)
+		}
+		if len(thumb.File) > 0 && !strings.Contains(thumb.File, "http") {
+			er := deleteContentFromCloud(thumb.File)
+			if er != nil {
+				receipt.fail("delete file "+thumb.File, er)
+			} else {
+				receipt.Files += 1
+			}
+		}
+	}
+	receipt.Thumbs, err = nosql.DeleteThumbs(uids)
+	if err != nil {
+		receipt.fail("delete thumbs", err)
+	}
+	receipt.Reviews, err = nosql.DeleteReviewsByThumbs(uids)
+	if err != nil {
+		receipt.fail("delete reviews", err)
+	}
+	num, err := nosql.RedactReviewsByUsers(receipt.Users)
+	if err != nil {
+		receipt.fail("redact reviews", err)
+	}
+	receipt.Reviews += num
+	receipt.Persons, err = nosql.DeletePersons(personIDs)
+	if err != nil {
+		receipt.fail("delete persons", err)
+	}
+
+	//只恢复匹配时设置的所有者，所有者是这些用户并且检测人脸时已经是所有者的资源（比如实体自己的照片）不修改
+	receipt.Assets = make([]string, 0, len(assets))
+	for _, uid := range assets {
+		asset, er := nosql.GetAsset(uid)
+		if er != nil || !tool.HasItem(receipt.Users, asset.Owner) || tool.HasItem(owners[uid], asset.Owner) {
+			continue
+		}
+		origin := ""
+		for _, owner := range owners[uid] {
+			if !tool.HasItem(receipt.Users, owner) {
+				origin = owner
+				break
+			}
+		}
+		er = updateAssetOwner(uid, asset.Owner, origin, operator)
+		if er != nil {
+			receipt.fail("restore owner of "+uid, er)
+			continue
+		}
+		receipt.Assets = append(receipt.Assets, uid)
+	}
+
+	//最后脱敏审计记录和删除事件，包括上面恢复所有者时写入的
+	targets := append(append(make([]string, 0, len(uids)+len(personIDs)), uids...), personIDs...)
+	receipt.Audits, err = nosql.RedactAudits(targets, receipt.Users, erasureFields)
+	if err != nil {
+		receipt.fail("redact audits", err)
+	}
+	values := append(append(make([]string, 0, len(receipt.Users)+len(uids)), receipt.Users...), uids...)
+	receipt.Events, err = nosql.PurgeOutboxByValues(values)
+	if err != nil {
+		receipt.fail("purge outbox", err)
+	}
+	receipt.Deliveries, err = nosql.PurgeDeliveriesByValues(values)
+	if err != nil {
+		receipt.fail("purge deliveries", err)
+	}
+	writeAudit(operator, "erase", AuditErasure, receipt.Subject, nil, receipt.auditData())
+	publishThumbEvent(EventFaceErased, receipt.Subject, "", receipt.Subject, "")
+	logger.Infof("erase the face user(%s) by %s that thumbs = %d, faces = %d, errors = %d", user, operator, receipt.Thumbs, receipt.Faces, len(receipt.Errors))
+	if len(receipt.Errors) > 0 {
+		return receipt, fmt.Errorf("the erasure is incomplete that %s", strings.Join(receipt.Errors, "; "))
+	}
+	return receipt, nil
+}
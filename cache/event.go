@@ -0,0 +1,341 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"strconv"
+	"time"
+)
+
+//领域事件的类型，订阅的主题为 prefix + "." + 类型
+const (
+	EventAssetCreated       = "asset.created"
+	EventAssetUpdated       = "asset.updated"
+	EventAssetRemoved       = "asset.removed"
+	EventAssetStatusChanged = "asset.status_changed"
+	EventAssetModerated     = "asset.moderated"
+	EventFacesDetected      = "asset.faces_detected"
+	EventThumbMatched       = "thumb.matched"
+	EventEntityBound        = "thumb.entity_bound"
+	EventFaceErased         = "thumb.erased"
+	EventFolderChanged      = "folder.changed"
+)
+
+//事件结构的版本，不兼容的修改需要升级
+const EventVersion uint32 = 1
+
+const EventSource = "omo.msa.asset"
+
+//所有事件统一的外层结构
+type EventEnvelope struct {
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Version uint32      `json:"version"`
+	Source  string      `json:"source"`
+	Subject string      `json:"subject"`
+	Time    int64       `json:"time"`
+	Data    interface{} `json:"data"`
+}
+
+type AssetEvent struct {
+	UID      string                 `json:"uid"`
+	Name     string                 `json:"name,omitempty"`
+	Owner    string                 `json:"owner,omitempty"`
+	Quote    string                 `json:"quote,omitempty"`
+	Type     uint8                  `json:"type"`
+	Scope    uint8                  `json:"scope"`
+	Operator string                 `json:"operator"`
+	Before   map[string]interface{} `json:"before,omitempty"`
+	After    map[string]interface{} `json:"after,omitempty"`
+}
+
+type ModerationEvent struct {
+	UID   string `json:"uid"`
+	Owner string `json:"owner,omitempty"`
+	Quote string `json:"quote,omitempty"`
+	Type  uint8  `json:"type"`
+	Code  int    `json:"code"`
+}
+
+type FacesEvent struct {
+	Asset string `json:"asset"`
+	Owner string `json:"owner,omitempty"`
+	Quote string `json:"quote"`
+	Count int    `json:"count"`
+}
+
+//Owner、Quote和Type取自人脸所在的资源
+type ThumbEvent struct {
+	UID    string `json:"uid"`
+	Asset  string `json:"asset"`
+	User   string `json:"user"`
+	Entity string `json:"entity,omitempty"`
+	Owner  string `json:"owner,omitempty"`
+	Quote  string `json:"quote,omitempty"`
+	Type   uint8  `json:"type"`
+	typed  bool
+}
+
+type FolderEvent struct {
+	UID      string                 `json:"uid"`
+	Scene    string                 `json:"scene"`
+	Action   string                 `json:"action"`
+	Operator string                 `json:"operator"`
+	Before   map[string]interface{} `json:"before,omitempty"`
+	After    map[string]interface{} `json:"after,omitempty"`
+}
+
+//事件所属的对象范围，用于webhook的过滤，tp小于0表示没有资源类型
+type eventScoper interface {
+	scope() (owner, quote string, tp int)
+}
+
+func (mine *AssetEvent) scope() (string, string, int) {
+	return mine.Owner, mine.Quote, int(mine.Type)
+}
+
+func (mine *ModerationEvent) scope() (string, string, int) {
+	return mine.Owner, mine.Quote, int(mine.Type)
+}
+
+func (mine *FacesEvent) scope() (string, string, int) {
+	return mine.Owner, mine.Quote, -1
+}
+
+func (mine *ThumbEvent) scope() (string, string, int) {
+	if !mine.typed {
+		return mine.Owner, mine.Quote, -1
+	}
+	return mine.Owner, mine.Quote, int(mine.Type)
+}
+
+func (mine *FolderEvent) scope() (string, string, int) {
+	return mine.Scene, "", -1
+}
+
+var eventBroker broker.Broker
+
+func eventEnable() bool {
+	return config.Schema.Event.Enable
+}
+
+//单独发布一个事件，没有对应的数据修改
+func publishEvent(tp, subject string, data interface{}) {
+	err := nosql.RunWrite(func(ctx context.Context) error {
+		return stageEvent(ctx, tp, subject, data)
+	})
+	if err != nil {
+		logger.Warn("write outbox failed that type = " + tp + " and msg = " + err.Error())
+	}
+}
+
+//在数据修改的事务里写入发件箱和webhook的投递记录，由后台任务发布到broker，broker不可用时事件不会丢失
+func stageEvent(ctx context.Context, tp, subject string, data interface{}) error {
+	hooks := matchWebhooks(tp, data)
+	if !eventEnable() && len(hooks) < 1 {
+		return nil
+	}
+	now := time.Now()
+	env := &EventEnvelope{
+		ID:      primitive.NewObjectID().Hex(),
+		Type:    tp,
+		Version: EventVersion,
+		Source:  EventSource,
+		Subject: subject,
+		Time:    now.Unix(),
+		Data:    data,
+	}
+	bts, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	err = enqueueDeliveries(ctx, hooks, env, bts)
+	if err != nil {
+		return err
+	}
+	if !eventEnable() {
+		return nil
+	}
+	db := new(nosql.Outbox)
+	db.UID, _ = primitive.ObjectIDFromHex(env.ID)
+	db.Created = now.Unix()
+	db.Topic = eventTopic(tp)
+	db.Type = tp
+	db.Version = EventVersion
+	db.Subject = subject
+	db.Payload = string(bts)
+	db.Status = nosql.OutboxPending
+	return nosql.CreateOutbox(ctx, db)
+}
+
+func eventTopic(tp string) string {
+	prefix := config.Schema.Event.Prefix
+	if len(prefix) < 1 {
+		return tp
+	}
+	return prefix + "." + tp
+}
+
+//审计记录对应的领域事件
+func stageAuditEvent(ctx context.Context, db *nosql.Audit) error {
+	switch db.Kind {
+	case AuditAsset:
+		tp := EventAssetUpdated
+		if db.Action == AuditCreate {
+			tp = EventAssetCreated
+		} else if db.Action == AuditRemove {
+			tp = EventAssetRemoved
+		} else if db.Action == "update_status" {
+			tp = EventAssetStatusChanged
+		}
+		data := &AssetEvent{UID: db.Target, Operator: db.Actor, Before: db.Before, After: db.After}
+		//事务里读取修改后的资源，审计记录只包含变化的字段
+		if asset, er := nosql.GetAssetIn(ctx, db.Target); er == nil {
+			data.Name = asset.Name
+			data.Owner = asset.Owner
+			data.Quote = asset.Quote
+			data.Type = asset.Type
+			data.Scope = asset.Scope
+			return stageEvent(ctx, tp, db.Target, data)
+		}
+		//资源已经删除时使用删除前的数据
+		snap := db.After
+		if snap == nil {
+			snap = db.Before
+		}
+		if val, ok := snap["name"].(string); ok {
+			data.Name = val
+		}
+		if val, ok := snap["owner"].(string); ok {
+			data.Owner = val
+		}
+		if val, ok := snap["quote"].(string); ok {
+			data.Quote = val
+		}
+		if val, ok := snap["type"].(uint8); ok {
+			data.Type = val
+		}
+		if val, ok := snap["scope"].(uint8); ok {
+			data.Scope = val
+		}
+		return stageEvent(ctx, tp, db.Target, data)
+	case AuditFolder:
+		data := &FolderEvent{UID: db.Target, Action: db.Action, Operator: db.Actor, Before: db.Before, After: db.After}
+		snap := db.After
+		if snap == nil {
+			snap = db.Before
+		}
+		if val, ok := snap["scene"].(string); ok {
+			data.Scene = val
+		}
+		return stageEvent(ctx, EventFolderChanged, db.Target, data)
+	}
+	return nil
+}
+
+func publishThumbEvent(tp, uid, asset, user, entity string) {
+	publishEvent(tp, uid, newThumbEvent(context.Background(), uid, asset, user, entity))
+}
+
+func newThumbEvent(ctx context.Context, uid, asset, user, entity string) *ThumbEvent {
+	data := &ThumbEvent{UID: uid, Asset: asset, User: user, Entity: entity}
+	if len(asset) < 1 {
+		return data
+	}
+	if db, er := nosql.GetAssetIn(ctx, asset); er == nil {
+		data.Owner = db.Owner
+		data.Quote = db.Quote
+		data.Type = db.Type
+		data.typed = true
+	}
+	return data
+}
+
+//启动发件箱的发布任务
+func StartEvents(b broker.Broker) {
+	if !eventEnable() || b == nil {
+		return
+	}
+	eventBroker = b
+	go func() {
+		interval := time.Duration(config.Schema.Event.Interval) * time.Second
+		if interval < time.Second {
+			interval = 2 * time.Second
+		}
+		for {
+			dispatchOutbox()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+//上次清理已发布事件的时间
+var outboxPurged time.Time
+
+//按顺序发布，失败时停止本轮，保证同一个对象的事件不乱序；失败次数达到上限的转为死信，不再阻塞后面的事件
+func dispatchOutbox() {
+	batch := config.Schema.Event.Batch
+	if batch < 1 {
+		batch = 100
+	}
+	retries := config.Schema.Event.Retries
+	if retries < 1 {
+		retries = 10
+	}
+	purgeOutbox()
+	for {
+		dbs, err := nosql.GetPendingOutbox(batch)
+		if err != nil || len(dbs) < 1 {
+			return
+		}
+		for _, db := range dbs {
+			msg := &broker.Message{
+				Header: map[string]string{
+					"id":      db.UID.Hex(),
+					"type":    db.Type,
+					"version": strconv.Itoa(int(db.Version)),
+					"source":  EventSource,
+				},
+				Body: []byte(db.Payload),
+			}
+			er := eventBroker.Publish(db.Topic, msg)
+			if er != nil {
+				logger.Warn("publish event failed that type = " + db.Type + " and msg = " + er.Error())
+				if db.Attempts+1 < retries {
+					_ = nosql.UpdateOutboxFailed(db.UID.Hex(), er.Error(), db.Attempts+1)
+					return
+				}
+				logger.Warn("the event is dead that uid = " + db.UID.Hex() + " and type = " + db.Type)
+				_ = nosql.UpdateOutboxDead(db.UID.Hex(), er.Error(), db.Attempts+1)
+				continue
+			}
+			_ = nosql.UpdateOutboxSent(db.UID.Hex())
+		}
+		if int64(len(dbs)) < batch {
+			return
+		}
+	}
+}
+
+//每小时清理一次超过保留时间的已发布事件，死信保留用于排查
+func purgeOutbox() {
+	if time.Since(outboxPurged) < time.Hour {
+		return
+	}
+	outboxPurged = time.Now()
+	retention := config.Schema.Event.Retention
+	if retention < 1 {
+		retention = 72
+	}
+	num, err := nosql.PurgeOutbox(time.Now().Add(-time.Duration(retention) * time.Hour).Unix())
+	if err != nil {
+		logger.Warn("purge outbox failed that msg = " + err.Error())
+	} else if num > 0 {
+		logger.Infof("purge %d sent events from outbox", num)
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/Baidu-AIP/golang-sdk/aip/censor"
@@ -72,25 +73,39 @@ func validateAsset(info *AssetInfo) {
 		return
 	}
 	code := result.GetStatus()
-	er := nosql.UpdateAssetCode(info.UID, code)
+	er := updateAssetCode(info.UID, info.Code, code)
 	if er != nil {
-		logger.Warn("set asset code failed that uid = " + info.UID + " and msg = " + err.Error())
+		logger.Warn("set asset code failed that uid = " + info.UID + " and msg = " + er.Error())
 		return
 	}
+	publishEvent(EventAssetModerated, info.UID, &ModerationEvent{UID: info.UID, Owner: info.Owner, Quote: info.Quote, Type: info.Type, Code: code})
 	info.Code = code
 	if code == BD_Conclusion {
 		cacheCtx.addPendingAsset(info)
 	}
 }
 
-func checkFaces(asset, owner, url, group, quote, operator string) (error, int) {
+//修改资源的内部状态码并记录审计
+func updateAssetCode(uid string, before, code int) error {
+	return commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateAssetCode(ctx, uid, code)
+	}, AuditSystem, AuditAsset, uid, "code", before, code)
+}
+
+func checkFaces(info *AssetInfo, url, group string) (error, int) {
 	resp, er, code := detectFaces(url)
 	if er != nil {
-		_ = nosql.UpdateAssetCode(asset, BD_DetectFailed)
+		if err := updateAssetCode(info.UID, info.Code, BD_DetectFailed); err != nil {
+			logger.Warn("set asset code failed that uid = " + info.UID + " and msg = " + err.Error())
+		}
+		info.Code = BD_DetectFailed
 		return er, code
 	}
-	_ = nosql.UpdateAssetCode(asset, BD_Detection)
-	er = clipFaces(asset, owner, url, group, quote, operator, resp)
+	if err := updateAssetCode(info.UID, info.Code, BD_Detection); err != nil {
+		logger.Warn("set asset code failed that uid = " + info.UID + " and msg = " + err.Error())
+	}
+	info.Code = BD_Detection
+	er = clipFaces(info.UID, info.Owner, url, group, info.Quote, info.Creator, resp)
 	if er != nil {
 		return er, -1
 	}
@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"io"
+	"net/http"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ExportFolder = "folder"
+	ExportQuote  = "quote"
+	ExportAssets = "assets"
+)
+
+const exportManifest = "manifest.json"
+
+//下载一个文件的超时时间，没有配置时为120秒
+func exportClient() *http.Client {
+	timeout := config.Schema.Web.Timeout
+	if timeout < 1 {
+		timeout = 120
+	}
+	return &http.Client{Timeout: time.Duration(timeout) * time.Second}
+}
+
+type ExportEntry struct {
+	UID     string   `json:"uid"`
+	File    string   `json:"file"`
+	Name    string   `json:"name"`
+	Format  string   `json:"format"`
+	MD5     string   `json:"md5"`
+	Size    uint64   `json:"size"`
+	Type    uint8    `json:"type"`
+	Owner   string   `json:"owner"`
+	Quote   string   `json:"quote"`
+	Creator string   `json:"creator"`
+	Created int64    `json:"created"`
+	Width   uint32   `json:"width"`
+	Height  uint32   `json:"height"`
+	Tags    []string `json:"tags"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type exportItem struct {
+	dir   string
+	asset *AssetInfo
+}
+
+type zipExporter struct {
+	ctx     context.Context
+	client  *http.Client
+	writer  *zip.Writer
+	names   map[string]int
+	entries []*ExportEntry
+}
+
+//按文件夹、引用对象或者指定的资源列表，把原始文件以zip流的方式写入w；ctx取消（比如客户端断开）时停止下载
+func (mine *cacheContext) ExportZip(ctx context.Context, w io.Writer, who *Principal, kind, key string, list []string, manifest bool) error {
+	items, err := mine.collectExportItems(who, kind, key, list)
+	if err != nil {
+		return err
+	}
+	exp := &zipExporter{
+		ctx:     ctx,
+		client:  exportClient(),
+		writer:  zip.NewWriter(w),
+		names:   make(map[string]int, len(items)),
+		entries: make([]*ExportEntry, 0, len(items)),
+	}
+	if manifest {
+		exp.names[exportManifest] = 0
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		er := exp.writeAsset(item.dir, item.asset)
+		if er != nil {
+			logger.Warn("export asset failed that uid = " + item.asset.UID + " and msg = " + er.Error())
+		}
+	}
+	if manifest {
+		err = exp.writeManifest()
+		if err != nil {
+			return err
+		}
+	}
+	return exp.writer.Close()
+}
+
+//只导出有读权限的文件夹和资源
+func (mine *cacheContext) collectExportItems(who *Principal, kind, key string, list []string) ([]*exportItem, error) {
+	items := make([]*exportItem, 0, 50)
+	switch kind {
+	case ExportFolder:
+		folder, err := mine.GetFolder(key)
+		if err != nil {
+			return nil, err
+		}
+		if !who.CanFolder(folder, ActionRead) {
+			return nil, errors.New("the operator has no permission")
+		}
+		visited := make(map[string]bool, 10)
+		mine.walkExportFolder(who, folder, "", visited, &items)
+	case ExportQuote:
+		if key == "" {
+			return nil, errors.New("the quote is empty")
+		}
+		dbs, err := nosql.GetAssetsByQuote(key, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, db := range dbs {
+			info := new(AssetInfo)
+			info.initInfo(db)
+			if who.CanAsset(info, ActionRead) {
+				items = append(items, &exportItem{asset: info})
+			}
+		}
+	case ExportAssets:
+		for _, uid := range list {
+			info := mine.GetAsset(uid)
+			if info != nil && who.CanAsset(info, ActionRead) {
+				items = append(items, &exportItem{asset: info})
+			}
+		}
+	default:
+		return nil, errors.New("not support the export kind of " + kind)
+	}
+	if len(items) < 1 {
+		return nil, errors.New("not found any asset to export")
+	}
+	return items, nil
+}
+
+func (mine *cacheContext) walkExportFolder(who *Principal, folder *FolderInfo, dir string, visited map[string]bool, items *[]*exportItem) {
+	if folder == nil || visited[folder.UID] || !who.CanFolder(folder, ActionRead) {
+		return
+	}
+	visited[folder.UID] = true
+	for _, content := range folder.Contents {
+		info := mine.GetAsset(content.Key)
+		if info != nil && who.CanAsset(info, ActionRead) {
+			*items = append(*items, &exportItem{dir: dir, asset: info})
+		}
+	}
+	children, _ := mine.GetFoldersByParent(folder.UID)
+	for _, child := range children {
+		mine.walkExportFolder(who, child, path.Join(dir, cleanExportName(child.Name, child.UID)), visited, items)
+	}
+}
+
+func (mine *zipExporter) writeAsset(dir string, info *AssetInfo) error {
+	file := mine.uniqueName(dir, info)
+	entry := &ExportEntry{
+		UID:     info.UID,
+		File:    file,
+		Name:    info.Name,
+		Format:  info.Format,
+		MD5:     info.MD5,
+		Size:    info.Size,
+		Type:    info.Type,
+		Owner:   info.Owner,
+		Quote:   info.Quote,
+		Creator: info.Creator,
+		Created: info.Created,
+		Width:   info.Width,
+		Height:  info.Height,
+		Tags:    info.Tags,
+	}
+	mine.entries = append(mine.entries, entry)
+
+	if info.Quarantine {
+		entry.Error = "the asset is quarantined"
+		return errors.New(entry.Error)
+	}
+	url := GetURL(info.UUID, false)
+	if len(url) < 1 {
+		entry.Error = "the asset file is empty"
+		return errors.New(entry.Error)
+	}
+	req, err := http.NewRequestWithContext(mine.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		entry.Error = err.Error()
+		return err
+	}
+	resp, err := mine.client.Do(req)
+	if err != nil {
+		entry.Error = err.Error()
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		entry.Error = fmt.Sprintf("download the file failed that code = %d", resp.StatusCode)
+		return errors.New(entry.Error)
+	}
+	header := &zip.FileHeader{
+		Name:     file,
+		Method:   exportMethod(info),
+		Modified: time.Unix(info.Created, 0),
+	}
+	writer, err := mine.writer.CreateHeader(header)
+	if err != nil {
+		entry.Error = err.Error()
+		return err
+	}
+	_, err = io.Copy(writer, resp.Body)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return err
+}
+
+func (mine *zipExporter) writeManifest() error {
+	writer, err := mine.writer.Create(exportManifest)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(mine.entries)
+}
+
+//根据资源名和格式生成文件名，同一个目录下的重名文件追加序号
+func (mine *zipExporter) uniqueName(dir string, info *AssetInfo) string {
+	ext := exportExtension(info.Format)
+	base := cleanExportName(info.Name, info.UID)
+	if ext != "" && strings.HasSuffix(strings.ToLower(base), "."+ext) {
+		base = base[:len(base)-len(ext)-1]
+	}
+	name := base
+	if ext != "" {
+		name = base + "." + ext
+	}
+	file := path.Join(dir, name)
+	origin := strings.ToLower(file)
+	count, ok := mine.names[origin]
+	for ok {
+		count += 1
+		name = fmt.Sprintf("%s(%d)", base, count)
+		if ext != "" {
+			name = name + "." + ext
+		}
+		file = path.Join(dir, name)
+		_, ok = mine.names[strings.ToLower(file)]
+	}
+	mine.names[origin] = count
+	mine.names[strings.ToLower(file)] = 0
+	return file
+}
+
+func exportExtension(format string) string {
+	ext := strings.ToLower(strings.TrimSpace(format))
+	if i := strings.LastIndex(ext, "/"); i > -1 {
+		ext = ext[i+1:]
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+func exportMethod(info *AssetInfo) uint16 {
+	switch exportExtension(info.Format) {
+	case "jpg", "jpeg", "png", "gif", "webp", "mp4", "mp3", "mov", "zip", "rar", "7z", "gz":
+		return zip.Store
+	default:
+		return zip.Deflate
+	}
+}
+
+func cleanExportName(name, def string) string {
+	tmp := strings.TrimSpace(name)
+	tmp = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "..", "_").Replace(tmp)
+	if len(tmp) < 1 {
+		return def
+	}
+	return tmp
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,6 +17,7 @@ type FolderInfo struct {
 	UID      string `json:"uid"`
 	Creator  string
 	Operator string
+	Revision uint64
 
 	Access uint8
 	Type   uint8
@@ -57,10 +59,16 @@ func (mine *cacheContext) CreateFolder(in *pb.ReqFolderAdd) (*FolderInfo, error)
 			Count: content.Count,
 		})
 	}
-	err := nosql.CreateFolder(db)
+	info := new(FolderInfo)
+	info.initInfo(db)
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.CreateFolder(ctx, db)
+		if er != nil {
+			return er
+		}
+		return recordAudit(ctx, in.Operator, AuditCreate, AuditFolder, info.UID, nil, info.auditData())
+	})
 	if err == nil {
-		info := new(FolderInfo)
-		info.initInfo(db)
 		return info, nil
 	}
 	return nil, err
@@ -71,7 +79,16 @@ func (mine *cacheContext) RemoveFolder(uid, operator string) error {
 	if num > 0 {
 		return errors.New("the folder not empty")
 	}
-	return nosql.RemoveFolder(uid, operator)
+	db, _ := nosql.GetFolder(uid)
+	return commitWrite(func(ctx context.Context) error {
+		er := nosql.RemoveFolder(ctx, uid, operator)
+		if er != nil || db == nil {
+			return er
+		}
+		info := new(FolderInfo)
+		info.initInfo(db)
+		return recordAudit(ctx, operator, AuditRemove, AuditFolder, uid, info.auditData(), nil)
+	})
 }
 
 func (mine *cacheContext) GetFolder(uid string) (*FolderInfo, error) {
@@ -136,6 +153,7 @@ func (mine *FolderInfo) initInfo(db *nosql.Folder) {
 	mine.Updated = db.Updated
 	mine.Creator = db.Creator
 	mine.Operator = db.Operator
+	mine.Revision = db.Revision
 	mine.Scene = db.Scene
 	mine.Name = db.Name
 	mine.Type = db.Type
@@ -156,8 +174,15 @@ func (mine *FolderInfo) GetChildCount() uint32 {
 	return uint32(num)
 }
 
-func (mine *FolderInfo) UpdateBase(name, remark, operator string) error {
-	err := nosql.UpdateFolderBase(mine.UID, name, remark, operator)
+//revision为预期的修订号，小于0时不检查
+func (mine *FolderInfo) UpdateBase(name, remark, operator string, revision int64) error {
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateFolderBase(ctx, mine.UID, name, remark, operator, revision)
+		if er != nil {
+			return er
+		}
+		return recordAudit(ctx, operator, "update_base", AuditFolder, mine.UID, map[string]interface{}{"name": mine.Name, "remark": mine.Remark}, map[string]interface{}{"name": name, "remark": remark})
+	})
 	if err == nil {
 		mine.Name = name
 		mine.Remark = remark
@@ -171,7 +196,9 @@ func (mine *FolderInfo) UpdateAccess(operator string, acc uint32) error {
 	if mine.Access == uint8(acc) {
 		return nil
 	}
-	err := nosql.UpdateFolderAccess(mine.UID, operator, uint8(acc))
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateFolderAccess(ctx, mine.UID, operator, uint8(acc))
+	}, operator, AuditFolder, mine.UID, "access", mine.Access, uint8(acc))
 	if err == nil {
 		mine.Access = uint8(acc)
 		mine.Operator = operator
@@ -179,11 +206,13 @@ func (mine *FolderInfo) UpdateAccess(operator string, acc uint32) error {
 	return err
 }
 
-func (mine *FolderInfo) UpdateParent(operator, parent string) error {
+func (mine *FolderInfo) UpdateParent(operator, parent string, revision int64) error {
 	if mine.Parent == parent {
 		return nil
 	}
-	err := nosql.UpdateFolderParent(mine.UID, parent, operator)
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateFolderParent(ctx, mine.UID, parent, operator, revision)
+	}, operator, AuditFolder, mine.UID, "parent", mine.Parent, parent)
 	if err == nil {
 		mine.Parent = parent
 		mine.Operator = operator
@@ -191,11 +220,13 @@ func (mine *FolderInfo) UpdateParent(operator, parent string) error {
 	return err
 }
 
-func (mine *FolderInfo) UpdateCover(operator, cover string) error {
+func (mine *FolderInfo) UpdateCover(operator, cover string, revision int64) error {
 	if mine.Cover == cover {
 		return nil
 	}
-	err := nosql.UpdateFolderCover(mine.UID, cover, operator)
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateFolderCover(ctx, mine.UID, cover, operator, revision)
+	}, operator, AuditFolder, mine.UID, "cover", mine.Cover, cover)
 	if err == nil {
 		mine.Cover = cover
 		mine.Operator = operator
@@ -212,10 +243,8 @@ func (mine *FolderInfo) HadContent(key string) bool {
 	return false
 }
 
-func (mine *FolderInfo) AppendContent(key, value, operator string) error {
-	if mine.HadContent(key) {
-		return nil
-	}
+//数据库里原子地判断是否已经存在，不依赖内存里的内容
+func (mine *FolderInfo) AppendContent(key, value, operator string, revision int64) error {
 	//all := make([]*proxy.PairInfo, 0, len(mine.Contents)+1)
 	//all = append(all, mine.Contents...)
 	//all = append(all, &proxy.PairInfo{
@@ -228,15 +257,47 @@ func (mine *FolderInfo) AppendContent(key, value, operator string) error {
 		Value: value,
 		Count: 0,
 	}
-	err := nosql.AppendFolderContent(mine.UID, operator, tmp)
-	if err == nil {
+	var ok bool
+	err := commitWrite(func(ctx context.Context) error {
+		var er error
+		ok, er = nosql.AppendFolderContent(ctx, mine.UID, operator, tmp, revision)
+		if er != nil || !ok {
+			return er
+		}
+		return recordAudit(ctx, operator, "append_content", AuditFolder, mine.UID, nil, map[string]interface{}{"key": key, "value": value})
+	})
+	if err == nil && ok {
 		mine.Contents = append(mine.Contents, tmp)
 		mine.Operator = operator
 	}
 	return err
 }
 
-func (mine *FolderInfo) UpdateContents(operator string, list []*pb.PairInfo) error {
+func (mine *FolderInfo) RemoveContent(key, operator string) error {
+	if !mine.HadContent(key) {
+		return nil
+	}
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.RemoveFolderContent(ctx, mine.UID, key)
+		if er != nil {
+			return er
+		}
+		return recordAudit(ctx, operator, "remove_content", AuditFolder, mine.UID, map[string]interface{}{"key": key}, nil)
+	})
+	if err == nil {
+		list := make([]*proxy.PairInfo, 0, len(mine.Contents))
+		for _, content := range mine.Contents {
+			if content.Key != key {
+				list = append(list, content)
+			}
+		}
+		mine.Contents = list
+		mine.Operator = operator
+	}
+	return err
+}
+
+func (mine *FolderInfo) UpdateContents(operator string, revision int64, list []*pb.PairInfo) error {
 	arr := make([]*proxy.PairInfo, 0, len(list))
 	for _, pair := range list {
 		arr = append(arr, &proxy.PairInfo{
@@ -245,7 +306,9 @@ func (mine *FolderInfo) UpdateContents(operator string, list []*pb.PairInfo) err
 			Count: pair.Count,
 		})
 	}
-	err := nosql.UpdateFolderContents(mine.UID, operator, arr)
+	err := commitUpdate(func(ctx context.Context) error {
+		return nosql.UpdateFolderContents(ctx, mine.UID, operator, revision, arr)
+	}, operator, AuditFolder, mine.UID, "contents", mine.Contents, arr)
 	if err == nil {
 		mine.Contents = arr
 		mine.Operator = operator
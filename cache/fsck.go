@@ -0,0 +1,399 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"github.com/qiniu/api.v7/v7/auth/qbox"
+	"github.com/qiniu/api.v7/v7/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const ReportFsck = "fsck"
+
+const (
+	IssueThumbOrphan    = "thumb_orphan"           //资源已经不存在的人脸
+	IssueThumbFile      = "thumb_file_missing"     //人脸图片没有上传成功
+	IssueAssetObject    = "asset_object_missing"   //存储里没有资源的文件
+	IssueAssetSnapshot  = "asset_snapshot_missing" //存储里没有资源的快照或者小图
+	IssueStorageOrphan  = "storage_orphan"         //存储里没有被引用的文件
+	IssueStorageUnknown = "storage_unavailable"    //无法读取存储的文件列表，跳过存储相关的检查
+)
+
+const (
+	RepairNone     = "none"
+	RepairReupload = "reupload"
+	RepairRelink   = "relink"
+	RepairRecycle  = "recycle"
+)
+
+//报告里最多保存的问题数量，超过后只计数
+const fsckMaxIssues = 5000
+
+//一致性检查是否在进行中
+var fscking int32 = 0
+
+//执行时更新心跳的报告类型，心跳超时的报告标记为失败
+var heartbeatReports = []string{ReportFsck, ReportReconcile}
+
+type fsckState struct {
+	operator string
+	repair   bool
+	grace    int64
+	keys     map[string]int64 //存储里的文件和上传时间，为nil表示无法读取
+	refs     map[string]bool
+	assets   map[string]bool
+	report   *nosql.Report
+}
+
+func fsckGrace() int64 {
+	if config.Schema.Fsck.Grace < 1 {
+		return 3600
+	}
+	return config.Schema.Fsck.Grace
+}
+
+//后台执行一致性检查，返回报告的uid
+func (mine *cacheContext) StartFsck(operator string, repair bool) (string, error) {
+	if !atomic.CompareAndSwapInt32(&fscking, 0, 1) {
+		return "", errors.New("the fsck is running")
+	}
+	report, err := createReport(ReportFsck, operator, repair)
+	if err != nil {
+		atomic.StoreInt32(&fscking, 0)
+		return "", err
+	}
+	go func() {
+		defer atomic.StoreInt32(&fscking, 0)
+		mine.runFsck(report)
+	}()
+	return report.UID.Hex(), nil
+}
+
+//同步执行一致性检查，用于命令行
+func (mine *cacheContext) RunFsck(operator string, repair bool) (*nosql.Report, error) {
+	if !atomic.CompareAndSwapInt32(&fscking, 0, 1) {
+		return nil, errors.New("the fsck is running")
+	}
+	defer atomic.StoreInt32(&fscking, 0)
+	report, err := createReport(ReportFsck, operator, repair)
+	if err != nil {
+		return nil, err
+	}
+	mine.runFsck(report)
+	return report, nil
+}
+
+//按照配置的间隔（小时）定时检查，并且定时把心跳超时的报告标记为失败
+func StartFsck() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			failStaleReports()
+			<-ticker.C
+		}
+	}()
+	interval := config.Schema.Fsck.Interval
+	if interval < 1 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			_, err := cacheCtx.RunFsck(AuditSystem, config.Schema.Fsck.Repair)
+			if err != nil {
+				logger.Warn("the scheduled fsck failed that msg = " + err.Error())
+			}
+		}
+	}()
+}
+
+func (mine *cacheContext) GetReport(uid string) (*nosql.Report, error) {
+	db, err := nosql.GetReport(uid)
+	if err != nil {
+		return nil, err
+	}
+	//查询时心跳已经超时的报告直接返回失败，不等待定时任务标记
+	if db.Status == nosql.ReportRunning && tool.HasItem(heartbeatReports, db.Kind) && db.Updated < time.Now().Unix()-batchStale() {
+		db.Status = nosql.ReportFailed
+		db.Error = "the report is stale"
+	}
+	return db, nil
+}
+
+func failStaleReports() {
+	num, err := nosql.FailStaleReports(heartbeatReports, time.Now().Unix()-batchStale(), "the report is stale")
+	if err != nil {
+		logger.Warn("fail the stale reports failed that msg = " + err.Error())
+	} else if num > 0 {
+		logger.Warnf("mark %d stale reports as failed", num)
+	}
+}
+
+//执行期间定时更新报告的心跳
+func reportHeartbeat(report *nosql.Report) func() {
+	uid := report.UID.Hex()
+	return startHeartbeat(func() {
+		_ = nosql.UpdateReportHeartbeat(uid)
+	})
+}
+
+func (mine *cacheContext) GetReports(kind string, num int64) ([]*nosql.Report, error) {
+	if num < 1 {
+		num = 10
+	}
+	return nosql.GetReportsByKind(kind, num)
+}
+
+func createReport(kind, operator string, repair bool) (*nosql.Report, error) {
+	db := new(nosql.Report)
+	db.UID = primitive.NewObjectID()
+	db.Created = time.Now().Unix()
+	db.Updated = db.Created
+	db.Creator = operator
+	db.Kind = kind
+	db.Repair = repair
+	db.Status = nosql.ReportRunning
+	db.Scanned = make(map[string]uint32)
+	db.Counts = make(map[string]uint32)
+	db.Issues = make([]nosql.ReportIssue, 0, 10)
+	err := nosql.CreateReport(db)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (mine *cacheContext) runFsck(report *nosql.Report) {
+	stop := reportHeartbeat(report)
+	defer stop()
+	state := &fsckState{
+		operator: report.Creator,
+		repair:   report.Repair,
+		grace:    time.Now().Unix() - fsckGrace(),
+		refs:     make(map[string]bool),
+		assets:   make(map[string]bool),
+		report:   report,
+	}
+	keys, err := listStorageKeys()
+	if err != nil {
+		state.addIssue(nosql.ReportIssue{Kind: IssueStorageUnknown, Target: config.Schema.Storage.Bucket, Detail: err.Error(), Action: RepairNone})
+	} else {
+		state.keys = keys
+		report.Scanned["storage"] = uint32(len(keys))
+	}
+	err = nosql.Each[nosql.Asset](nosql.TableAssets, bson.M{}, func(item *nosql.Asset) error {
+		report.Scanned[AuditAsset] += 1
+		mine.checkFsckAsset(state, item)
+		return nil
+	})
+	if err == nil {
+		err = nosql.Each[nosql.Thumb](nosql.TableThumbs, bson.M{nosql.TimeDeleted: 0}, func(item *nosql.Thumb) error {
+			report.Scanned[AuditThumb] += 1
+			mine.checkFsckThumb(state, item)
+			return nil
+		})
+	}
+	if err == nil {
+		err = nosql.Each[nosql.Recycle](nosql.TableRecycles, bson.M{}, func(item *nosql.Recycle) error {
+			report.Scanned["recycle"] += 1
+			state.refer(item.UUID, item.Snapshot, item.Small)
+			return nil
+		})
+	}
+	if err == nil {
+		//生成的文件夹封面也在存储里
+		err = nosql.Each[nosql.Folder](nosql.TableFolders, bson.M{}, func(item *nosql.Folder) error {
+			report.Scanned["folder"] += 1
+			state.refer(item.Cover)
+			return nil
+		})
+	}
+	if err == nil && state.keys != nil {
+		for key, put := range state.keys {
+			if !state.refs[key] && put < state.grace {
+				state.addIssue(nosql.ReportIssue{Kind: IssueStorageOrphan, Target: key, Action: RepairNone})
+			}
+		}
+	}
+	report.Status = nosql.ReportFinished
+	if err != nil {
+		report.Status = nosql.ReportFailed
+		report.Error = err.Error()
+	}
+	er := nosql.UpdateReportResult(report)
+	if er != nil {
+		logger.Warn("save the fsck report failed that msg = " + er.Error())
+	}
+	logger.Infof("fsck by %s finished that report = %s, counts = %v", report.Creator, report.UID.Hex(), report.Counts)
+}
+
+func (mine *fsckState) refer(keys ...string) {
+	for _, key := range keys {
+		if len(key) > 0 {
+			mine.refs[key] = true
+		}
+	}
+}
+
+//存储可用时，判断文件是否缺失；网络地址和上传时间在宽限期内的不检查
+func (mine *fsckState) missing(key string, created int64) bool {
+	if mine.keys == nil || len(key) < 1 || strings.Contains(key, "http") || created > mine.grace {
+		return false
+	}
+	_, ok := mine.keys[key]
+	return !ok
+}
+
+func (mine *fsckState) addIssue(issue nosql.ReportIssue) {
+	mine.report.Counts[issue.Kind] += 1
+	if issue.Repaired {
+		mine.report.Counts["repaired"] += 1
+	}
+	if len(mine.report.Issues) < fsckMaxIssues {
+		mine.report.Issues = append(mine.report.Issues, issue)
+	}
+}
+
+func (mine *fsckState) resolve(issue nosql.ReportIssue, fn func() error) {
+	if mine.repair && issue.Action != RepairNone {
+		err := fn()
+		if err != nil {
+			issue.Error = err.Error()
+		} else {
+			issue.Repaired = true
+		}
+	}
+	mine.addIssue(issue)
+}
+
+func (mine *cacheContext) checkFsckAsset(state *fsckState, db *nosql.Asset) {
+	uid := db.UID.Hex()
+	state.assets[uid] = true
+	state.refer(db.UUID, db.Snapshot, db.Small)
+	info := new(AssetInfo)
+	info.initInfo(db)
+	if state.missing(db.UUID, db.Created) {
+		issue := nosql.ReportIssue{Kind: IssueAssetObject, Target: uid, Detail: db.UUID, Action: RepairRecycle}
+		state.resolve(issue, func() error {
+			return info.Remove(state.operator)
+		})
+		return
+	}
+	if state.missing(db.Snapshot, db.Created) {
+		issue := nosql.ReportIssue{Kind: IssueAssetSnapshot, Target: uid, Detail: db.Snapshot, Action: RepairRelink}
+		state.resolve(issue, func() error {
+			//没有快照时使用原文件
+			return info.UpdateSnapshot(state.operator, "")
+		})
+	}
+	if state.missing(db.Small, db.Created) {
+		issue := nosql.ReportIssue{Kind: IssueAssetSnapshot, Target: uid, Detail: db.Small, Action: RepairRelink}
+		state.resolve(issue, func() error {
+			return info.UpdateSmall(state.operator, "")
+		})
+	}
+}
+
+func (mine *cacheContext) checkFsckThumb(state *fsckState, db *nosql.Thumb) {
+	uid := db.UID.Hex()
+	state.refer(db.File)
+	if !state.assets[db.Asset] {
+		issue := nosql.ReportIssue{Kind: IssueThumbOrphan, Target: uid, Detail: db.Asset, Action: RepairRecycle}
+		state.resolve(issue, func() error {
+			err := nosql.RemoveThumb(uid, state.operator)
+			if err == nil {
+				writeAudit(state.operator, AuditRemove, AuditThumb, uid, map[string]interface{}{"asset": db.Asset, "user": db.User}, nil)
+			}
+			return err
+		})
+		return
+	}
+	if (len(db.File) < 1 && db.Created < state.grace) || state.missing(db.File, db.Created) {
+		issue := nosql.ReportIssue{Kind: IssueThumbFile, Target: uid, Detail: db.File, Action: RepairReupload}
+		state.resolve(issue, func() error {
+			file, err := mine.reuploadThumb(db, state.operator)
+			if err == nil {
+				state.refer(file)
+			}
+			return err
+		})
+	}
+}
+
+//从资源的图片里重新裁剪人脸并上传
+func (mine *cacheContext) reuploadThumb(db *nosql.Thumb, operator string) (string, error) {
+	asset := mine.GetAsset(db.Asset)
+	if asset == nil {
+		return "", errors.New("the asset not found")
+	}
+	if db.Location.Width < 1 || db.Location.Height < 1 {
+		return "", errors.New("the face location is empty")
+	}
+	_, url := asset.getMinURL()
+	_, buf, err := downloadAsset(url)
+	if err != nil {
+		return "", err
+	}
+	_, bts, err := clipImageFace(buf, db.Location)
+	if err != nil {
+		return "", err
+	}
+	file := db.File
+	if len(file) < 1 {
+		file = tool.CreateUUID()
+	}
+	_, _, err = uploadToQiNiu(file, bts)
+	if err != nil {
+		return "", err
+	}
+	if file != db.File {
+		err = commitUpdate(func(ctx context.Context) error {
+			return nosql.UpdateThumbFields(ctx, db.UID.Hex(), operator, nosql.RevisionAny, bson.M{"file": file})
+		}, operator, AuditThumb, db.UID.Hex(), "file", db.File, file)
+		if err != nil {
+			return "", err
+		}
+	}
+	return file, nil
+}
+
+//列出存储空间里的所有文件
+func listStorageKeys() (map[string]int64, error) {
+	cof := config.Schema.Storage
+	if len(cof.Bucket) < 1 || len(cof.AccessKey) < 1 {
+		return nil, errors.New("the storage not config")
+	}
+	mac := qbox.NewMac(cof.AccessKey, cof.SecretKey)
+	bucketManager := storage.NewBucketManager(mac, &storage.Config{UseHTTPS: false})
+	keys := make(map[string]int64)
+	marker := ""
+	for {
+		entries, _, next, hasNext, err := bucketManager.ListFiles(cof.Bucket, "", "", marker, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("list the storage failed that %s", err.Error())
+		}
+		for _, entry := range entries {
+			if entry.IsEmpty() {
+				continue
+			}
+			//putTime的单位是100纳秒
+			keys[entry.Key] = entry.PutTime / 10000000
+		}
+		if !hasNext {
+			break
+		}
+		marker = next
+	}
+	return keys, nil
+}
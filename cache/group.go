@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"time"
+)
+
+//有新的迁移排队时唤醒后台的迁移
+var migrateSignal = make(chan struct{}, 1)
+
+//人脸库的统计，Users为人脸库里的用户数量，Faces为本地的人脸数量
+type FaceGroupInfo struct {
+	Group   string `json:"group"`
+	Users   int    `json:"users"`
+	Faces   uint32 `json:"faces"`
+	Persons uint32 `json:"persons"`
+}
+
+//人脸库和本地人脸所在的人脸库，读取人脸库的用户失败时Users为-1
+func (mine *cacheContext) GetFaceGroups() ([]*FaceGroupInfo, error) {
+	groups, err := getFaceGroups()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := nosql.GetThumbCountByGroups()
+	if err != nil {
+		return nil, err
+	}
+	for group := range counts {
+		if len(group) > 0 && !tool.HasItem(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	list := make([]*FaceGroupInfo, 0, len(groups))
+	for _, group := range groups {
+		info := &FaceGroupInfo{Group: group, Faces: counts[group]}
+		info.Users = getUserCountByGroup(group)
+		info.Persons = nosql.GetPersonCountByGroup(group)
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+//机构删除后删除对应的人脸库，本地的人脸和人一起删除；默认人脸库不能删除
+func (mine *cacheContext) RemoveFaceGroup(group, operator string) error {
+	if len(group) < 1 || group == FaceGroupDefault {
+		return errors.New("the face group can not remove")
+	}
+	err := removeFaceGroup(group)
+	if err != nil {
+		return err
+	}
+	thumbs, err := nosql.RemoveThumbsByGroup(group, operator)
+	if err != nil {
+		return err
+	}
+	persons, err := nosql.RemovePersonsByGroup(group, operator)
+	if err != nil {
+		return err
+	}
+	writeAudit(operator, AuditRemove, AuditGroup, group, map[string]interface{}{"thumbs": thumbs, "persons": persons}, nil)
+	return nil
+}
+
+//资源的范围或者所有者修改后，把已经注册的人脸移动到新的人脸库；先保存到迁移队列，进程退出后启动时继续
+func (mine *AssetInfo) migrateFaces(operator string) {
+	if !mine.SupportFace() {
+		return
+	}
+	err := nosql.UpsertMigration(mine.UID, operator)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("queue the migration of asset(%s) failed that msg = %s", mine.UID, err.Error()))
+		return
+	}
+	select {
+	case migrateSignal <- struct{}{}:
+	default:
+	}
+}
+
+//后台逐个执行人脸库的迁移，避免超过人脸库接口的QPS限制；失败的按次数退避重试
+func StartMigrations() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			runMigrations()
+			select {
+			case <-ticker.C:
+			case <-migrateSignal:
+			}
+		}
+	}()
+}
+
+func runMigrations() {
+	for {
+		dbs, err := nosql.GetDueMigrations(time.Now().Unix(), 100)
+		if err != nil || len(dbs) < 1 {
+			return
+		}
+		for _, db := range dbs {
+			uid := db.UID.Hex()
+			er := migrateAsset(db.Asset, db.Operator)
+			if er != nil {
+				attempts := db.Attempts + 1
+				delay := time.Duration(attempts) * time.Minute
+				if delay > time.Hour {
+					delay = time.Hour
+				}
+				_ = nosql.UpdateMigrationFailed(uid, er.Error(), attempts, time.Now().Add(delay).Unix())
+				logger.Warn(fmt.Sprintf("migrate the faces of asset(%s) failed that msg = %s", db.Asset, er.Error()))
+				continue
+			}
+			_ = nosql.RemoveMigration(uid, db.Sequence)
+		}
+	}
+}
+
+//迁移到资源当前的人脸库，资源已经删除时不迁移
+func migrateAsset(uid, operator string) error {
+	db, err := nosql.GetAsset(uid)
+	if err != nil {
+		return nil
+	}
+	info := new(AssetInfo)
+	info.initInfo(db)
+	if !info.SupportFace() {
+		return nil
+	}
+	return migrateAssetFaces(uid, info.CheckFaceGroup(), operator)
+}
+
+func migrateAssetFaces(asset, group, operator string) error {
+	dbs, err := nosql.GetThumbsByAsset(asset)
+	if err != nil {
+		return err
+	}
+	pairs := make(map[[2]string]bool)
+	checked := false
+	for _, db := range dbs {
+		if db.Deleted > 0 || db.Group == group {
+			continue
+		}
+		if !checked {
+			err = checkFaceGroup(group)
+			if err != nil {
+				return err
+			}
+			checked = true
+		}
+		err = migrateThumbFace(db, group)
+		if err != nil {
+			return err
+		}
+		uid := db.UID.Hex()
+		err = nosql.UpdateThumbGroup(uid, group)
+		if err != nil {
+			return err
+		}
+		auditUpdate(operator, AuditThumb, uid, "group", db.Group, group)
+		if len(db.User) > 0 {
+			pairs[[2]string{db.Group, db.User}] = true
+			pairs[[2]string{group, db.User}] = true
+		}
+	}
+	refreshPersons(pairs, operator)
+	return nil
+}
+
+//人脸库里的人脸从原来的人脸库移动到新的人脸库，没有face_token的旧人脸重新注册
+func migrateThumbFace(db *nosql.Thumb, group string) error {
+	if len(db.User) < 1 || len(db.Group) < 1 {
+		return nil
+	}
+	face, _ := resolveFaceUser(db.Group, db.User)
+	if len(db.Face) < 1 {
+		_, err := registerThumbFace(group, face, db)
+		if err == nil {
+			logger.Warn(fmt.Sprintf("the thumb(%s) has no face token, the old face in group(%s) is kept", db.UID.Hex(), db.Group))
+		}
+		return err
+	}
+	err := addProviderFace(group, face, db.Face)
+	if err != nil {
+		return err
+	}
+	err = removeFace(0, db.Face, face, db.Group)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("remove the face(%s) of group(%s) failed that msg = %s", db.Face, db.Group, err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/micro/go-micro/v2/logger"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"time"
+)
+
+//请求头里的幂等键
+const HeaderIdempotency = "Idempotency-Key"
+
+var (
+	ErrIdempotencyReused  = errors.New("the idempotency key is reused by a different request")
+	ErrIdempotencyPending = errors.New("the request of the idempotency key is in progress")
+)
+
+//同一个幂等键的请求正在处理时，等待的最长时间
+const idempotencyWait = 10 * time.Second
+
+//处理中的请求占用幂等键的时长（秒），进程崩溃后超过这个时间其他请求可以接管
+func idempotencyLease() int64 {
+	lease := config.Schema.Idempotency.Lease
+	if lease < 1 {
+		lease = 60
+	}
+	return lease
+}
+
+func idempotencyID(method, caller, key string) string {
+	sum := sha256.Sum256([]byte(method + "\n" + caller + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+//占用幂等键，返回之前请求的结果（为空表示需要创建）；之前的请求还在处理时会等待它完成
+func BeginIdempotent(ctx context.Context, method, caller, key, digest string) (string, string, error) {
+	id := idempotencyID(method, caller, key)
+	ttl := config.Schema.Idempotency.TTL
+	if ttl < 1 {
+		ttl = 24 * 3600
+	}
+	now := time.Now()
+	db := &nosql.Idempotency{
+		Key:     id,
+		Method:  method,
+		Caller:  caller,
+		Digest:  digest,
+		Status:  nosql.IdempotencyPending,
+		Created: now.Unix(),
+		Lease:   now.Unix() + idempotencyLease(),
+		Expire:  now.Add(time.Duration(ttl) * time.Second),
+	}
+	ok, err := nosql.CreateIdempotency(db)
+	if err != nil {
+		return "", "", err
+	}
+	if ok {
+		return id, "", nil
+	}
+	deadline := now.Add(idempotencyWait)
+	for {
+		old, er := nosql.GetIdempotency(id)
+		if er != nil {
+			return "", "", er
+		}
+		if old.Digest != digest {
+			return "", "", ErrIdempotencyReused
+		}
+		if old.Status == nosql.IdempotencyDone {
+			logger.Infof("replay the result(%s) of idempotency key(%s) by %s", old.Result, key, caller)
+			return "", old.Result, nil
+		}
+		//之前的请求没有完成也没有释放，租约过期后由当前请求接管
+		stamp := time.Now().Unix()
+		if old.Lease < stamp {
+			ok, er = nosql.TakeoverIdempotency(id, old.Lease, stamp+idempotencyLease())
+			if er != nil {
+				return "", "", er
+			}
+			if ok {
+				logger.Warnf("take over the expired idempotency key(%s) by %s", key, caller)
+				return id, "", nil
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return "", "", ErrIdempotencyPending
+		}
+		select {
+		case <-ctx.Done():
+			return "", "", ErrIdempotencyPending
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+//保存创建的结果，相同的请求再次到达时直接返回
+func FinishIdempotent(id, result string) {
+	if len(id) < 1 {
+		return
+	}
+	err := nosql.UpdateIdempotencyResult(id, result)
+	if err != nil {
+		logger.Warn("save the idempotency result failed that msg = " + err.Error())
+	}
+}
+
+//创建失败时释放幂等键，允许重试
+func CancelIdempotent(id string) {
+	if len(id) < 1 {
+		return
+	}
+	_ = nosql.RemoveIdempotency(id)
+}
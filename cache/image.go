@@ -93,9 +93,14 @@ func clipFaces(asset, owner, url, group, quote, operator string, info *DetectFac
 	logger.Warn(fmt.Sprintf("clip faces that count = %d of asset = %s", len(info.Result.List), asset))
 	faces := make([]*DetectFace, 0, len(info.Result.List))
 	for _, item := range info.Result.List {
-		if item.Kind.Type == "human" && item.Kind.Probability >= 0.8 {
-			faces = append(faces, item)
+		if item.Kind == nil || item.Kind.Type != "human" || item.Kind.Probability < 0.8 {
+			continue
 		}
+		if ok, reason := checkFaceQuality(item); !ok {
+			logger.Infof("skip the face of asset = %s that %s", asset, reason)
+			continue
+		}
+		faces = append(faces, item)
 	}
 	if len(faces) < 1 {
 		return errors.New("not found the human faces")
@@ -126,6 +131,7 @@ func clipFaces(asset, owner, url, group, quote, operator string, info *DetectFac
 		//	logger.Error(fmt.Sprintf("search user face (%s) from group of %s, that err = %s", thumb.UID, group, er.Error()))
 		//}
 	}
+	publishEvent(EventFacesDetected, asset, &FacesEvent{Asset: asset, Owner: owner, Quote: quote, Count: len(faces)})
 	return nil
 }
 
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"image"
+	"io"
+	"net/http"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"strconv"
+	"strings"
+)
+
+const (
+	CodeTypeMismatch int = 201 //真实类型与声明的格式或者类型策略不符
+	CodeOverLimit    int = 202 //真实大小或者尺寸超出类型策略
+)
+
+//嗅探需要读取的文件头大小，图片的尺寸信息可能在比较靠后的位置
+const sniffHeadSize = 256 * 1024
+
+type sniffResult struct {
+	Mime   string
+	Size   uint64
+	Width  uint32
+	Height uint32
+}
+
+//自定义的文件头，http.DetectContentType识别不了的类型
+var customMagics = []struct {
+	offset int
+	magic  []byte
+	mime   string
+}{
+	{0, []byte("glTF"), "model/gltf-binary"},
+	{0, []byte("Kaydara FBX Binary"), "model/fbx"},
+	{0, []byte("UnityFS"), "application/vnd.unity"},
+	{4, []byte("ftypqt"), "video/quicktime"},
+}
+
+//mime类型对应的文件格式
+var mimeFormats = map[string][]string{
+	"image/jpeg":                   {"jpg", "jpeg"},
+	"image/png":                    {"png"},
+	"image/gif":                    {"gif"},
+	"image/bmp":                    {"bmp"},
+	"image/webp":                   {"webp"},
+	"image/x-icon":                 {"ico"},
+	"audio/mpeg":                   {"mp3"},
+	"audio/wave":                   {"wav"},
+	"audio/aiff":                   {"aif", "aiff"},
+	"audio/midi":                   {"mid", "midi"},
+	"application/ogg":              {"ogg", "oga", "ogv"},
+	"video/mp4":                    {"mp4", "m4v", "m4a"},
+	"video/webm":                   {"webm"},
+	"video/avi":                    {"avi"},
+	"video/quicktime":              {"mov"},
+	"application/pdf":              {"pdf"},
+	"application/zip":              {"zip", "apk", "docx", "xlsx", "pptx", "unitypackage"},
+	"application/x-gzip":           {"gz", "tgz"},
+	"application/x-rar-compressed": {"rar"},
+	"model/gltf-binary":            {"glb"},
+	"model/fbx":                    {"fbx"},
+	"application/vnd.unity":        {"ab", "unity3d", "bundle"},
+}
+
+func getTypePolicy(tp uint8) *config.PolicyConfig {
+	for i := 0; i < len(config.Schema.Policies); i += 1 {
+		if config.Schema.Policies[i].Type == tp {
+			return &config.Schema.Policies[i]
+		}
+	}
+	return nil
+}
+
+func formatOf(format string) string {
+	tmp := strings.ToLower(strings.TrimSpace(format))
+	if i := strings.LastIndex(tmp, "/"); i > -1 {
+		tmp = tmp[i+1:]
+	}
+	return strings.TrimPrefix(tmp, ".")
+}
+
+func formatAllowed(policy *config.PolicyConfig, format string) bool {
+	if policy == nil || len(policy.Formats) < 1 {
+		return true
+	}
+	for _, item := range policy.Formats {
+		if strings.ToLower(item) == format {
+			return true
+		}
+	}
+	return false
+}
+
+//上传时根据声明的信息检查资源类型的策略
+func CheckAssetPolicy(tp uint8, format string, size uint64, width, height uint32) error {
+	policy := getTypePolicy(tp)
+	if policy == nil {
+		return nil
+	}
+	if !formatAllowed(policy, formatOf(format)) {
+		return fmt.Errorf("the format(%s) not allowed of the asset type(%d)", format, tp)
+	}
+	return checkPolicyLimit(policy, size, width, height)
+}
+
+func checkPolicyLimit(policy *config.PolicyConfig, size uint64, width, height uint32) error {
+	if policy.Size > 0 && size > policy.Size {
+		return fmt.Errorf("the size(%d) is over the limit(%d)", size, policy.Size)
+	}
+	if policy.Width > 0 && width > policy.Width {
+		return fmt.Errorf("the width(%d) is over the limit(%d)", width, policy.Width)
+	}
+	if policy.Height > 0 && height > policy.Height {
+		return fmt.Errorf("the height(%d) is over the limit(%d)", height, policy.Height)
+	}
+	return nil
+}
+
+func sniffMime(head []byte) string {
+	for _, item := range customMagics {
+		if len(head) > item.offset && bytes.HasPrefix(head[item.offset:], item.magic) {
+			return item.mime
+		}
+	}
+	mime := http.DetectContentType(head)
+	if i := strings.Index(mime, ";"); i > -1 {
+		mime = mime[:i]
+	}
+	return mime
+}
+
+//只读取文件头来检测真实的类型和图片尺寸
+func sniffAsset(url string) (*sniffResult, error) {
+	if len(url) < 1 {
+		return nil, errors.New("the url is empty")
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", sniffHeadSize-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("read the file failed that code = %d", resp.StatusCode)
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, sniffHeadSize))
+	if err != nil {
+		return nil, err
+	}
+	result := new(sniffResult)
+	result.Mime = sniffMime(head)
+	result.Size = uint64(len(head))
+	if resp.StatusCode == http.StatusPartialContent {
+		//Content-Range: bytes 0-262143/1234567
+		rg := resp.Header.Get("Content-Range")
+		if i := strings.LastIndex(rg, "/"); i > -1 {
+			total, er := strconv.ParseUint(rg[i+1:], 10, 64)
+			if er == nil {
+				result.Size = total
+			}
+		}
+	} else if resp.ContentLength > 0 {
+		result.Size = uint64(resp.ContentLength)
+	}
+	if strings.HasPrefix(result.Mime, "image/") {
+		cfg, _, er := image.DecodeConfig(bytes.NewReader(head))
+		if er == nil {
+			result.Width = uint32(cfg.Width)
+			result.Height = uint32(cfg.Height)
+		}
+	}
+	return result, nil
+}
+
+//入库后的检测流程：嗅探真实类型，按类型策略检查，病毒扫描，通过后再进行内容审核和人脸识别
+func inspectAsset(info *AssetInfo) {
+	result, err := sniffAsset(GetURL(info.UUID, false))
+	if err != nil {
+		//文件还不能访问的时候按照声明的格式继续处理
+		logger.Warn("sniff asset failed that uid = " + info.UID + " and msg = " + err.Error())
+	} else {
+		err = commitUpdate(func(ctx context.Context) error {
+			return nosql.UpdateAssetMime(ctx, info.UID, result.Mime)
+		}, AuditSystem, AuditAsset, info.UID, "mime", info.Mime, result.Mime)
+		if err != nil {
+			logger.Warn("update asset mime failed that uid = " + info.UID + " and msg = " + err.Error())
+		}
+		info.Mime = result.Mime
+		code := checkSniffResult(info, result)
+		if code != Detected_Pend {
+			logger.Warn(fmt.Sprintf("the asset(%s) inspect failed that code = %d and mime = %s", info.UID, code, result.Mime))
+			if err = updateAssetCode(info.UID, info.Code, code); err != nil {
+				logger.Warn("set asset code failed that uid = " + info.UID + " and msg = " + err.Error())
+			}
+			info.Code = code
+			//检查不通过的资源也要扫描，否则一直处于待扫描的隔离状态
+			scanAsset(info)
+			return
+		}
+	}
+	if scanAsset(info) == ScanInfected {
+		return
+	}
+	if info.SupportFace() {
+		validateAsset(info)
+	}
+}
+
+func checkSniffResult(info *AssetInfo, result *sniffResult) int {
+	formats, ok := mimeFormats[result.Mime]
+	declared := formatOf(info.Format)
+	if ok && len(declared) > 0 && !tool.HasItem(formats, declared) {
+		return CodeTypeMismatch
+	}
+	policy := getTypePolicy(info.Type)
+	if policy == nil {
+		return Detected_Pend
+	}
+	if ok && len(policy.Formats) > 0 {
+		allowed := false
+		for _, format := range formats {
+			if formatAllowed(policy, format) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return CodeTypeMismatch
+		}
+	}
+	if checkPolicyLimit(policy, result.Size, result.Width, result.Height) != nil {
+		return CodeOverLimit
+	}
+	return Detected_Pend
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,6 +18,7 @@ type LabelInfo struct {
 	UID      string `json:"uid"`
 	Creator  string
 	Operator string
+	Revision uint64
 
 	Type uint8
 
@@ -42,6 +44,7 @@ func (mine *cacheContext) CreateLabel(in *pb.ReqLabelAdd) (*LabelInfo, error) {
 	if err == nil {
 		info := new(LabelInfo)
 		info.initInfo(db)
+		writeAudit(in.Operator, AuditCreate, AuditLabel, info.UID, nil, info.auditData())
 		return info, nil
 	}
 	return nil, err
@@ -52,7 +55,14 @@ func (mine *cacheContext) RemoveLabel(uid, operator string) error {
 	if num > 0 {
 		return errors.New("the folder not empty")
 	}
-	return nosql.RemoveLabel(uid, operator)
+	db, _ := nosql.GetLabel(uid)
+	err := nosql.RemoveLabel(uid, operator)
+	if err == nil && db != nil {
+		info := new(LabelInfo)
+		info.initInfo(db)
+		writeAudit(operator, AuditRemove, AuditLabel, uid, info.auditData(), nil)
+	}
+	return err
 }
 
 func (mine *cacheContext) GetLabel(uid string) (*LabelInfo, error) {
@@ -130,6 +140,7 @@ func (mine *LabelInfo) initInfo(db *nosql.Label) {
 	mine.Updated = db.Updated
 	mine.Creator = db.Creator
 	mine.Operator = db.Operator
+	mine.Revision = db.Revision
 	mine.Scene = db.Scene
 	mine.Name = db.Name
 	mine.Type = db.Type
@@ -141,16 +152,25 @@ func (mine *LabelInfo) GetChildCount() uint32 {
 	return uint32(num)
 }
 
-func (mine *LabelInfo) UpdateBase(name, remark, operator string) error {
-	had, er := cacheCtx.HadLabel(name)
-	if er != nil {
-		return er
-	}
-	if had {
-		return errors.New("the name had exited")
+func (mine *LabelInfo) UpdateBase(name, remark, operator string, revision int64) error {
+	//只修改备注时名字不变，不需要检查重名
+	if name != mine.Name {
+		had, er := cacheCtx.HadLabel(name)
+		if er != nil {
+			return er
+		}
+		if had {
+			return errors.New("the name had exited")
+		}
 	}
 
-	err := nosql.UpdateLabelBase(mine.UID, name, remark, operator)
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateLabelBase(ctx, mine.UID, name, remark, operator, revision)
+		if er != nil {
+			return er
+		}
+		return recordAudit(ctx, operator, "update_base", AuditLabel, mine.UID, map[string]interface{}{"name": mine.Name, "remark": mine.Remark}, map[string]interface{}{"name": name, "remark": remark})
+	})
 	if err == nil {
 		mine.Name = name
 		mine.Remark = remark
@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"omo.msa.asset/proxy/nosql"
+	"strings"
+)
+
+const AuditPatch = "patch"
+
+//字段掩码或者字段的值不合法
+var ErrPatchInvalid = errors.New("the patch is invalid")
+
+//修订号和预期的不一致，数据已经被其他人修改
+var ErrConflict = nosql.ErrRevisionConflict
+
+const RevisionAny = nosql.RevisionAny
+
+//可以通过字段掩码修改的字段，field为数据库里的字段名
+type patchSpec struct {
+	field string
+	parse func(raw json.RawMessage) (interface{}, error)
+}
+
+var assetPatchSpecs = map[string]patchSpec{
+	"name":     {"name", patchText(true)},
+	"remark":   {"remark", patchText(false)},
+	"meta":     {"meta", patchText(false)},
+	"language": {"language", patchText(false)},
+	"owner":    {"owner", patchText(true)},
+	"quote":    {"quote", patchText(false)},
+	"links":    {"links", patchList},
+	"tags":     {"tags", patchList},
+	"type":     {"type", patchUint8(AssetTypeCertify)},
+	"status":   {"status", patchUint8(StatusVisible)},
+	"scope":    {"scope", patchUint8(AssetScopeSystem)},
+	"weight":   {"weight", patchUint32},
+}
+
+//人脸的用户需要同时迁移人脸库和人物，只能通过review_reassign或者bind修改
+var thumbPatchSpecs = map[string]patchSpec{
+	"owner":   {"owner", patchText(true)},
+	"quote":   {"quote", patchText(false)},
+	"meta":    {"meta", patchText(false)},
+	"similar": {"similar", patchFloat},
+}
+
+var folderPatchSpecs = map[string]patchSpec{
+	"name":   {"name", patchText(true)},
+	"remark": {"face", patchText(false)},
+	"parent": {"parent", patchText(false)},
+	"cover":  {"cover", patchText(false)},
+	"access": {"access", patchUint8(FolderAccessPublic)},
+	"tags":   {"tags", patchList},
+	"users":  {"users", patchList},
+}
+
+var labelPatchSpecs = map[string]patchSpec{
+	"name":   {"name", patchText(true)},
+	"remark": {"face", patchText(false)},
+	"type":   {"type", patchUint8(255)},
+}
+
+func patchText(required bool) func(raw json.RawMessage) (interface{}, error) {
+	return func(raw json.RawMessage) (interface{}, error) {
+		var val string
+		if err := json.Unmarshal(raw, &val); err != nil {
+			return nil, errors.New("need a string")
+		}
+		if required && len(strings.TrimSpace(val)) < 1 {
+			return nil, errors.New("can not be empty")
+		}
+		return val, nil
+	}
+}
+
+func patchList(raw json.RawMessage) (interface{}, error) {
+	var val []string
+	if err := json.Unmarshal(raw, &val); err != nil {
+		return nil, errors.New("need a string array")
+	}
+	if val == nil {
+		val = make([]string, 0, 1)
+	}
+	return val, nil
+}
+
+func patchUint8(max uint8) func(raw json.RawMessage) (interface{}, error) {
+	return func(raw json.RawMessage) (interface{}, error) {
+		var val uint64
+		if err := json.Unmarshal(raw, &val); err != nil {
+			return nil, errors.New("need an unsigned integer")
+		}
+		if val > uint64(max) {
+			return nil, fmt.Errorf("must not be greater than %d", max)
+		}
+		return uint8(val), nil
+	}
+}
+
+func patchUint32(raw json.RawMessage) (interface{}, error) {
+	var val uint32
+	if err := json.Unmarshal(raw, &val); err != nil {
+		return nil, errors.New("need an unsigned integer")
+	}
+	return val, nil
+}
+
+func patchFloat(raw json.RawMessage) (interface{}, error) {
+	var val float32
+	if err := json.Unmarshal(raw, &val); err != nil {
+		return nil, errors.New("need a number")
+	}
+	return val, nil
+}
+
+//校验掩码里的每个字段，全部通过后才返回需要写入的内容
+func buildPatch(specs map[string]patchSpec, mask []string, values map[string]json.RawMessage) (bson.M, error) {
+	if len(mask) < 1 {
+		return nil, fmt.Errorf("%w that the field mask is empty", ErrPatchInvalid)
+	}
+	fields := bson.M{}
+	for _, key := range mask {
+		spec, ok := specs[key]
+		if !ok {
+			return nil, fmt.Errorf("%w that the field(%s) is not supported", ErrPatchInvalid, key)
+		}
+		raw, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("%w that the value of field(%s) is missing", ErrPatchInvalid, key)
+		}
+		val, err := spec.parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w that the field(%s) %s", ErrPatchInvalid, key, err.Error())
+		}
+		fields[spec.field] = val
+	}
+	return fields, nil
+}
+
+//在一次写操作里修改掩码里的所有字段，revision为预期的修订号，小于0时不检查
+func (mine *AssetInfo) Patch(operator string, revision int64, mask []string, values map[string]json.RawMessage) error {
+	fields, err := buildPatch(assetPatchSpecs, mask, values)
+	if err != nil {
+		return err
+	}
+	var db *nosql.Asset
+	before := mine.auditData()
+	err = commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateAssetFields(ctx, mine.UID, operator, revision, fields)
+		if er != nil {
+			return er
+		}
+		db, er = nosql.GetAssetIn(ctx, mine.UID)
+		if er != nil {
+			return er
+		}
+		after := new(AssetInfo)
+		after.initInfo(db)
+		return recordAudit(ctx, operator, AuditPatch, AuditAsset, mine.UID, before, after.auditData())
+	})
+	if err != nil {
+		return err
+	}
+	group := mine.CheckFaceGroup()
+	mine.initInfo(db)
+	if group != mine.CheckFaceGroup() {
+		mine.migrateFaces(operator)
+	}
+	return nil
+}
+
+func (mine *ThumbInfo) Patch(operator string, revision int64, mask []string, values map[string]json.RawMessage) error {
+	fields, err := buildPatch(thumbPatchSpecs, mask, values)
+	if err != nil {
+		return err
+	}
+	var db *nosql.Thumb
+	before := mine.auditData()
+	err = commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateThumbFields(ctx, mine.UID, operator, revision, fields)
+		if er != nil {
+			return er
+		}
+		db, er = nosql.GetThumbIn(ctx, mine.UID)
+		if er != nil {
+			return er
+		}
+		//initInfo可能在事务外补写分组，这里只取审计需要的字段
+		after := &ThumbInfo{Asset: db.Asset, Owner: db.Owner, User: db.User, Quote: db.Quote, File: db.File, Similar: db.Similar, Meta: db.Meta}
+		return recordAudit(ctx, operator, AuditPatch, AuditThumb, mine.UID, before, after.auditData())
+	})
+	if err != nil {
+		return err
+	}
+	mine.initInfo(db)
+	return nil
+}
+
+func (mine *FolderInfo) Patch(operator string, revision int64, mask []string, values map[string]json.RawMessage) error {
+	fields, err := buildPatch(folderPatchSpecs, mask, values)
+	if err != nil {
+		return err
+	}
+	if parent, ok := fields["parent"].(string); ok && parent == mine.UID {
+		return fmt.Errorf("%w that the parent can not be self", ErrPatchInvalid)
+	}
+	var db *nosql.Folder
+	before := mine.auditData()
+	err = commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateFolderFields(ctx, mine.UID, operator, revision, fields)
+		if er != nil {
+			return er
+		}
+		db, er = nosql.GetFolderIn(ctx, mine.UID)
+		if er != nil {
+			return er
+		}
+		after := new(FolderInfo)
+		after.initInfo(db)
+		return recordAudit(ctx, operator, AuditPatch, AuditFolder, mine.UID, before, after.auditData())
+	})
+	if err != nil {
+		return err
+	}
+	mine.initInfo(db)
+	return nil
+}
+
+func (mine *LabelInfo) Patch(operator string, revision int64, mask []string, values map[string]json.RawMessage) error {
+	fields, err := buildPatch(labelPatchSpecs, mask, values)
+	if err != nil {
+		return err
+	}
+	var db *nosql.Label
+	before := mine.auditData()
+	err = commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateLabelFields(ctx, mine.UID, operator, revision, fields)
+		if er != nil {
+			return er
+		}
+		db, er = nosql.GetLabelIn(ctx, mine.UID)
+		if er != nil {
+			return er
+		}
+		after := new(LabelInfo)
+		after.initInfo(db)
+		return recordAudit(ctx, operator, AuditPatch, AuditLabel, mine.UID, before, after.auditData())
+	})
+	if err != nil {
+		return err
+	}
+	mine.initInfo(db)
+	return nil
+}
@@ -0,0 +1,497 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"omo.msa.asset/proxy/nosql"
+	"strings"
+	"time"
+)
+
+//人脸库里的一个人，同一个人的人脸的User相同
+type PersonInfo struct {
+	UID      string `json:"uid"`
+	Created  int64  `json:"created"`
+	Updated  int64  `json:"updated"`
+	Creator  string `json:"creator"`
+	Operator string `json:"operator"`
+	Revision uint64 `json:"revision"`
+
+	Group  string `json:"group"`
+	Key    string `json:"key"`
+	Face   string `json:"-"`
+	Name   string `json:"name"`
+	Cover  string `json:"cover"`
+	Entity string `json:"entity"`
+	Quote  string `json:"quote"`
+	Count  uint32 `json:"count"`
+}
+
+func (mine *PersonInfo) initInfo(db *nosql.Person) {
+	mine.UID = db.UID.Hex()
+	mine.Created = db.Created
+	mine.Updated = db.Updated
+	mine.Creator = db.Creator
+	mine.Operator = db.Operator
+	mine.Revision = db.Revision
+	mine.Group = db.Group
+	mine.Key = db.Key
+	mine.Face = db.Face
+	mine.Name = db.Name
+	mine.Cover = db.Cover
+	mine.Entity = db.Entity
+	mine.Quote = db.Quote
+	mine.Count = db.Count
+}
+
+func (mine *PersonInfo) auditData() map[string]interface{} {
+	return map[string]interface{}{
+		"group":  mine.Group,
+		"key":    mine.Key,
+		"name":   mine.Name,
+		"cover":  mine.Cover,
+		"entity": mine.Entity,
+		"count":  mine.Count,
+	}
+}
+
+func (mine *cacheContext) GetPerson(uid string) (*PersonInfo, error) {
+	db, err := nosql.GetPerson(uid)
+	if err != nil {
+		return nil, err
+	}
+	if db.Deleted > 0 {
+		return nil, errors.New("the person had removed")
+	}
+	info := new(PersonInfo)
+	info.initInfo(db)
+	return info, nil
+}
+
+//场景里出现的人，人在人脸注册时创建，这里只读取
+func (mine *cacheContext) GetPersonsByQuote(quote string) ([]*PersonInfo, error) {
+	if len(quote) < 1 {
+		return nil, errors.New("the quote is empty")
+	}
+	keys, err := nosql.GetPersonKeysByQuote(quote)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*PersonInfo, 0, len(keys))
+	for _, key := range keys {
+		db, er := nosql.GetPersonByKey(key.Group, key.User)
+		if er != nil {
+			continue
+		}
+		info := new(PersonInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+//人脸保存或者改变用户后，确保人脸库和用户对应的人存在，并更新人脸数量
+func ensurePerson(group, user, quote, cover, operator string) error {
+	if len(group) < 1 || len(user) < 1 {
+		return nil
+	}
+	tmp := &nosql.Person{
+		Group:   group,
+		Key:     user,
+		Face:    user,
+		Cover:   cover,
+		Quote:   quote,
+		Creator: operator,
+		Count:   nosql.GetThumbCountByPerson(group, user),
+	}
+	if !strings.Contains(user, "temp_") {
+		tmp.Entity = user
+	}
+	_, err := nosql.UpsertPerson(tmp)
+	return err
+}
+
+//启动时补齐之前没有创建的人
+func backfillPersons() {
+	keys, err := nosql.GetAllPersonKeys()
+	if err != nil {
+		logger.Warn("get the person keys failed that msg = " + err.Error())
+		return
+	}
+	num := 0
+	for _, key := range keys {
+		if _, er := nosql.GetPersonByKey(key.Group, key.User); er == nil {
+			continue
+		}
+		er := ensurePerson(key.Group, key.User, key.Quote, key.Cover, AuditSystem)
+		if er != nil {
+			logger.Warn(fmt.Sprintf("create the person(%s) of group(%s) failed that msg = %s", key.User, key.Group, er.Error()))
+			continue
+		}
+		num += 1
+	}
+	if num > 0 {
+		logger.Infof("backfill the persons count = %d", num)
+	}
+}
+
+//人脸的用户改为实体后，对应的人也跟着改变；实体在人脸库里已经有人时，合并到已有的人
+func bindPersonsEntity(user, entity, operator string) error {
+	dbs, err := nosql.GetPersonsByKey(user)
+	if err != nil {
+		return err
+	}
+	for _, db := range dbs {
+		exist, er := nosql.GetPersonByKey(db.Group, entity)
+		if er == nil && exist.UID != db.UID {
+			er = nosql.RemovePerson(db.UID.Hex(), operator)
+			if er != nil {
+				return er
+			}
+			old := new(PersonInfo)
+			old.initInfo(db)
+			writeAudit(operator, AuditRemove, AuditPerson, old.UID, old.auditData(), map[string]interface{}{"merged": exist.UID.Hex()})
+			info := new(PersonInfo)
+			info.initInfo(exist)
+			_ = info.refresh(operator)
+			continue
+		}
+		er = nosql.UpdatePersonEntity(db.UID.Hex(), entity, operator)
+		if er != nil {
+			return er
+		}
+		auditUpdate(operator, AuditPerson, db.UID.Hex(), "key", db.Key, entity)
+	}
+	return nil
+}
+
+//用于权限判断，一个人可以修改需要对他的所有人脸都有写权限
+func (mine *PersonInfo) GetThumbs() []*ThumbInfo {
+	dbs, err := nosql.GetThumbsByPerson(mine.Group, mine.Key)
+	if err != nil {
+		return make([]*ThumbInfo, 0, 1)
+	}
+	list := make([]*ThumbInfo, 0, len(dbs))
+	for _, db := range dbs {
+		info := new(ThumbInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	return list
+}
+
+func (mine *PersonInfo) Rename(name, operator string) error {
+	err := nosql.UpdatePersonName(mine.UID, name, operator)
+	if err == nil {
+		auditUpdate(operator, AuditPerson, mine.UID, "name", mine.Name, name)
+		mine.Name = name
+		mine.Operator = operator
+	}
+	return err
+}
+
+//把另外一个人的人脸合并进来，人脸库里的人脸也一起移动
+func (mine *PersonInfo) Merge(from *PersonInfo, operator string) error {
+	if from.UID == mine.UID {
+		return errors.New("can not merge the person to self")
+	}
+	if from.Group != mine.Group {
+		return errors.New("the persons are not in the same face group")
+	}
+	before := mine.auditData()
+	moved := make([]string, 0, faceUserLimit)
+	if from.Face != mine.Face {
+		tokens, err := getProviderTokens(mine.Group, from.Face)
+		if err != nil {
+			return err
+		}
+		err = checkProviderCapacity(mine.Group, mine.Face, len(tokens))
+		if err != nil {
+			return err
+		}
+		err = moveProviderTokens(mine.Group, from.Face, mine.Face, tokens)
+		if err != nil {
+			return err
+		}
+		moved = tokens
+	}
+	_, err := nosql.UpdateThumbsPerson(mine.Group, from.Key, mine.Key, operator, nil)
+	if err != nil {
+		if len(moved) > 0 {
+			_ = moveProviderTokens(mine.Group, mine.Face, from.Face, moved)
+		}
+		return err
+	}
+	err = nosql.RemovePerson(from.UID, operator)
+	if err != nil {
+		return err
+	}
+	writeAudit(operator, AuditRemove, AuditPerson, from.UID, from.auditData(), nil)
+	if len(mine.Entity) < 1 {
+		mine.Entity = from.Entity
+	}
+	if len(mine.Name) < 1 && len(from.Name) > 0 {
+		_ = mine.Rename(from.Name, operator)
+	}
+	err = mine.refresh(operator)
+	if err == nil {
+		after := mine.auditData()
+		after["merged"] = from.UID
+		writeAudit(operator, "merge", AuditPerson, mine.UID, before, after)
+	}
+	return err
+}
+
+//把分错的人脸拆分成一个新的人
+func (mine *PersonInfo) Split(thumbs []string, name, operator string) (*PersonInfo, error) {
+	if len(thumbs) < 1 {
+		return nil, errors.New("the thumbs is empty")
+	}
+	dbs := make([]*nosql.Thumb, 0, len(thumbs))
+	for _, uid := range thumbs {
+		db, err := nosql.GetThumb(uid)
+		if err != nil || db.Deleted > 0 {
+			return nil, fmt.Errorf("the thumb(%s) not found", uid)
+		}
+		if db.Group != mine.Group || db.User != mine.Key {
+			return nil, fmt.Errorf("the thumb(%s) not belong to the person", uid)
+		}
+		dbs = append(dbs, db)
+	}
+	if uint32(len(dbs)) >= nosql.GetThumbCountByPerson(mine.Group, mine.Key) {
+		return nil, errors.New("can not split all the thumbs of the person")
+	}
+	if len(dbs) > faceUserLimit {
+		return nil, fmt.Errorf("can not split more than %d thumbs once", faceUserLimit)
+	}
+	db := new(nosql.Person)
+	db.UID = primitive.NewObjectID()
+	db.Created = time.Now().Unix()
+	db.Updated = db.Created
+	db.Creator = operator
+	db.Operator = operator
+	db.Group = mine.Group
+	db.Key = "temp_" + db.UID.Hex()
+	db.Face = db.Key
+	db.Name = name
+	db.Cover = dbs[0].UID.Hex()
+	db.Quote = mine.Quote
+	db.Count = uint32(len(dbs))
+	//没有face_token的旧人脸先用图片注册，失败时撤销已经注册的人脸
+	registered := make([]*nosql.Thumb, 0, len(dbs))
+	tokens := make([]string, 0, len(dbs))
+	for _, thumb := range dbs {
+		if len(thumb.Face) > 0 {
+			tokens = append(tokens, thumb.Face)
+			continue
+		}
+		token, err := registerThumbFace(mine.Group, db.Face, thumb)
+		if err != nil {
+			rollbackThumbFaces(mine.Group, db.Face, registered)
+			return nil, err
+		}
+		if len(token) > 0 {
+			thumb.Face = token
+			registered = append(registered, thumb)
+		}
+		logger.Warn(fmt.Sprintf("the thumb(%s) has no face token, the old face of user(%s) is kept", thumb.UID.Hex(), mine.Face))
+	}
+	err := moveProviderTokens(mine.Group, mine.Face, db.Face, tokens)
+	if err != nil {
+		rollbackThumbFaces(mine.Group, db.Face, registered)
+		return nil, err
+	}
+	_, err = nosql.UpdateThumbsPerson(mine.Group, mine.Key, db.Key, operator, thumbs)
+	if err != nil {
+		_ = moveProviderTokens(mine.Group, db.Face, mine.Face, tokens)
+		rollbackThumbFaces(mine.Group, db.Face, registered)
+		return nil, err
+	}
+	err = nosql.CreatePerson(db)
+	if err != nil {
+		return nil, err
+	}
+	info := new(PersonInfo)
+	info.initInfo(db)
+	writeAudit(operator, AuditCreate, AuditPerson, info.UID, nil, map[string]interface{}{"group": info.Group, "key": info.Key, "name": info.Name, "from": mine.UID, "thumbs": thumbs})
+	before := mine.auditData()
+	err = mine.refresh(operator)
+	if err == nil {
+		writeAudit(operator, "split", AuditPerson, mine.UID, before, mine.auditData())
+	}
+	return info, err
+}
+
+//重新统计人脸数量，封面不属于这个人时换成第一个人脸
+func (mine *PersonInfo) refresh(operator string) error {
+	dbs, err := nosql.GetThumbsByPerson(mine.Group, mine.Key)
+	if err != nil {
+		return err
+	}
+	cover := ""
+	for _, db := range dbs {
+		if db.UID.Hex() == mine.Cover || len(cover) < 1 {
+			cover = db.UID.Hex()
+		}
+	}
+	err = nosql.UpdatePersonBase(mine.UID, cover, mine.Entity, operator, uint32(len(dbs)))
+	if err == nil {
+		mine.Cover = cover
+		mine.Count = uint32(len(dbs))
+		mine.Operator = operator
+	}
+	return err
+}
+
+//人脸删除或者移动后，重新统计人脸库和用户对应的人，没有人脸的人删除
+func refreshPersons(pairs map[[2]string]bool, operator string) {
+	for pair := range pairs {
+		group, user := pair[0], pair[1]
+		person, err := nosql.GetPersonByKey(group, user)
+		if err != nil {
+			continue
+		}
+		info := new(PersonInfo)
+		info.initInfo(person)
+		if nosql.GetThumbCountByPerson(group, user) < 1 {
+			_ = nosql.RemovePerson(info.UID, operator)
+		} else {
+			_ = info.refresh(operator)
+		}
+	}
+}
+
+//人脸库里用户的所有face_token，用户不存在时为空
+func getProviderTokens(group, user string) ([]string, error) {
+	resp, code, err := getFacesByGroup(group, user)
+	if err != nil {
+		if code == ErrorCodeUserNone {
+			return make([]string, 0, 1), nil
+		}
+		return nil, err
+	}
+	list := make([]string, 0, len(resp.FaceList))
+	for _, face := range resp.FaceList {
+		list = append(list, face.Token)
+	}
+	return list, nil
+}
+
+//人脸库里一个用户最多faceUserLimit个人脸，移动前检查目标用户是否放得下
+func checkProviderCapacity(group, user string, more int) error {
+	tokens, err := getProviderTokens(group, user)
+	if err != nil {
+		return err
+	}
+	if len(tokens)+more > faceUserLimit {
+		return fmt.Errorf("the faces of user(%s) will be %d, over the limit %d", user, len(tokens)+more, faceUserLimit)
+	}
+	return nil
+}
+
+//人脸库里的人脸从一个用户移动到另一个用户，先全部添加，失败时撤销已经添加的，全部成功后再从原来的用户删除
+func moveProviderTokens(group, from, to string, tokens []string) error {
+	added := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		err := addProviderFace(group, to, token)
+		if err != nil {
+			for _, item := range added {
+				if er := removeFace(0, item, to, group); er != nil {
+					logger.Warn(fmt.Sprintf("rollback the face(%s) of user(%s) failed that msg = %s", item, to, er.Error()))
+				}
+			}
+			return err
+		}
+		added = append(added, token)
+	}
+	for _, token := range tokens {
+		err := removeFace(0, token, from, group)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("remove the face(%s) of user(%s) failed that msg = %s", token, from, err.Error()))
+		}
+	}
+	return nil
+}
+
+//撤销用图片重新注册的人脸，并清空保存的face_token
+func rollbackThumbFaces(group, user string, thumbs []*nosql.Thumb) {
+	for _, thumb := range thumbs {
+		err := removeFace(0, thumb.Face, user, group)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("rollback the face(%s) of user(%s) failed that msg = %s", thumb.Face, user, err.Error()))
+		}
+		if nosql.UpdateThumbFace(thumb.UID.Hex(), "") == nil {
+			auditUpdate(AuditSystem, AuditThumb, thumb.UID.Hex(), "face", thumb.Face, "")
+		}
+	}
+}
+
+//没有face_token的旧人脸重新用图片注册
+func moveProviderFace(group, from, to string, thumb *nosql.Thumb) error {
+	if len(thumb.Face) > 0 {
+		err := addProviderFace(group, to, thumb.Face)
+		if err != nil {
+			return err
+		}
+		err = removeFace(0, thumb.Face, from, group)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("remove the face(%s) of user(%s) failed that msg = %s", thumb.Face, from, err.Error()))
+		}
+		return nil
+	}
+	_, err := registerThumbFace(group, to, thumb)
+	if err != nil {
+		return err
+	}
+	logger.Warn(fmt.Sprintf("the thumb(%s) has no face token, the old face of user(%s) is kept", thumb.UID.Hex(), from))
+	return nil
+}
+
+//用人脸图片重新注册到人脸库，并保存新的face_token
+func registerThumbFace(group, user string, thumb *nosql.Thumb) (string, error) {
+	if len(thumb.File) < 1 {
+		return "", fmt.Errorf("the thumb(%s) has no file", thumb.UID.Hex())
+	}
+	_, bs64, err := downloadAssetToB64(GetURL(thumb.File, true))
+	if err != nil {
+		return "", err
+	}
+	req := new(FaceAddReq)
+	req.Type = ImageTypeBase64
+	req.Image = bs64
+	req.Group = group
+	req.User = user
+	req.Quality = QualityLow
+	req.Action = "APPEND"
+	req.Meta = fmt.Sprintf(`"user":"%s", "thumb":"%s"`, user, thumb.UID.Hex())
+	result, code, err := registerUserFace(req)
+	if err != nil && code != ErrorCodeFaceExist {
+		return "", err
+	}
+	if result == nil || len(result.Token) < 1 {
+		return "", nil
+	}
+	err = nosql.UpdateThumbFace(thumb.UID.Hex(), result.Token)
+	if err == nil {
+		auditUpdate(AuditSystem, AuditThumb, thumb.UID.Hex(), "face", thumb.Face, result.Token)
+	}
+	return result.Token, err
+}
+
+func addProviderFace(group, user, token string) error {
+	req := new(FaceAddReq)
+	req.Type = ImageTypeFace
+	req.Image = token
+	req.Group = group
+	req.User = user
+	req.Quality = QualityNone
+	req.Action = "APPEND"
+	req.Meta = fmt.Sprintf(`"user":"%s"`, user)
+	_, code, err := registerUserFace(req)
+	if err != nil && code != ErrorCodeFaceExist {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"math"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy"
+	"omo.msa.asset/proxy/nosql"
+)
+
+//人脸是否满足配置的质量要求，不满足时返回原因
+func checkFaceQuality(face *DetectFace) (bool, string) {
+	cof := config.Schema.Face
+	if cof.Probability > 0 && face.Probability < cof.Probability {
+		return false, fmt.Sprintf("probability %.2f", face.Probability)
+	}
+	if cof.Blur > 0 && face.Quality.Blur > cof.Blur {
+		return false, fmt.Sprintf("blur %.2f", face.Quality.Blur)
+	}
+	if cof.Size > 0 && (face.Location.Width < cof.Size || face.Location.Height < cof.Size) {
+		return false, fmt.Sprintf("size %dx%d", face.Location.Width, face.Location.Height)
+	}
+	if face.Angle != nil {
+		if cof.Yaw > 0 && abs32(face.Angle.Yaw) > cof.Yaw {
+			return false, fmt.Sprintf("yaw %.1f", face.Angle.Yaw)
+		}
+		if cof.Pitch > 0 && abs32(face.Angle.Pitch) > cof.Pitch {
+			return false, fmt.Sprintf("pitch %.1f", face.Angle.Pitch)
+		}
+		if cof.Roll > 0 && abs32(face.Angle.Roll) > cof.Roll {
+			return false, fmt.Sprintf("roll %.1f", face.Angle.Roll)
+		}
+	}
+	if cof.Occlusion > 0 && face.Quality.Occlusion != nil {
+		if max := face.Quality.Occlusion.max(); max > cof.Occlusion {
+			return false, fmt.Sprintf("occlusion %.2f", max)
+		}
+	}
+	return true, ""
+}
+
+func (mine *OcclusionInfo) max() float32 {
+	list := []float32{mine.LeftEye, mine.RightEye, mine.Nose, mine.Mouth, mine.LeftCheek, mine.RightCheek, mine.Chin}
+	var max float32 = 0
+	for _, item := range list {
+		if item > max {
+			max = item
+		}
+	}
+	return max
+}
+
+func abs32(val float32) float32 {
+	return float32(math.Abs(float64(val)))
+}
+
+//人脸的质量分数，越清晰、越正的人脸分数越高
+func faceScore(probably, blur float32, attr proxy.FaceAttribute) float32 {
+	angle := (abs32(attr.Yaw) + abs32(attr.Pitch) + abs32(attr.Roll)) / 270
+	if angle > 1 {
+		angle = 1
+	}
+	return probably * (1 - blur) * (1 - angle)
+}
+
+//人脸库里的用户人脸数量达到上限时，删除质量最低的人脸；当前人脸质量更低时返回false
+func (mine *ThumbInfo) replaceWorstFace(user, group string) (bool, error) {
+	key := user
+	keys, _ := nosql.GetPersonKeysByFaces(group, []string{user})
+	if val, ok := keys[user]; ok {
+		key = val
+	}
+	dbs, err := nosql.GetThumbsByPerson(group, key)
+	if err != nil {
+		return false, err
+	}
+	var worst *nosql.Thumb
+	var low float32 = 0
+	for _, db := range dbs {
+		if len(db.Face) < 1 || db.UID.Hex() == mine.UID {
+			continue
+		}
+		score := faceScore(db.Probably, db.Blur, db.Attribute)
+		if worst == nil || score < low {
+			worst = db
+			low = score
+		}
+	}
+	if worst == nil {
+		return false, fmt.Errorf("the user(%s) has no replaceable face", user)
+	}
+	if faceScore(mine.Probably, mine.Blur, mine.Attribute) <= low {
+		return false, nil
+	}
+	err = removeFace(0, worst.Face, user, group)
+	if err != nil {
+		return false, err
+	}
+	err = nosql.UpdateThumbFace(worst.UID.Hex(), "")
+	if err != nil {
+		return false, err
+	}
+	auditUpdate(AuditSystem, AuditThumb, worst.UID.Hex(), "face", worst.Face, "")
+	logger.Infof("the face(%s) of user(%s) is replaced by thumb(%s)", worst.UID.Hex(), user, mine.UID)
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy"
+	"testing"
+)
+
+func TestCheckFaceQuality(t *testing.T) {
+	old := config.Schema.Face
+	defer func() {
+		config.Schema.Face = old
+	}()
+	strict := config.FaceConfig{Probability: 0.8, Blur: 0.5, Size: 40, Yaw: 30, Pitch: 30, Roll: 30, Occlusion: 0.5}
+	good := func() *DetectFace {
+		return &DetectFace{
+			Probability: 0.9,
+			Quality:     ImageQuality{Blur: 0.1, Occlusion: &OcclusionInfo{LeftEye: 0.1}},
+			Location:    proxy.LocationInfo{Width: 60, Height: 60},
+			Angle:       &AngleInfo{Yaw: 10, Pitch: -10, Roll: 5},
+		}
+	}
+	cases := []struct {
+		name   string
+		cof    config.FaceConfig
+		change func(face *DetectFace)
+		ok     bool
+		reason string
+	}{
+		{"good", strict, func(face *DetectFace) {}, true, ""},
+		{"probability", strict, func(face *DetectFace) { face.Probability = 0.5 }, false, "probability 0.50"},
+		{"blur", strict, func(face *DetectFace) { face.Quality.Blur = 0.7 }, false, "blur 0.70"},
+		{"size", strict, func(face *DetectFace) { face.Location.Width = 30 }, false, "size 30x60"},
+		{"yaw", strict, func(face *DetectFace) { face.Angle.Yaw = -45 }, false, "yaw -45.0"},
+		{"pitch", strict, func(face *DetectFace) { face.Angle.Pitch = 31 }, false, "pitch 31.0"},
+		{"roll", strict, func(face *DetectFace) { face.Angle.Roll = -40 }, false, "roll -40.0"},
+		{"no angle", strict, func(face *DetectFace) { face.Angle = nil }, true, ""},
+		{"occlusion", strict, func(face *DetectFace) { face.Quality.Occlusion.Chin = 0.6 }, false, "occlusion 0.60"},
+		{"no occlusion", strict, func(face *DetectFace) { face.Quality.Occlusion = nil }, true, ""},
+		{"no check", config.FaceConfig{}, func(face *DetectFace) {
+			face.Probability = 0.1
+			face.Quality.Blur = 1
+			face.Angle.Yaw = 90
+		}, true, ""},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			config.Schema.Face = item.cof
+			face := good()
+			item.change(face)
+			ok, reason := checkFaceQuality(face)
+			if ok != item.ok || reason != item.reason {
+				t.Errorf("checkFaceQuality() = %v %q, want %v %q", ok, reason, item.ok, item.reason)
+			}
+		})
+	}
+}
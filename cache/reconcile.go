@@ -0,0 +1,333 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const ReportReconcile = "reconcile"
+
+const (
+	IssueFaceOrphan       = "face_remote_orphan"  //人脸库里没有对应人脸的人脸
+	IssueFaceMissing      = "face_remote_missing" //人脸的face_token不在人脸库里
+	IssueFaceMismatch     = "face_user_mismatch"  //人脸库里的用户和人脸的用户不一致
+	IssueFaceUnregistered = "face_unregistered"   //人脸的用户在人脸库里不存在
+	IssueGroupUnknown     = "group_unavailable"   //无法读取人脸库，跳过该人脸库
+	IssueFaceUntokened    = "face_token_missing"  //人脸没有face_token，用人脸库里多出来的人脸补齐
+	IssueGroupUntokened   = "group_untokened"     //人脸库里还有没有face_token的人脸，不修复该人脸库
+)
+
+const (
+	RepairRemoveRemote = "remove_remote"
+	RepairRegister     = "register"
+	RepairMove         = "move"
+	RepairBackfill     = "backfill"
+)
+
+//人脸库里人脸的创建时间格式
+const faceStampLayout = "2006-01-02 15:04:05"
+
+//人脸库对账是否在进行中
+var reconciling int32 = 0
+
+//后台执行人脸库对账，返回报告的uid
+func (mine *cacheContext) StartReconcile(operator string, repair bool) (string, error) {
+	if !atomic.CompareAndSwapInt32(&reconciling, 0, 1) {
+		return "", errors.New("the reconcile is running")
+	}
+	report, err := createReport(ReportReconcile, operator, repair)
+	if err != nil {
+		atomic.StoreInt32(&reconciling, 0)
+		return "", err
+	}
+	go func() {
+		defer atomic.StoreInt32(&reconciling, 0)
+		mine.runReconcile(report)
+	}()
+	return report.UID.Hex(), nil
+}
+
+//同步执行人脸库对账，用于命令行
+func (mine *cacheContext) RunReconcile(operator string, repair bool) (*nosql.Report, error) {
+	if !atomic.CompareAndSwapInt32(&reconciling, 0, 1) {
+		return nil, errors.New("the reconcile is running")
+	}
+	defer atomic.StoreInt32(&reconciling, 0)
+	report, err := createReport(ReportReconcile, operator, repair)
+	if err != nil {
+		return nil, err
+	}
+	mine.runReconcile(report)
+	return report, nil
+}
+
+//按照配置的间隔（小时）定时对账
+func StartReconcile() {
+	interval := config.Schema.Reconcile.Interval
+	if interval < 1 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			_, err := cacheCtx.RunReconcile(AuditSystem, config.Schema.Reconcile.Repair)
+			if err != nil {
+				logger.Warn("the scheduled reconcile failed that msg = " + err.Error())
+			}
+		}
+	}()
+}
+
+func (mine *cacheContext) runReconcile(report *nosql.Report) {
+	stop := reportHeartbeat(report)
+	defer stop()
+	state := &fsckState{
+		operator: report.Creator,
+		repair:   report.Repair,
+		grace:    time.Now().Unix() - fsckGrace(),
+		report:   report,
+	}
+	groups, err := nosql.GetThumbGroups()
+	if err == nil {
+		remote, er := getFaceGroups()
+		if er != nil {
+			err = fmt.Errorf("list the face groups failed that %s", er.Error())
+		}
+		for _, group := range remote {
+			if !tool.HasItem(groups, group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	if err == nil {
+		for _, group := range groups {
+			report.Scanned["group"] += 1
+			er := reconcileGroup(state, group)
+			if er != nil {
+				state.addIssue(nosql.ReportIssue{Kind: IssueGroupUnknown, Target: group, Detail: er.Error(), Action: RepairNone})
+			}
+		}
+	}
+	report.Status = nosql.ReportFinished
+	if err != nil {
+		report.Status = nosql.ReportFailed
+		report.Error = err.Error()
+	}
+	er := nosql.UpdateReportResult(report)
+	if er != nil {
+		logger.Warn("save the reconcile report failed that msg = " + er.Error())
+	}
+	logger.Infof("reconcile by %s finished that report = %s, counts = %v", report.Creator, report.UID.Hex(), report.Counts)
+}
+
+//对比一个人脸库里的用户和人脸与本地的人脸
+func reconcileGroup(state *fsckState, group string) error {
+	//人的key和人脸库里的用户
+	users := make(map[string]string)
+	err := nosql.Each[nosql.Person](nosql.TablePersons, bson.M{"group": group, nosql.TimeDeleted: 0}, func(item *nosql.Person) error {
+		users[item.Key] = item.Face
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	providerUser := func(db *nosql.Thumb) string {
+		if face, ok := users[db.User]; ok && len(face) > 0 {
+			return face
+		}
+		return db.User
+	}
+	known := make(map[string]bool)
+	tokens := make(map[string]*nosql.Thumb)
+	untokened := make(map[string][]*nosql.Thumb)
+	pending := make(map[string][]*nosql.Thumb)
+	err = nosql.Each[nosql.Thumb](nosql.TableThumbs, bson.M{"group": group, nosql.TimeDeleted: 0}, func(item *nosql.Thumb) error {
+		state.report.Scanned[AuditThumb] += 1
+		if len(item.Face) > 0 {
+			known[item.Face] = true
+		} else if len(item.User) > 0 {
+			user := providerUser(item)
+			pending[user] = append(pending[user], item)
+		}
+		//还没有注册或者刚注册的人脸不检查
+		if len(item.User) < 1 || item.Created > state.grace {
+			return nil
+		}
+		if len(item.Face) > 0 {
+			tokens[item.Face] = item
+		} else {
+			user := providerUser(item)
+			untokened[user] = append(untokened[user], item)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	list, err := getUsersByGroup(group)
+	if err != nil {
+		return err
+	}
+	faces := make(map[string][]*FaceBrief, len(list))
+	for _, user := range list {
+		state.report.Scanned["user"] += 1
+		resp, code, er := getFacesByGroup(group, user)
+		if er != nil {
+			if code == ErrorCodeUserNone {
+				continue
+			}
+			return er
+		}
+		faces[user] = resp.FaceList
+		state.report.Scanned["face"] += uint32(len(resp.FaceList))
+	}
+	//没有face_token的旧人脸先用人脸库里同一个用户多出来的人脸补齐，补不齐时不修复这个人脸库
+	left := backfillTokens(state, group, faces, known, pending)
+	check := state
+	if left > 0 {
+		tmp := *state
+		tmp.repair = false
+		check = &tmp
+	}
+	if left > 0 && state.repair {
+		state.addIssue(nosql.ReportIssue{Kind: IssueGroupUntokened, Target: group, Detail: fmt.Sprintf("%d thumbs have no face token, the repair is refused", left), Action: RepairNone})
+	}
+	for _, arr := range pending {
+		for _, db := range arr {
+			if len(db.Face) > 0 && db.Created <= state.grace {
+				tokens[db.Face] = db
+			}
+		}
+	}
+	remote := make(map[string]string)
+	for user, arr := range faces {
+		for _, face := range arr {
+			remote[face.Token] = user
+			if db, ok := tokens[face.Token]; ok {
+				expect := providerUser(db)
+				if expect != user {
+					reconcileMismatch(check, group, user, expect, face.Token, db)
+				}
+				continue
+			}
+			if known[face.Token] {
+				continue
+			}
+			//用户还有没有face_token的人脸时，多出来的人脸可能属于这些人脸，不当作多余的人脸
+			if hasUntokened(pending[user]) {
+				continue
+			}
+			stamp, er := time.ParseInLocation(faceStampLayout, face.Stamp, time.Local)
+			if er == nil && stamp.Unix() > state.grace {
+				continue
+			}
+			token, from := face.Token, user
+			issue := nosql.ReportIssue{Kind: IssueFaceOrphan, Target: token, Detail: fmt.Sprintf("group=%s user=%s", group, from), Action: RepairRemoveRemote}
+			check.resolve(issue, func() error {
+				return removeFace(0, token, from, group)
+			})
+		}
+	}
+	for token, db := range tokens {
+		if _, ok := remote[token]; ok {
+			continue
+		}
+		thumb, user := db, providerUser(db)
+		issue := nosql.ReportIssue{Kind: IssueFaceMissing, Target: db.UID.Hex(), Detail: fmt.Sprintf("group=%s user=%s face=%s", group, user, token), Action: RepairRegister}
+		check.resolve(issue, func() error {
+			_, er := registerThumbFace(group, user, thumb)
+			return er
+		})
+	}
+	//用户在人脸库里不存在时重新注册，注册后保存face_token
+	for user, arr := range untokened {
+		if _, ok := faces[user]; ok {
+			continue
+		}
+		for _, db := range arr {
+			thumb, to := db, user
+			issue := nosql.ReportIssue{Kind: IssueFaceUnregistered, Target: db.UID.Hex(), Detail: fmt.Sprintf("group=%s user=%s", group, user), Action: RepairRegister}
+			state.resolve(issue, func() error {
+				_, er := registerThumbFace(group, to, thumb)
+				return er
+			})
+		}
+	}
+	return nil
+}
+
+//用户在人脸库里多出来的人脸和没有face_token的人脸数量相同时，按创建时间一一对应补齐，返回补不齐的人脸数量；
+//用户在人脸库里不存在的人脸重新注册后会有face_token，不算在内
+func backfillTokens(state *fsckState, group string, faces map[string][]*FaceBrief, known map[string]bool, pending map[string][]*nosql.Thumb) int {
+	left := 0
+	for user, arr := range pending {
+		list, ok := faces[user]
+		if !ok {
+			continue
+		}
+		unknown := make([]*FaceBrief, 0, len(list))
+		for _, face := range list {
+			if !known[face.Token] {
+				unknown = append(unknown, face)
+			}
+		}
+		if len(unknown) != len(arr) {
+			left += len(arr)
+			state.addIssue(nosql.ReportIssue{Kind: IssueFaceUntokened, Target: user, Detail: fmt.Sprintf("group=%s thumbs=%d faces=%d", group, len(arr), len(unknown)), Action: RepairNone})
+			continue
+		}
+		sort.Slice(arr, func(i, j int) bool {
+			return arr[i].Created < arr[j].Created
+		})
+		sort.Slice(unknown, func(i, j int) bool {
+			return unknown[i].Stamp < unknown[j].Stamp
+		})
+		for i, db := range arr {
+			thumb, token := db, unknown[i].Token
+			issue := nosql.ReportIssue{Kind: IssueFaceUntokened, Target: thumb.UID.Hex(), Detail: fmt.Sprintf("group=%s user=%s face=%s", group, user, token), Action: RepairBackfill}
+			state.resolve(issue, func() error {
+				err := nosql.UpdateThumbFace(thumb.UID.Hex(), token)
+				if err == nil {
+					auditUpdate(AuditSystem, AuditThumb, thumb.UID.Hex(), "face", "", token)
+					thumb.Face = token
+					known[token] = true
+				}
+				return err
+			})
+			if len(thumb.Face) < 1 {
+				left += 1
+			}
+		}
+	}
+	return left
+}
+
+func hasUntokened(list []*nosql.Thumb) bool {
+	for _, db := range list {
+		if len(db.Face) < 1 {
+			return true
+		}
+	}
+	return false
+}
+
+//人脸库里的人脸移动到本地人脸的用户
+func reconcileMismatch(state *fsckState, group, from, to, token string, db *nosql.Thumb) {
+	issue := nosql.ReportIssue{Kind: IssueFaceMismatch, Target: db.UID.Hex(), Detail: fmt.Sprintf("group=%s remote=%s local=%s", group, from, to), Action: RepairMove}
+	state.resolve(issue, func() error {
+		err := addProviderFace(group, to, token)
+		if err != nil {
+			return err
+		}
+		return removeFace(0, token, from, group)
+	})
+}
@@ -164,6 +164,7 @@ func (mine *RecycleInfo) initInfo(db *nosql.Recycle) {
 func (mine *RecycleInfo) Remove() error {
 	err := nosql.RemoveRecycle(mine.UID)
 	if err == nil {
+		writeAudit(AuditSystem, AuditRemove, AuditRecycle, mine.UID, map[string]interface{}{"name": mine.Name, "owner": mine.Owner, "uuid": mine.UUID}, nil)
 		_ = deleteContentFromCloud(mine.UUID)
 		if len(mine.Snapshot) > 2 {
 			_ = deleteContentFromCloud(mine.Snapshot)
@@ -257,5 +258,9 @@ func (mine *RecycleInfo) RemoveThumb(uid, operator string) error {
 	if !mine.hadThumb(uid) {
 		return nil
 	}
-	return nosql.RemoveThumb(uid, operator)
+	err := nosql.RemoveThumb(uid, operator)
+	if err == nil {
+		writeAudit(operator, AuditRemove, AuditThumb, uid, map[string]interface{}{"recycle": mine.UID}, nil)
+	}
+	return err
 }
@@ -19,6 +19,7 @@ const (
 	ErrorCodeDownloadFailed = 222204 //从图片的url下载图片失败
 	ErrorCodeTooLarge       = 222304 //图片尺寸过大，要小于4k
 	ErrorCodeFaceLimit      = 222210 //人脸库中用户下的人脸数目超过限制<=20
+	ErrorCodeUserNone       = 223103 //人脸库中没有该用户
 )
 
 const (
@@ -39,6 +40,7 @@ const (
 
 const (
 	FaceGroupDefault = "default_users"
+	faceUserLimit    = 20 //人脸库中一个用户最多的人脸数目
 )
 
 type FaceSearchReq struct {
@@ -178,8 +180,10 @@ func registerUserFace(info *FaceAddReq) (*ImageFaceResult, int, error) {
 	if code != 0 {
 		return nil, int(code), errors.New(result.Get("error_msg").String())
 	}
+	//face_token和location在result里
 	reply := new(ImageFaceResult)
-	er = json.Unmarshal(bts, reply)
+	er = json.Unmarshal([]byte(result.Get("result").Raw), reply)
+	reply.LogID = result.Get("log_id").Uint()
 	return reply, 0, er
 }
 
@@ -268,29 +272,35 @@ func getFaceGroups() ([]string, error) {
 	return list, er
 }
 
+//分页读取人脸库里的所有用户，每页最多1000个
 func getUsersByGroup(group string) ([]string, error) {
 	token, er := getDetectAccessToken()
 	if er != nil {
 		return nil, er
 	}
 	addr := fmt.Sprintf("%s?access_token=%s", config.Schema.Detection.User.List, token)
-	data := fmt.Sprintf(`{"group_id":"%s"}`, group)
-	bts, er := httpPost(addr, data)
-	if er != nil {
-		return nil, er
-	}
-	result := gjson.ParseBytes(bts)
-	code := result.Get("error_code").Int()
-	if code != 0 {
-		return nil, errors.New(result.Get("error_msg").String())
-	}
-	re := result.Get("result")
-	arr := re.Get("user_id_list").Array()
-	list := make([]string, 0, len(arr))
-	for _, item := range arr {
-		list = append(list, item.String())
-	}
-	return list, er
+	list := make([]string, 0, 100)
+	for start := 0; ; start += 1000 {
+		data := fmt.Sprintf(`{"group_id":"%s", "start":%d, "length":1000}`, group, start)
+		bts, er := httpPost(addr, data)
+		if er != nil {
+			return nil, er
+		}
+		result := gjson.ParseBytes(bts)
+		code := result.Get("error_code").Int()
+		if code != 0 {
+			return nil, errors.New(result.Get("error_msg").String())
+		}
+		re := result.Get("result")
+		arr := re.Get("user_id_list").Array()
+		for _, item := range arr {
+			list = append(list, item.String())
+		}
+		if len(arr) < 1000 {
+			break
+		}
+	}
+	return list, nil
 }
 
 func getUserCountByGroup(group string) int {
@@ -301,25 +311,26 @@ func getUserCountByGroup(group string) int {
 	return len(list)
 }
 
-func getFacesByGroup(group, user string) (*FaceListResp, error) {
+func getFacesByGroup(group, user string) (*FaceListResp, int, error) {
 	token, er := getDetectAccessToken()
 	if er != nil {
-		return nil, er
+		return nil, -1, er
 	}
 	addr := fmt.Sprintf("%s?access_token=%s", config.Schema.Detection.Face.List, token)
 	data := fmt.Sprintf(`{"group_id":"%s", "user_id":"%s"}`, group, user)
 	bts, er := httpPost(addr, data)
 	if er != nil {
-		return nil, er
+		return nil, -1, er
 	}
 	result := gjson.ParseBytes(bts)
 	code := result.Get("error_code").Int()
 	if code != 0 {
-		return nil, errors.New(result.Get("error_msg").String())
+		return nil, int(code), errors.New(result.Get("error_msg").String())
 	}
 	reply := new(FaceListResp)
-	er = json.Unmarshal(bts, reply)
-	return reply, er
+	er = json.Unmarshal([]byte(result.Get("result").Raw), reply)
+	reply.LogID = result.Get("log_id").Uint()
+	return reply, 0, er
 }
 
 func getFaceMetas(group, user string) (*UserListResp, error) {
@@ -349,7 +360,7 @@ func removeFace(log uint64, face, user, group string) error {
 		return er
 	}
 	addr := fmt.Sprintf("%s?access_token=%s", config.Schema.Detection.Face.Delete, token)
-	data := fmt.Sprintf(`{"log_id":"%d", "group_id":"%s", "user_id":"%s", "face_token":"%s",}`, log, group, user, face)
+	data := fmt.Sprintf(`{"log_id":%d, "group_id":"%s", "user_id":"%s", "face_token":"%s"}`, log, group, user, face)
 	bts, er := httpPost(addr, data)
 	if er != nil {
 		return er
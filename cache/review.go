@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"strings"
+	"time"
+)
+
+//待确认的人脸匹配，User为人脸暂时注册的用户
+type ReviewInfo struct {
+	UID      string `json:"uid"`
+	Created  int64  `json:"created"`
+	Updated  int64  `json:"updated"`
+	Operator string `json:"operator"`
+
+	Thumb      string                  `json:"thumb"`
+	Asset      string                  `json:"asset"`
+	Quote      string                  `json:"quote"`
+	Group      string                  `json:"group"`
+	User       string                  `json:"user"`
+	Status     uint8                   `json:"status"`
+	Decision   string                  `json:"decision"`
+	Candidates []nosql.ReviewCandidate `json:"candidates"`
+}
+
+func (mine *ReviewInfo) initInfo(db *nosql.Review) {
+	mine.UID = db.UID.Hex()
+	mine.Created = db.Created
+	mine.Updated = db.Updated
+	mine.Operator = db.Operator
+	mine.Thumb = db.Thumb
+	mine.Asset = db.Asset
+	mine.Quote = db.Quote
+	mine.Group = db.Group
+	mine.User = db.User
+	mine.Status = db.Status
+	mine.Decision = db.Decision
+	mine.Candidates = db.Candidates
+}
+
+func suggestThreshold() int {
+	val := config.Schema.Review.Suggest
+	if val < 1 || val > 100 {
+		return 80
+	}
+	return val
+}
+
+//相似度达到自动接受的阈值，或者没有配置阈值
+func acceptFaceMatch(user *UserResult) bool {
+	accept := config.Schema.Review.Accept
+	if accept < 1 {
+		return true
+	}
+	return user.Score >= float32(accept)
+}
+
+func queueReview(thumb *ThumbInfo, users []*UserResult) {
+	db := new(nosql.Review)
+	db.UID = primitive.NewObjectID()
+	db.Created = time.Now().Unix()
+	db.Updated = db.Created
+	db.Creator = thumb.Creator
+	db.Operator = thumb.Creator
+	db.Thumb = thumb.UID
+	db.Asset = thumb.Asset
+	db.Quote = thumb.Quote
+	db.Group = thumb.Group
+	db.User = thumb.User
+	db.Status = nosql.ReviewPending
+	db.Candidates = make([]nosql.ReviewCandidate, 0, len(users))
+	for _, user := range users {
+		if user.Group == thumb.Group {
+			db.Candidates = append(db.Candidates, nosql.ReviewCandidate{User: user.ID, Score: user.Score})
+		}
+	}
+	if len(db.Candidates) < 1 {
+		return
+	}
+	err := nosql.CreateReview(db)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("create the review of thumb(%s) failed that msg = %s", thumb.UID, err.Error()))
+	}
+}
+
+func (mine *cacheContext) GetReview(uid string) (*ReviewInfo, error) {
+	db, err := nosql.GetReview(uid)
+	if err != nil {
+		return nil, err
+	}
+	info := new(ReviewInfo)
+	info.initInfo(db)
+	return info, nil
+}
+
+func (mine *cacheContext) GetReviews(quote string, st uint8, page, num uint32) (uint32, []*ReviewInfo, error) {
+	if num < 1 || num > 100 {
+		num = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+	dbs, total, err := nosql.GetReviewsByStatus(quote, st, int64((page-1)*num), int64(num))
+	if err != nil {
+		return 0, nil, err
+	}
+	list := make([]*ReviewInfo, 0, len(dbs))
+	for _, db := range dbs {
+		info := new(ReviewInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	return uint32(total), list, nil
+}
+
+func (mine *ReviewInfo) GetThumb() *ThumbInfo {
+	return cacheCtx.GetThumb(mine.Thumb)
+}
+
+//确认为其中一个候选用户
+func (mine *ReviewInfo) Confirm(user, operator string) error {
+	found := false
+	for _, item := range mine.Candidates {
+		if item.User == user {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("the user is not the candidate")
+	}
+	return mine.decide(nosql.ReviewConfirmed, user, operator)
+}
+
+//改为其他用户，用户可以是人脸库里的用户或者人的key
+func (mine *ReviewInfo) Reassign(user, operator string) error {
+	if len(user) < 2 {
+		return errors.New("the user is empty")
+	}
+	return mine.decide(nosql.ReviewReassigned, user, operator)
+}
+
+//不是候选用户，保留为新的人
+func (mine *ReviewInfo) Reject(operator string) error {
+	if mine.Status != nosql.ReviewPending {
+		return errors.New("the review had decided")
+	}
+	err := ensurePerson(mine.Group, mine.User, mine.Quote, mine.Thumb, operator)
+	if err != nil {
+		return err
+	}
+	return mine.finish(nosql.ReviewRejected, mine.User, operator)
+}
+
+//人脸库里的人脸从暂时的用户移动到确认的用户
+func (mine *ReviewInfo) decide(st uint8, user, operator string) error {
+	if mine.Status != nosql.ReviewPending {
+		return errors.New("the review had decided")
+	}
+	db, err := nosql.GetThumb(mine.Thumb)
+	if err != nil || db.Deleted > 0 {
+		return errors.New("the thumb not found")
+	}
+	if db.User != mine.User {
+		return errors.New("the user of thumb had changed")
+	}
+	face, key := resolveFaceUser(mine.Group, user)
+	if face == mine.User {
+		return errors.New("the user is same as the temporary user")
+	}
+	err = moveProviderFace(mine.Group, mine.User, face, db)
+	if err != nil {
+		return err
+	}
+	thumb := new(ThumbInfo)
+	thumb.initInfo(db)
+	err = thumb.UpdateUser(key, operator)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(key, "temp_") {
+		if asset, er := nosql.GetAsset(thumb.Asset); er == nil {
+			_ = updateAssetOwner(thumb.Asset, asset.Owner, key, operator)
+		}
+	}
+	if target, er := nosql.GetPersonByKey(mine.Group, key); er == nil {
+		info := new(PersonInfo)
+		info.initInfo(target)
+		_ = info.refresh(operator)
+	}
+	return mine.finish(st, key, operator)
+}
+
+func (mine *ReviewInfo) finish(st uint8, decision, operator string) error {
+	err := nosql.UpdateReviewDecision(mine.UID, st, decision, operator)
+	if err == nil {
+		writeAudit(operator, "decide", AuditReview, mine.UID, map[string]interface{}{"status": mine.Status, "user": mine.User},
+			map[string]interface{}{"status": st, "decision": decision})
+		mine.Status = st
+		mine.Decision = decision
+		mine.Operator = operator
+	}
+	return err
+}
+
+//人脸库里的用户和人脸的user，人脸绑定实体后两者不同
+func resolveFaceUser(group, user string) (string, string) {
+	person, err := nosql.GetPersonByKey(group, user)
+	if err == nil && len(person.Face) > 0 {
+		return person.Face, person.Key
+	}
+	keys, _ := nosql.GetPersonKeysByFaces(group, []string{user})
+	if key, ok := keys[user]; ok {
+		return user, key
+	}
+	return user, user
+}
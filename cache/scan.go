@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"io"
+	"net"
+	"net/http"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ScanPending  uint8 = 0 //上传后等待扫描，处于隔离状态
+	ScanClean    uint8 = 1
+	ScanInfected uint8 = 2 //感染病毒，已隔离
+	ScanFailed   uint8 = 3 //扫描服务异常，等待重新扫描
+)
+
+//clamd INSTREAM每次发送的数据块大小
+const scanChunkSize = 64 * 1024
+
+//全量重新扫描是否在进行中
+var rescanning int32 = 0
+
+func scanEnable() bool {
+	return len(config.Schema.Scan.Address) > 0
+}
+
+//通过clamd的INSTREAM协议扫描数据流，返回病毒名称，为空表示没有发现病毒
+func clamdScan(reader io.Reader) (string, error) {
+	network := config.Schema.Scan.Network
+	if network == "" {
+		network = "tcp"
+	}
+	timeout := time.Duration(config.Schema.Scan.Timeout) * time.Second
+	if timeout < 1 {
+		timeout = 2 * time.Minute
+	}
+	conn, err := net.DialTimeout(network, config.Schema.Scan.Address, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, scanChunkSize)
+	size := make([]byte, 4)
+	for {
+		num, er := reader.Read(buf)
+		if num > 0 {
+			binary.BigEndian.PutUint32(size, uint32(num))
+			_, err = conn.Write(size)
+			if err == nil {
+				_, err = conn.Write(buf[:num])
+			}
+			if err != nil {
+				//超出StreamMaxLength时clamd会主动断开，回复里面有具体原因
+				break
+			}
+		}
+		if er == io.EOF {
+			binary.BigEndian.PutUint32(size, 0)
+			_, err = conn.Write(size)
+			break
+		}
+		if er != nil {
+			return "", er
+		}
+	}
+
+	reply, er := io.ReadAll(conn)
+	if er != nil && len(reply) < 1 {
+		return "", er
+	}
+	return parseClamdReply(reply)
+}
+
+//stream: OK | stream: Eicar-Signature FOUND | INSTREAM size limit exceeded. ERROR
+func parseClamdReply(reply []byte) (string, error) {
+	msg := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+	if strings.HasSuffix(msg, "FOUND") {
+		msg = strings.TrimSuffix(msg, "FOUND")
+		if i := strings.Index(msg, ":"); i > -1 {
+			msg = msg[i+1:]
+		}
+		return strings.TrimSpace(msg), nil
+	}
+	if strings.HasSuffix(msg, "OK") {
+		return "", nil
+	}
+	if msg == "" {
+		msg = "the clamd reply is empty"
+	}
+	return "", errors.New(msg)
+}
+
+//下载原始文件交给clamd扫描，记录结果，感染和扫描失败的资源会被隔离
+func scanAsset(info *AssetInfo) uint8 {
+	if !scanEnable() {
+		return ScanPending
+	}
+	virus, err := scanAssetFile(info.UUID)
+	scan := ScanClean
+	if err != nil {
+		scan = ScanFailed
+		logger.Warn("scan asset failed that uid = " + info.UID + " and msg = " + err.Error())
+	} else if len(virus) > 0 {
+		scan = ScanInfected
+		logger.Warn(fmt.Sprintf("the asset(%s) is infected by %s and quarantined", info.UID, virus))
+	}
+	//只有扫描通过才解除隔离，扫描失败的等待重新扫描
+	quarantine := scan != ScanClean
+	err = commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateAssetScan(ctx, info.UID, virus, scan, quarantine)
+		if er != nil {
+			return er
+		}
+		return recordAudit(ctx, AuditSystem, "scan", AuditAsset, info.UID, map[string]interface{}{"scan": info.Scan, "quarantine": info.Quarantine},
+			map[string]interface{}{"scan": scan, "quarantine": quarantine, "virus": virus})
+	})
+	if err != nil {
+		logger.Warn("update asset scan failed that uid = " + info.UID + " and msg = " + err.Error())
+	}
+	info.Scan = scan
+	info.Virus = virus
+	info.Quarantine = quarantine
+	return scan
+}
+
+func scanAssetFile(key string) (string, error) {
+	url := GetURL(key, false)
+	if len(url) < 1 {
+		return "", errors.New("the asset file is empty")
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download the file failed that code = %d", resp.StatusCode)
+	}
+	return clamdScan(resp.Body)
+}
+
+//重新扫描所有的资源，扫描通过的资源会解除隔离，包括上传后还没有扫描完成的
+func (mine *cacheContext) RescanAssets(operator string) error {
+	if !scanEnable() {
+		return errors.New("the scan service not config")
+	}
+	if !atomic.CompareAndSwapInt32(&rescanning, 0, 1) {
+		return errors.New("the rescan is running")
+	}
+	array, err := nosql.GetAllAssets()
+	if err != nil {
+		atomic.StoreInt32(&rescanning, 0)
+		return err
+	}
+	go func() {
+		defer atomic.StoreInt32(&rescanning, 0)
+		var infected, failed int
+		for _, db := range array {
+			info := new(AssetInfo)
+			info.initInfo(db)
+			switch scanAsset(info) {
+			case ScanInfected:
+				infected += 1
+			case ScanFailed:
+				failed += 1
+			}
+		}
+		logger.Infof("rescan assets by %s finished that total = %d, infected = %d, failed = %d", operator, len(array), infected, failed)
+	}()
+	return nil
+}
+
+func (mine *cacheContext) GetQuarantineAssets() []*AssetInfo {
+	array, err := nosql.GetAssetsByQuarantine()
+	if err != nil {
+		return make([]*AssetInfo, 0, 1)
+	}
+	list := make([]*AssetInfo, 0, len(array))
+	for _, asset := range array {
+		info := new(AssetInfo)
+		info.initInfo(asset)
+		list = append(list, info)
+	}
+	return list
+}
+
+func (mine *AssetInfo) Rescan() error {
+	if !scanEnable() {
+		return errors.New("the scan service not config")
+	}
+	if scanAsset(mine) == ScanFailed {
+		return errors.New("scan the asset failed")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"errors"
+	"omo.msa.asset/proxy/nosql"
+	"sort"
+	"strings"
+)
+
+//人脸库一次最多搜索的分组数量
+const searchGroupMax = 10
+
+//以图搜人，Image为base64的图片，为空时使用Asset的图片
+type FaceQuery struct {
+	Image     string   `json:"image"`
+	Asset     string   `json:"asset"`
+	Groups    []string `json:"groups"`
+	Threshold int      `json:"threshold"`
+	Max       int      `json:"max"`
+	Quote     string   `json:"quote"`
+	Owner     string   `json:"owner"`
+	From      int64    `json:"from"`
+	To        int64    `json:"to"`
+}
+
+type FaceMatch struct {
+	Thumb *ThumbInfo
+	Score float32
+}
+
+//需要搜索的人脸库，没有指定时为默认库和Owner的机构库
+func (mine *FaceQuery) SearchGroups() []string {
+	if len(mine.Groups) > 0 {
+		return mine.Groups
+	}
+	groups := []string{FaceGroupDefault}
+	if len(mine.Owner) > 0 {
+		groups = append(groups, mine.Owner)
+	}
+	return groups
+}
+
+//搜索与图片里的人脸相似的用户，返回这些用户有读权限的人脸，同一个资源的人脸排在一起，相似度高的资源在前面
+func (mine *cacheContext) SearchFaces(query *FaceQuery, who *Principal) ([]*FaceMatch, error) {
+	if query.Threshold < 1 || query.Threshold > 100 {
+		query.Threshold = 80
+	}
+	if query.Max < 1 || query.Max > 50 {
+		query.Max = 10
+	}
+	groups := query.SearchGroups()
+	if len(groups) > searchGroupMax {
+		return nil, errors.New("the face groups is too many")
+	}
+	image := query.Image
+	if len(image) < 1 {
+		asset := mine.GetAsset(query.Asset)
+		if asset == nil {
+			return nil, errors.New("the probe image is empty")
+		}
+		_, url := asset.getMinURL()
+		_, bs64, err := downloadAssetToB64(url)
+		if err != nil {
+			return nil, err
+		}
+		image = bs64
+	}
+	req := new(FaceSearchReq)
+	req.Type = ImageTypeBase64
+	req.Image = image
+	req.Groups = strings.Join(groups, ",")
+	req.Quality = QualityLow
+	req.MaxUser = query.Max
+	req.Threshold = query.Threshold
+	result, err, code := searchFaceByOne(req)
+	if err != nil {
+		if code == ErrorCodeNotMatch {
+			return make([]*FaceMatch, 0, 1), nil
+		}
+		return nil, err
+	}
+	list := make([]*FaceMatch, 0, 10)
+	best := make(map[string]float32)
+	for _, user := range result.Users {
+		users := []string{user.ID}
+		keys, _ := nosql.GetPersonKeysByFaces(user.Group, users)
+		if key, ok := keys[user.ID]; ok && key != user.ID {
+			users = append(users, key)
+		}
+		dbs, er := nosql.GetThumbsByUsers(user.Group, users, query.Quote, query.Owner, query.From, query.To)
+		if er != nil {
+			return nil, er
+		}
+		for _, db := range dbs {
+			info := new(ThumbInfo)
+			info.initInfo(db)
+			if !who.CanThumb(info, ActionRead) {
+				continue
+			}
+			list = append(list, &FaceMatch{Thumb: info, Score: user.Score})
+			if user.Score > best[info.Asset] {
+				best[info.Asset] = user.Score
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Thumb.Asset != b.Thumb.Asset {
+			if best[a.Thumb.Asset] != best[b.Thumb.Asset] {
+				return best[a.Thumb.Asset] > best[b.Thumb.Asset]
+			}
+			return a.Thumb.Asset < b.Thumb.Asset
+		}
+		return a.Score > b.Score
+	})
+	return list, nil
+}
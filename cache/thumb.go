@@ -1,9 +1,11 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"omo.msa.asset/proxy"
 	"omo.msa.asset/proxy/nosql"
@@ -23,6 +25,7 @@ type ThumbInfo struct {
 	UID      string `json:"uid"`
 	Creator  string
 	Operator string
+	Revision uint64
 
 	Face  string
 	File  string
@@ -30,12 +33,13 @@ type ThumbInfo struct {
 	Asset string
 	Meta  string
 
-	User     string
-	Quote    string
-	Group    string
-	bs64     string
-	data     []byte
-	Location proxy.LocationInfo
+	User      string
+	Quote     string
+	Group     string
+	bs64      string
+	data      []byte
+	Location  proxy.LocationInfo
+	Attribute proxy.FaceAttribute
 }
 
 func CreateThumb(asset, owner, bs64, quote, group, operator string, bts []byte, info *DetectFace) *ThumbInfo {
@@ -57,6 +61,7 @@ func CreateThumb(asset, owner, bs64, quote, group, operator string, bts []byte,
 	temp.Group = group
 	temp.Meta = ""
 	temp.Location = info.Location
+	temp.Attribute = info.attribute()
 	temp.bs64 = bs64
 	temp.Status = uint32(Detected_Pend)
 	return temp
@@ -109,19 +114,32 @@ func (mine *cacheContext) BindFaceEntity(user, entity, operator string) error {
 	if len(entity) < 2 {
 		return errors.New("the entity is empty")
 	}
-	dbs, err := nosql.GetThumbsByUser(user)
+	_, owners, err := nosql.BindThumbsEntity(user, entity, operator, func(ctx context.Context, dbs []*nosql.Thumb, owners map[string]string) error {
+		for _, db := range dbs {
+			er := recordUpdate(ctx, operator, AuditThumb, db.UID.Hex(), "user", db.User, entity)
+			if er != nil {
+				return er
+			}
+			er = stageEvent(ctx, EventEntityBound, db.UID.Hex(), newThumbEvent(ctx, db.UID.Hex(), db.Asset, db.User, entity))
+			if er != nil {
+				return er
+			}
+		}
+		for asset, owner := range owners {
+			er := recordUpdate(ctx, operator, AuditAsset, asset, "owner", owner, entity)
+			if er != nil {
+				return er
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	for _, db := range dbs {
-		er := nosql.UpdateThumbUser(db.UID.Hex(), entity, operator)
-		if er != nil {
-			return er
-		}
-		_ = nosql.UpdateAssetOwner(db.Asset, entity, operator)
+	for asset, owner := range owners {
+		ownerChanged(asset, owner, entity, operator)
 	}
-
-	return nil
+	return bindPersonsEntity(user, entity, operator)
 }
 
 func (mine *ThumbInfo) initInfo(db *nosql.Thumb) {
@@ -136,18 +154,23 @@ func (mine *ThumbInfo) initInfo(db *nosql.Thumb) {
 	mine.Blur = db.Blur
 	mine.Creator = db.Creator
 	mine.Operator = db.Operator
+	mine.Revision = db.Revision
 	mine.Meta = db.Meta
 	mine.User = db.User
 	mine.Quote = db.Quote
 	mine.File = db.File
+	mine.Face = db.Face
 	mine.Group = db.Group
 	mine.Status = db.Status
 	mine.Location = db.Location
+	mine.Attribute = db.Attribute
 	if len(mine.Group) < 1 {
 		asset := cacheCtx.GetAsset(db.Asset)
 		if asset != nil {
 			mine.Group = asset.CheckFaceGroup()
-			_ = nosql.UpdateThumbGroup(mine.UID, mine.Group)
+			if nosql.UpdateThumbGroup(mine.UID, mine.Group) == nil {
+				auditUpdate(AuditSystem, AuditThumb, mine.UID, "group", "", mine.Group)
+			}
 		}
 	}
 }
@@ -163,6 +186,7 @@ func (mine *ThumbInfo) save() error {
 	db.User = mine.User
 	db.Quote = mine.Quote
 	db.File = file
+	db.Face = mine.Face
 	db.Asset = mine.Asset
 	db.Blur = mine.Blur
 	db.Owner = mine.Owner
@@ -170,22 +194,40 @@ func (mine *ThumbInfo) save() error {
 	db.Similar = 0
 	db.Group = mine.Group
 	db.Location = mine.Location
+	db.Attribute = mine.Attribute
 	db.Meta = mine.Meta
 	db.Status = mine.Status
-	er := nosql.CreateThumb(db)
+	matched := !strings.Contains(mine.User, "temp_")
+	er := commitWrite(func(ctx context.Context) error {
+		err := nosql.CreateThumb(ctx, db)
+		if err != nil {
+			return err
+		}
+		err = recordAudit(ctx, mine.Operator, AuditCreate, AuditThumb, mine.UID, nil, map[string]interface{}{"asset": db.Asset, "user": db.User, "file": file})
+		if err != nil || !matched {
+			return err
+		}
+		return stageEvent(ctx, EventThumbMatched, mine.UID, newThumbEvent(ctx, mine.UID, mine.Asset, mine.User, ""))
+	})
 	if er == nil {
 		go uploadToQiNiu(file, mine.data)
-		if !strings.Contains(mine.User, "temp_") {
-			_ = nosql.UpdateAssetOwner(mine.Asset, mine.User, mine.Operator)
+		if err := ensurePerson(db.Group, db.User, db.Quote, mine.UID, mine.Operator); err != nil {
+			logger.Warn("create the person of thumb = " + mine.UID + " failed that msg = " + err.Error())
+		}
+		if matched {
+			if asset, err := nosql.GetAsset(mine.Asset); err == nil {
+				_ = updateAssetOwner(mine.Asset, asset.Owner, mine.User, mine.Operator)
+			}
 		}
 	}
 	logger.Warn("try save thumb of asset = " + db.Asset + " and thumb = " + mine.UID + "; user = " + db.User)
 	return er
 }
 
-func (mine *ThumbInfo) UpdateBase(owner string, similar float32) error {
+func (mine *ThumbInfo) UpdateBase(owner, operator string, similar float32) error {
 	err := nosql.UpdateThumbBase(mine.UID, owner, similar)
 	if err == nil {
+		writeAudit(operator, "update_base", AuditThumb, mine.UID, map[string]interface{}{"owner": mine.Owner, "similar": mine.Similar}, map[string]interface{}{"owner": owner, "similar": similar})
 		mine.Owner = owner
 		mine.Similar = similar
 	}
@@ -200,7 +242,7 @@ func (mine *ThumbInfo) SearchUsers() ([]*UserResult, error) {
 	req.Groups = mine.Group
 	req.Quality = QualityLow
 	req.MaxUser = 10
-	req.Threshold = 80
+	req.Threshold = suggestThreshold()
 	result, err, code := searchFaceByOne(req)
 	if err != nil {
 		if code == ErrorCodeQPSLimit {
@@ -245,6 +287,9 @@ func (mine *ThumbInfo) RegisterFace(user, group string) error {
 	if len(group) < 1 {
 		return errors.New("the group is empty")
 	}
+	if !mine.consented() {
+		return ErrNoConsent
+	}
 	mine.Status = uint32(BD_Detection)
 	id := user
 	if len(id) < 2 {
@@ -259,10 +304,28 @@ func (mine *ThumbInfo) RegisterFace(user, group string) error {
 	req.Action = "APPEND"
 
 	req.Meta = fmt.Sprintf(`"user":"%s", "thumb":"%s"`, id, mine.UID)
-	_, code, err := registerUserFace(req)
+	result, code, err := registerUserFace(req)
+	if code == ErrorCodeFaceLimit {
+		//人脸数量达到上限时替换质量最低的人脸，当前人脸更差时不注册
+		ok, er := mine.replaceWorstFace(id, group)
+		if er != nil {
+			return er
+		}
+		if !ok {
+			logger.Infof("the thumb(%s) is worse than the faces of user(%s), not register", mine.UID, id)
+			if len(mine.User) < 2 {
+				mine.User = id
+			}
+			return nil
+		}
+		result, code, err = registerUserFace(req)
+	}
 	if err != nil && code != ErrorCodeFaceExist {
 		return err
 	}
+	if result != nil {
+		mine.Face = result.Token
+	}
 
 	if len(mine.User) < 2 {
 		mine.User = id
@@ -273,6 +336,7 @@ func (mine *ThumbInfo) RegisterFace(user, group string) error {
 func (mine *ThumbInfo) UpdateInfo(meta, operator string) error {
 	err := nosql.UpdateThumbMeta(mine.UID, meta, operator)
 	if err == nil {
+		auditUpdate(operator, AuditThumb, mine.UID, "meta", mine.Meta, meta)
 		mine.bs64 = meta
 		mine.Operator = operator
 	}
@@ -285,19 +349,128 @@ func (mine *ThumbInfo) BindEntity(entity, operator string) error {
 		return err
 	}
 	for _, db := range dbs {
-		er := nosql.UpdateThumbUser(db.UID.Hex(), entity, operator)
+		er := commitWrite(func(ctx context.Context) error {
+			err := nosql.UpdateThumbUser(ctx, db.UID.Hex(), entity, operator)
+			if err != nil {
+				return err
+			}
+			err = recordUpdate(ctx, operator, AuditThumb, db.UID.Hex(), "user", db.User, entity)
+			if err != nil {
+				return err
+			}
+			return stageEvent(ctx, EventEntityBound, db.UID.Hex(), newThumbEvent(ctx, db.UID.Hex(), db.Asset, db.User, entity))
+		})
 		if er != nil {
 			return er
 		}
 	}
-	return err
+	return bindPersonsEntity(mine.User, entity, operator)
 }
 
 func (mine *ThumbInfo) UpdateUser(user, operator string) error {
-	err := nosql.UpdateThumbUser(mine.UID, user, operator)
+	err := commitWrite(func(ctx context.Context) error {
+		er := nosql.UpdateThumbUser(ctx, mine.UID, user, operator)
+		if er != nil {
+			return er
+		}
+		er = recordUpdate(ctx, operator, AuditThumb, mine.UID, "user", mine.User, user)
+		if er != nil || strings.Contains(user, "temp_") {
+			return er
+		}
+		return stageEvent(ctx, EventThumbMatched, mine.UID, newThumbEvent(ctx, mine.UID, mine.Asset, user, ""))
+	})
 	if err == nil {
+		old := mine.User
 		mine.User = user
 		mine.Operator = operator
+		if er := ensurePerson(mine.Group, user, mine.Quote, mine.UID, operator); er != nil {
+			logger.Warn("create the person of thumb = " + mine.UID + " failed that msg = " + er.Error())
+		}
+		refreshPersons(map[[2]string]bool{{mine.Group, old}: true}, operator)
 	}
 	return err
 }
+
+//人脸属性的查询条件，Quote、Asset、Owner和User至少一个；Angle为偏航角和俯仰角的最大绝对值，用于查询正脸
+type FaceFilter struct {
+	Quote   string   `json:"quote"`
+	Asset   string   `json:"asset"`
+	Owner   string   `json:"owner"`
+	User    string   `json:"user"`
+	Gender  string   `json:"gender"`
+	Glasses string   `json:"glasses"`
+	Emotion string   `json:"emotion"`
+	Kind    string   `json:"kind"`
+	Mask    *bool    `json:"mask"`
+	MinAge  uint32   `json:"min_age"`
+	MaxAge  uint32   `json:"max_age"`
+	Angle   *float32 `json:"angle"`
+	Blur    *float32 `json:"blur"`
+	Number  int64    `json:"number"`
+}
+
+func (mine *cacheContext) GetThumbsByAttribute(filter *FaceFilter) ([]*ThumbInfo, error) {
+	match := bson.M{}
+	if len(filter.Quote) > 0 {
+		match["quote"] = filter.Quote
+	}
+	if len(filter.Asset) > 0 {
+		match["asset"] = filter.Asset
+	}
+	if len(filter.Owner) > 0 {
+		match["owner"] = filter.Owner
+	}
+	if len(filter.User) > 0 {
+		match["user"] = filter.User
+	}
+	if len(match) < 1 {
+		return nil, errors.New("the face filter is empty")
+	}
+	if len(filter.Gender) > 0 {
+		match["attribute.gender"] = filter.Gender
+	}
+	if len(filter.Glasses) > 0 {
+		match["attribute.glasses"] = filter.Glasses
+	}
+	if len(filter.Emotion) > 0 {
+		match["attribute.emotion"] = filter.Emotion
+	}
+	if len(filter.Kind) > 0 {
+		match["attribute.kind"] = filter.Kind
+	}
+	if filter.Mask != nil {
+		match["attribute.mask"] = *filter.Mask
+	}
+	if filter.MinAge > 0 || filter.MaxAge > 0 {
+		age := bson.M{}
+		if filter.MinAge > 0 {
+			age["$gte"] = filter.MinAge
+		}
+		if filter.MaxAge > 0 {
+			age["$lte"] = filter.MaxAge
+		}
+		match["attribute.age"] = age
+	}
+	if filter.Angle != nil {
+		angle := *filter.Angle
+		match["attribute.yaw"] = bson.M{"$gte": -angle, "$lte": angle}
+		match["attribute.pitch"] = bson.M{"$gte": -angle, "$lte": angle}
+	}
+	if filter.Blur != nil {
+		match["blur"] = bson.M{"$lte": *filter.Blur}
+	}
+	if filter.Number < 1 || filter.Number > 500 {
+		filter.Number = 100
+	}
+	dbs, err := nosql.GetThumbsByAttribute(match, filter.Number)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*ThumbInfo, 0, len(dbs))
+	for _, db := range dbs {
+		info := new(ThumbInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	return list, nil
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"omo.msa.asset/proxy/nosql"
+	"time"
+)
+
+const (
+	WatchCreate = "create"
+	WatchUpdate = "update"
+	WatchRemove = "remove"
+)
+
+//订阅的范围，至少需要一个条件
+type WatchFilter struct {
+	Owner  string
+	Quote  string
+	Folder string
+	Token  string
+}
+
+type WatchChange struct {
+	Token  string      `json:"token"`
+	Action string      `json:"action"`
+	Kind   string      `json:"kind"`
+	UID    string      `json:"uid"`
+	Time   int64       `json:"time"`
+	Asset  *WatchAsset `json:"asset,omitempty"`
+	Thumb  *WatchThumb `json:"thumb,omitempty"`
+}
+
+type WatchAsset struct {
+	UID      string   `json:"uid"`
+	Name     string   `json:"name"`
+	Type     uint8    `json:"type"`
+	Status   uint8    `json:"status"`
+	Owner    string   `json:"owner"`
+	Quote    string   `json:"quote"`
+	Creator  string   `json:"creator"`
+	Created  int64    `json:"created"`
+	Updated  int64    `json:"updated"`
+	Width    uint32   `json:"width"`
+	Height   uint32   `json:"height"`
+	URL      string   `json:"url"`
+	Snapshot string   `json:"snapshot"`
+	Small    string   `json:"small"`
+	Tags     []string `json:"tags"`
+}
+
+type WatchThumb struct {
+	UID      string  `json:"uid"`
+	Asset    string  `json:"asset"`
+	Owner    string  `json:"owner"`
+	User     string  `json:"user"`
+	Quote    string  `json:"quote"`
+	Similar  float32 `json:"similar"`
+	Probably float32 `json:"probably"`
+	URL      string  `json:"url"`
+}
+
+//订阅资源和人脸的实时变更，直到ctx结束或者fn返回错误；只推送调用者可以读取的内容
+func (mine *cacheContext) WatchChanges(ctx context.Context, who *Principal, filter *WatchFilter, fn func(change *WatchChange) error) error {
+	if len(filter.Owner) < 1 && len(filter.Quote) < 1 && len(filter.Folder) < 1 {
+		return errors.New("the watch filter is empty")
+	}
+	match := bson.M{}
+	if len(filter.Owner) > 0 {
+		match["owner"] = filter.Owner
+	}
+	if len(filter.Quote) > 0 {
+		match["quote"] = filter.Quote
+	}
+	if len(filter.Folder) > 0 {
+		folder, err := mine.GetFolder(filter.Folder)
+		if err != nil || folder == nil {
+			return errors.New("the folder not found")
+		}
+		if !who.CanFolder(folder, ActionRead) {
+			return errors.New("the operator has no permission")
+		}
+	}
+	return nosql.WatchChanges(ctx, match, filter.Token, func(change *nosql.Change) error {
+		item := mine.switchChange(who, filter.Folder, change)
+		if item == nil {
+			return nil
+		}
+		return fn(item)
+	})
+}
+
+func (mine *cacheContext) switchChange(who *Principal, folder string, change *nosql.Change) *WatchChange {
+	item := &WatchChange{Token: change.Token, UID: change.Key, Time: time.Now().Unix()}
+	deleted := false
+	if change.Asset != nil {
+		info := new(AssetInfo)
+		info.initInfo(change.Asset)
+		if info.Quarantine || !who.CanAsset(info, ActionRead) || !mine.inFolder(folder, info.UID) {
+			return nil
+		}
+		deleted = change.Asset.Deleted > 0
+		item.Kind = AuditAsset
+		item.Asset = &WatchAsset{
+			UID:      info.UID,
+			Name:     info.Name,
+			Type:     info.Type,
+			Status:   info.Status,
+			Owner:    info.Owner,
+			Quote:    info.Quote,
+			Creator:  info.Creator,
+			Created:  info.Created,
+			Updated:  info.Updated,
+			Width:    info.Width,
+			Height:   info.Height,
+			URL:      info.URL(),
+			Snapshot: info.SnapshotURL(),
+			Small:    info.SmallImageURL(),
+			Tags:     info.Tags,
+		}
+	} else if change.Thumb != nil {
+		info := new(ThumbInfo)
+		info.initInfo(change.Thumb)
+		if !who.CanThumb(info, ActionRead) || !mine.inFolder(folder, info.Asset) {
+			return nil
+		}
+		deleted = change.Thumb.Deleted > 0
+		item.Kind = AuditThumb
+		item.Thumb = &WatchThumb{
+			UID:      info.UID,
+			Asset:    info.Asset,
+			Owner:    info.Owner,
+			User:     info.User,
+			Quote:    info.Quote,
+			Similar:  info.Similar,
+			Probably: info.Probably,
+			URL:      GetURL(info.File, true),
+		}
+	} else {
+		//物理删除的文档没有内容，无法判断范围和权限
+		return nil
+	}
+	switch change.Operation {
+	case "insert":
+		item.Action = WatchCreate
+	case "delete":
+		item.Action = WatchRemove
+	default:
+		item.Action = WatchUpdate
+		if deleted {
+			item.Action = WatchRemove
+		}
+	}
+	return item
+}
+
+//文件夹的内容会变化，每次都重新读取
+func (mine *cacheContext) inFolder(folder, asset string) bool {
+	if len(folder) < 1 {
+		return true
+	}
+	info, err := mine.GetFolder(folder)
+	if err != nil || info == nil {
+		return false
+	}
+	return info.HadContent(asset)
+}
@@ -0,0 +1,498 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"omo.msa.asset/tool"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//webhook请求头，签名为 "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))
+const (
+	WebhookHeaderEvent     = "X-Omo-Event"
+	WebhookHeaderDelivery  = "X-Omo-Delivery"
+	WebhookHeaderTimestamp = "X-Omo-Timestamp"
+	WebhookHeaderSignature = "X-Omo-Signature"
+)
+
+const EventWebhookPing = "webhook.ping"
+
+//投递日志里保存的响应内容的最大长度
+const webhookResponseMax = 1024
+
+type WebhookInfo struct {
+	UID     string   `json:"uid"`
+	Name    string   `json:"name"`
+	Created int64    `json:"created"`
+	Creator string   `json:"creator"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Owner   string   `json:"owner"`
+	Quote   string   `json:"quote"`
+	Types   []uint32 `json:"types"`
+	secret  string
+}
+
+type DeliveryInfo struct {
+	UID      string              `json:"uid"`
+	Created  int64               `json:"created"`
+	Updated  int64               `json:"updated"`
+	Webhook  string              `json:"webhook"`
+	Event    string              `json:"event"`
+	Type     string              `json:"type"`
+	Status   uint8               `json:"status"`
+	Attempts uint32              `json:"attempts"`
+	Next     int64               `json:"next"`
+	Logs     []nosql.DeliveryLog `json:"logs"`
+}
+
+var (
+	webhookLock   sync.RWMutex
+	webhookLoaded bool
+	webhookList   []*WebhookInfo
+)
+
+func (mine *WebhookInfo) initInfo(db *nosql.Webhook) {
+	mine.UID = db.UID.Hex()
+	mine.Name = db.Name
+	mine.Created = db.Created
+	mine.Creator = db.Creator
+	mine.URL = db.URL
+	mine.Events = db.Events
+	mine.Owner = db.Owner
+	mine.Quote = db.Quote
+	mine.Types = db.Types
+	mine.secret = db.Secret
+}
+
+func (mine *DeliveryInfo) initInfo(db *nosql.Delivery) {
+	mine.UID = db.UID.Hex()
+	mine.Created = db.Created
+	mine.Updated = db.Updated
+	mine.Webhook = db.Webhook
+	mine.Event = db.Event
+	mine.Type = db.Type
+	mine.Status = db.Status
+	mine.Attempts = db.Attempts
+	mine.Next = db.Next
+	mine.Logs = db.Logs
+	if mine.Logs == nil {
+		mine.Logs = make([]nosql.DeliveryLog, 0, 1)
+	}
+}
+
+func (mine *cacheContext) CreateWebhook(name, address, secret, owner, quote, operator string, events []string, types []uint32) (*WebhookInfo, error) {
+	uri, err := url.Parse(address)
+	if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || len(uri.Hostname()) < 1 {
+		return nil, errors.New("the webhook url is invalid")
+	}
+	err = checkWebhookHost(uri.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) < 1 {
+		return nil, errors.New("the webhook secret is empty")
+	}
+	db := new(nosql.Webhook)
+	db.UID = primitive.NewObjectID()
+	db.Created = time.Now().Unix()
+	db.Creator = operator
+	db.Name = name
+	db.URL = address
+	db.Secret = secret
+	db.Owner = owner
+	db.Quote = quote
+	db.Events = events
+	db.Types = types
+	if db.Events == nil {
+		db.Events = make([]string, 0, 1)
+	}
+	if db.Types == nil {
+		db.Types = make([]uint32, 0, 1)
+	}
+	err = nosql.CreateWebhook(db)
+	if err != nil {
+		return nil, err
+	}
+	info := new(WebhookInfo)
+	info.initInfo(db)
+	writeAudit(operator, AuditCreate, AuditWebhook, info.UID, nil, info.auditData())
+	reloadWebhooks()
+	return info, nil
+}
+
+func (mine *cacheContext) RemoveWebhook(uid, operator string) error {
+	info := mine.GetWebhook(uid)
+	if info == nil {
+		return errors.New("the webhook not found")
+	}
+	err := nosql.RemoveWebhook(uid, operator)
+	if err == nil {
+		writeAudit(operator, AuditRemove, AuditWebhook, uid, info.auditData(), nil)
+		reloadWebhooks()
+	}
+	return err
+}
+
+func (mine *cacheContext) GetWebhook(uid string) *WebhookInfo {
+	db, err := nosql.GetWebhook(uid)
+	if err != nil || db.Deleted > 0 {
+		return nil
+	}
+	info := new(WebhookInfo)
+	info.initInfo(db)
+	return info
+}
+
+func (mine *cacheContext) GetWebhooks(owner string) []*WebhookInfo {
+	list := make([]*WebhookInfo, 0, 5)
+	for _, item := range getWebhooks() {
+		if len(owner) < 1 || item.Owner == owner {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (mine *cacheContext) GetDeliveries(webhook string, page, num uint32) (uint32, uint32, []*DeliveryInfo) {
+	start, number := getPageStart(page, num)
+	total := nosql.GetDeliveriesCount(webhook)
+	pages := math.Ceil(float64(total) / float64(number))
+	dbs, err := nosql.GetDeliveries(webhook, int64(start), int64(number))
+	if err != nil {
+		return 0, 0, make([]*DeliveryInfo, 0, 1)
+	}
+	list := make([]*DeliveryInfo, 0, len(dbs))
+	for _, db := range dbs {
+		info := new(DeliveryInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	return uint32(total), uint32(pages), list
+}
+
+//立即投递一次测试事件，不做重试
+func (mine *WebhookInfo) Ping(operator string) (*DeliveryInfo, error) {
+	env := &EventEnvelope{
+		ID:      primitive.NewObjectID().Hex(),
+		Type:    EventWebhookPing,
+		Version: EventVersion,
+		Source:  EventSource,
+		Subject: mine.UID,
+		Time:    time.Now().Unix(),
+		Data:    map[string]string{"webhook": mine.UID, "operator": operator},
+	}
+	bts, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	db := newDelivery(mine, env, bts)
+	err = nosql.CreateDelivery(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+	log := postWebhook(mine, db)
+	db.Attempts = 1
+	db.Status = nosql.DeliverySuccess
+	if len(log.Error) > 0 {
+		db.Status = nosql.DeliveryDead
+	}
+	_ = nosql.UpdateDeliveryAttempt(db.UID.Hex(), db.Status, db.Attempts, 0, log)
+	db.Logs = append(db.Logs, log)
+	info := new(DeliveryInfo)
+	info.initInfo(db)
+	if len(log.Error) > 0 {
+		return info, errors.New(log.Error)
+	}
+	return info, nil
+}
+
+func (mine *WebhookInfo) auditData() map[string]interface{} {
+	return map[string]interface{}{
+		"name":   mine.Name,
+		"url":    mine.URL,
+		"events": mine.Events,
+		"owner":  mine.Owner,
+		"quote":  mine.Quote,
+		"types":  mine.Types,
+	}
+}
+
+//事件类型和对象范围都符合订阅条件
+func (mine *WebhookInfo) match(tp string, data interface{}) bool {
+	if len(mine.Events) > 0 && !tool.HasItem(mine.Events, tp) {
+		return false
+	}
+	if len(mine.Owner) < 1 && len(mine.Quote) < 1 && len(mine.Types) < 1 {
+		return true
+	}
+	scoper, ok := data.(eventScoper)
+	if !ok {
+		return false
+	}
+	owner, quote, kind := scoper.scope()
+	if len(mine.Owner) > 0 && mine.Owner != owner {
+		return false
+	}
+	if len(mine.Quote) > 0 && mine.Quote != quote {
+		return false
+	}
+	if len(mine.Types) > 0 {
+		if kind < 0 {
+			return false
+		}
+		for _, item := range mine.Types {
+			if int(item) == kind {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func reloadWebhooks() {
+	dbs, err := nosql.GetAllWebhooks()
+	if err != nil {
+		logger.Warn("load webhooks failed that msg = " + err.Error())
+		return
+	}
+	list := make([]*WebhookInfo, 0, len(dbs))
+	for _, db := range dbs {
+		info := new(WebhookInfo)
+		info.initInfo(db)
+		list = append(list, info)
+	}
+	webhookLock.Lock()
+	webhookList = list
+	webhookLoaded = true
+	webhookLock.Unlock()
+}
+
+func getWebhooks() []*WebhookInfo {
+	webhookLock.RLock()
+	loaded := webhookLoaded
+	webhookLock.RUnlock()
+	if !loaded {
+		reloadWebhooks()
+	}
+	webhookLock.RLock()
+	defer webhookLock.RUnlock()
+	return webhookList
+}
+
+func findWebhook(uid string) *WebhookInfo {
+	for _, item := range getWebhooks() {
+		if item.UID == uid {
+			return item
+		}
+	}
+	return nil
+}
+
+func matchWebhooks(tp string, data interface{}) []*WebhookInfo {
+	list := make([]*WebhookInfo, 0, 2)
+	for _, item := range getWebhooks() {
+		if item.match(tp, data) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func newDelivery(hook *WebhookInfo, env *EventEnvelope, body []byte) *nosql.Delivery {
+	db := new(nosql.Delivery)
+	db.UID = primitive.NewObjectID()
+	db.Created = time.Now().Unix()
+	db.Webhook = hook.UID
+	db.Event = env.ID
+	db.Type = env.Type
+	db.Payload = string(body)
+	db.Status = nosql.DeliveryPending
+	db.Next = db.Created
+	db.Logs = make([]nosql.DeliveryLog, 0, 1)
+	return db
+}
+
+func enqueueDeliveries(ctx context.Context, hooks []*WebhookInfo, env *EventEnvelope, body []byte) error {
+	for _, hook := range hooks {
+		err := nosql.CreateDelivery(ctx, newDelivery(hook, env, body))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func signWebhook(secret, stamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//发送一次请求，返回2xx以外的响应都记录为错误
+func postWebhook(hook *WebhookInfo, db *nosql.Delivery) nosql.DeliveryLog {
+	now := time.Now()
+	log := nosql.DeliveryLog{Time: now.Unix()}
+	body := []byte(db.Payload)
+	stamp := strconv.FormatInt(now.Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Error = err.Error()
+		return log
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderEvent, db.Type)
+	req.Header.Set(WebhookHeaderDelivery, db.UID.Hex())
+	req.Header.Set(WebhookHeaderTimestamp, stamp)
+	req.Header.Set(WebhookHeaderSignature, signWebhook(hook.secret, stamp, body))
+	timeout := time.Duration(config.Schema.Webhook.Timeout) * time.Second
+	if timeout < time.Second {
+		timeout = 10 * time.Second
+	}
+	resp, err := webhookClient(timeout).Do(req)
+	log.Duration = time.Since(now).Milliseconds()
+	if err != nil {
+		log.Error = err.Error()
+		return log
+	}
+	defer resp.Body.Close()
+	bts, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseMax))
+	log.Code = resp.StatusCode
+	log.Response = string(bts)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Error = fmt.Sprintf("the response status code is %d", resp.StatusCode)
+	}
+	return log
+}
+
+//只允许公网地址，防止通过webhook访问内网服务
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+//注册时检查域名解析出的所有地址
+func checkWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) < 1 {
+		return errors.New("the webhook host can not be resolved")
+	}
+	for _, ip := range ips {
+		if !publicAddress(ip) {
+			return errors.New("the webhook host is not a public address")
+		}
+	}
+	return nil
+}
+
+//投递时在建立连接前检查实际连接的地址，域名重新解析到内网地址或者重定向到内网时也会被拒绝
+func webhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("the webhook address(%s) is not public", host)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		DisableKeepAlives:   true,
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+//第n次失败后等待 backoff * 2^(n-1) 秒，不超过最大值
+func webhookBackoff(attempts uint32) int64 {
+	base := config.Schema.Webhook.Backoff
+	if base < 1 {
+		base = 30
+	}
+	max := config.Schema.Webhook.MaxBackoff
+	if max < base {
+		max = 6 * 3600
+	}
+	wait := base
+	for i := uint32(1); i < attempts && wait < max; i += 1 {
+		wait = wait * 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+func deliverWebhook(db *nosql.Delivery) {
+	hook := findWebhook(db.Webhook)
+	attempts := db.Attempts + 1
+	var log nosql.DeliveryLog
+	if hook == nil {
+		log = nosql.DeliveryLog{Time: time.Now().Unix(), Error: "the webhook is removed"}
+		_ = nosql.UpdateDeliveryAttempt(db.UID.Hex(), nosql.DeliveryDead, attempts, 0, log)
+		return
+	}
+	log = postWebhook(hook, db)
+	if len(log.Error) < 1 {
+		_ = nosql.UpdateDeliveryAttempt(db.UID.Hex(), nosql.DeliverySuccess, attempts, 0, log)
+		return
+	}
+	retries := config.Schema.Webhook.Retries
+	if retries < 1 {
+		retries = 8
+	}
+	if attempts >= retries {
+		logger.Warn(fmt.Sprintf("the delivery(%s) of webhook(%s) is dead that msg = %s", db.UID.Hex(), hook.UID, log.Error))
+		_ = nosql.UpdateDeliveryAttempt(db.UID.Hex(), nosql.DeliveryDead, attempts, 0, log)
+		return
+	}
+	next := time.Now().Unix() + webhookBackoff(attempts)
+	_ = nosql.UpdateDeliveryAttempt(db.UID.Hex(), nosql.DeliveryPending, attempts, next, log)
+}
+
+//启动webhook的投递任务，每轮都会重新加载订阅
+func StartWebhooks() {
+	go func() {
+		interval := time.Duration(config.Schema.Webhook.Interval) * time.Second
+		if interval < time.Second {
+			interval = 5 * time.Second
+		}
+		for {
+			reloadWebhooks()
+			dbs, err := nosql.GetDueDeliveries(time.Now().Unix(), 100)
+			if err == nil {
+				for _, db := range dbs {
+					deliverWebhook(db)
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -68,6 +68,83 @@ const defaultJson string = `{
 	"basic": {
 		"synonym": 6,
 		"tag": 6
-	}
+	},
+	"web": {
+		"address": ":7077",
+		"timeout": 120
+	},
+	"scan": {
+		"network": "tcp",
+		"address": "",
+		"timeout": 120
+	},
+	"access": {
+		"enable": false,
+		"admins": []
+	},
+	"auth": {
+		"enable": false,
+		"issuer": "",
+		"skew": 300,
+		"keys": [],
+		"services": []
+	},
+	"event": {
+		"enable": true,
+		"prefix": "omo.event",
+		"interval": 2,
+		"batch": 100,
+		"retries": 10,
+		"retention": 72
+	},
+	"webhook": {
+		"interval": 5,
+		"timeout": 10,
+		"retries": 8,
+		"backoff": 30,
+		"max_backoff": 21600
+	},
+	"idempotency": {
+		"ttl": 86400,
+		"lease": 60
+	},
+	"batch": {
+		"limit": 200,
+		"max": 10000,
+		"stale": 300
+	},
+	"fsck": {
+		"interval": 24,
+		"repair": false,
+		"grace": 3600
+	},
+	"face": {
+		"probability": 0.8,
+		"blur": 0.7,
+		"size": 40,
+		"yaw": 45,
+		"pitch": 30,
+		"roll": 45,
+		"occlusion": 0.6
+	},
+	"review": {
+		"suggest": 70,
+		"accept": 90
+	},
+	"reconcile": {
+		"interval": 24,
+		"repair": false
+	},
+	"consent": {
+		"default": "opt_in"
+	},
+	"policies": [
+		{"type": 0, "formats": ["jpg","jpeg","png","bmp","gif","webp"], "size": 52428800, "width": 12000, "height": 12000},
+		{"type": 4, "formats": ["mp3","wav","aac","ogg","m4a"], "size": 209715200},
+		{"type": 5, "formats": ["mp4","mov","avi","webm","m4v"], "size": 2147483648},
+		{"type": 6, "formats": ["jpg","jpeg","png","webp"], "size": 5242880, "width": 4096, "height": 4096},
+		{"type": 7, "formats": ["png","jpg","jpeg","svg","ico","webp"], "size": 2097152, "width": 2048, "height": 2048},
+		{"type": 8, "formats": ["pdf","jpg","jpeg","png"], "size": 20971520}
+	]
 }
 `
@@ -21,6 +21,129 @@ type DBConfig struct {
 	Name     string `json:"name"`
 }
 
+//资源类型的上传策略
+type PolicyConfig struct {
+	Type    uint8    `json:"type"`
+	Formats []string `json:"formats"`
+	//最大字节数，0表示不限制
+	Size   uint64 `json:"size"`
+	Width  uint32 `json:"width"`
+	Height uint32 `json:"height"`
+}
+
+//clamd病毒扫描服务，地址为空时不扫描
+type ScanConfig struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	//超时时间（秒）
+	Timeout int64 `json:"timeout"`
+}
+
+//访问控制，Admins为管理员用户
+type AccessConfig struct {
+	Enable bool     `json:"enable"`
+	Admins []string `json:"admins"`
+}
+
+//调用者认证，Issuer为空时不校验签发者
+type AuthConfig struct {
+	Enable   bool          `json:"enable"`
+	Issuer   string        `json:"issuer"`
+	Keys     []AuthKey     `json:"keys"`
+	Services []AuthService `json:"services"`
+	//服务签名允许的时间偏差（秒）
+	Skew int64 `json:"skew"`
+}
+
+//JWT的验证密钥，HS256为共享密钥，RS256和ES256为PEM格式的公钥
+type AuthKey struct {
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Key string `json:"key"`
+}
+
+//使用HMAC签名的内部服务
+type AuthService struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+	Admin  bool   `json:"admin"`
+}
+
+//领域事件，通过发件箱发布到broker，Interval为发布的间隔（秒），
+//Retries次发布失败后转为死信，已发布的事件保留Retention小时
+type EventConfig struct {
+	Enable    bool   `json:"enable"`
+	Prefix    string `json:"prefix"`
+	Interval  int64  `json:"interval"`
+	Batch     int64  `json:"batch"`
+	Retries   uint32 `json:"retries"`
+	Retention int64  `json:"retention"`
+}
+
+//webhook投递，失败后按Backoff（秒）指数退避，Retries次后不再投递
+type WebhookConfig struct {
+	Interval   int64  `json:"interval"`
+	Timeout    int64  `json:"timeout"`
+	Retries    uint32 `json:"retries"`
+	Backoff    int64  `json:"backoff"`
+	MaxBackoff int64  `json:"max_backoff"`
+}
+
+//幂等键的保存时间（秒）
+type IdempotencyConfig struct {
+	TTL   int64 `json:"ttl"`
+	Lease int64 `json:"lease"`
+}
+
+//批量操作，超过Limit个资源时在后台执行，单次最多Max个；后台任务超过Stale秒没有心跳时标记为失败
+type BatchConfig struct {
+	Limit int   `json:"limit"`
+	Max   int   `json:"max"`
+	Stale int64 `json:"stale"`
+}
+
+//一致性检查，Interval为定时检查的间隔（小时），为0时不定时检查；Grace（秒）内新写入的数据不检查
+type FsckConfig struct {
+	Interval int64 `json:"interval"`
+	Repair   bool  `json:"repair"`
+	Grace    int64 `json:"grace"`
+}
+
+//人脸的质量要求，不满足的人脸不裁剪和注册；为0的项不检查
+type FaceConfig struct {
+	Probability float32 `json:"probability"`
+	Blur        float32 `json:"blur"`
+	//人脸框的最小宽高（像素）
+	Size      uint32  `json:"size"`
+	Yaw       float32 `json:"yaw"`
+	Pitch     float32 `json:"pitch"`
+	Roll      float32 `json:"roll"`
+	Occlusion float32 `json:"occlusion"`
+}
+
+//人脸匹配的相似度阈值[0, 100]，Suggest和Accept之间的匹配需要人工确认；Accept为0时不需要确认
+type ReviewConfig struct {
+	Suggest int `json:"suggest"`
+	Accept  int `json:"accept"`
+}
+
+//人脸库对账，Interval为定时对账的间隔（小时），为0时不定时对账；宽限期和一致性检查相同
+type ReconcileConfig struct {
+	Interval int64 `json:"interval"`
+	Repair   bool  `json:"repair"`
+}
+
+//人脸识别授权，Default为没有授权记录时的状态：opt_in、opt_out或者pending
+type ConsentConfig struct {
+	Default string `json:"default"`
+}
+
+//Timeout为导出时下载一个文件的最长时间（秒）
+type WebConfig struct {
+	Address string `json:"address"`
+	Timeout int64  `json:"timeout"`
+}
+
 type BasicConfig struct {
 	SynonymMax int `ini:"synonym"`
 	TagMax     int `ini:"tag"`
@@ -80,11 +203,25 @@ type APIOption struct {
 }
 
 type SchemaConfig struct {
-	Service   ServiceConfig `json:"service"`
-	Logger    LoggerConfig  `json:"logger"`
-	Database  DBConfig      `json:"database"`
-	Basic     BasicConfig   `json:"basic"`
-	Storage   StorageConfig `json:"storage"`
-	Examine   ExamineConfig `json:"examine"`
-	Detection DetectConfig  `json:"detection"`
+	Service     ServiceConfig     `json:"service"`
+	Logger      LoggerConfig      `json:"logger"`
+	Database    DBConfig          `json:"database"`
+	Basic       BasicConfig       `json:"basic"`
+	Storage     StorageConfig     `json:"storage"`
+	Examine     ExamineConfig     `json:"examine"`
+	Detection   DetectConfig      `json:"detection"`
+	Web         WebConfig         `json:"web"`
+	Policies    []PolicyConfig    `json:"policies"`
+	Scan        ScanConfig        `json:"scan"`
+	Access      AccessConfig      `json:"access"`
+	Auth        AuthConfig        `json:"auth"`
+	Event       EventConfig       `json:"event"`
+	Webhook     WebhookConfig     `json:"webhook"`
+	Idempotency IdempotencyConfig `json:"idempotency"`
+	Batch       BatchConfig       `json:"batch"`
+	Fsck        FsckConfig        `json:"fsck"`
+	Face        FaceConfig        `json:"face"`
+	Review      ReviewConfig      `json:"review"`
+	Reconcile   ReconcileConfig   `json:"reconcile"`
+	Consent     ConsentConfig     `json:"consent"`
 }
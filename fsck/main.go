@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"omo.msa.asset/cache"
+	"omo.msa.asset/config"
+	"omo.msa.asset/proxy/nosql"
+	"os"
+)
+
+//一致性检查的命令行工具，使用和服务相同的配置
+func main() {
+	repair := flag.Bool("repair", false, "repair the issues: reupload, relink, move to the recycle bin, or register and remove the faces")
+	operator := flag.String("operator", "fsck", "the operator written to the audit log")
+	verbose := flag.Bool("v", false, "print every issue")
+	face := flag.Bool("face", false, "reconcile the face library with the thumbs instead of the storage check")
+	flag.Parse()
+
+	config.Setup()
+	err := cache.InitData()
+	if err != nil {
+		fmt.Println("init the database failed: " + err.Error())
+		os.Exit(2)
+	}
+	var report *nosql.Report
+	if *face {
+		report, err = cache.Context().RunReconcile(*operator, *repair)
+	} else {
+		report, err = cache.Context().RunFsck(*operator, *repair)
+	}
+	if err != nil {
+		fmt.Println("fsck failed: " + err.Error())
+		os.Exit(2)
+	}
+	fmt.Printf("report: %s\n", report.UID.Hex())
+	fmt.Printf("scanned: %v\n", report.Scanned)
+	fmt.Printf("issues: %v\n", report.Counts)
+	if *verbose {
+		for _, issue := range report.Issues {
+			fmt.Printf("%-24s %-26s %-10s repaired=%v %s %s\n", issue.Kind, issue.Target, issue.Action, issue.Repaired, issue.Detail, issue.Error)
+		}
+	}
+	if report.Status != nosql.ReportFinished {
+		fmt.Println("fsck stopped: " + report.Error)
+		os.Exit(2)
+	}
+	var total uint32
+	for kind, num := range report.Counts {
+		if kind != "repaired" {
+			total += num
+		}
+	}
+	if total > report.Counts["repaired"] {
+		os.Exit(1)
+	}
+}
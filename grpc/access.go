@@ -0,0 +1,23 @@
+package grpc
+
+import (
+	"context"
+	"github.com/micro/go-micro/v2/metadata"
+	"omo.msa.asset/cache"
+	"strings"
+)
+
+const msgForbidden = "the operator has no permission"
+
+//开启认证时使用认证后的身份，否则使用网关透传的身份；透传的身份没有校验，不读取角色
+func getPrincipal(ctx context.Context) *cache.Principal {
+	if who, ok := ctx.Value(principalKey{}).(*cache.Principal); ok {
+		return who
+	}
+	if cache.AuthEnable() {
+		return cache.NewPrincipal("", nil, nil)
+	}
+	user, _ := metadata.Get(ctx, cache.HeaderUser)
+	scenes, _ := metadata.Get(ctx, cache.HeaderScenes)
+	return cache.NewPrincipal(strings.TrimSpace(user), cache.SplitHeader(scenes), nil)
+}
@@ -2,10 +2,12 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
 	"omo.msa.asset/cache"
 	"omo.msa.asset/config"
+	"omo.msa.asset/tool"
 	"strconv"
 )
 
@@ -75,12 +77,39 @@ func (mine *AssetService) AddOne(ctx context.Context, in *pb.ReqAssetAdd, out *p
 		out.Status = outError(path, "the owner is empty", pb.ResultStatus_Empty)
 		return nil
 	}
+	who := getPrincipal(ctx)
+	if !who.CanScene(in.Owner, cache.ActionWrite) || (in.Scope == cache.AssetScopeSystem && !who.CanManage()) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	err := cache.CheckAssetPolicy(uint8(in.Type), in.Format, in.Size, in.Width, in.Height)
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return nil
+	}
 
+	key, uid, err := beginIdempotent(ctx, path, in)
+	if err != nil {
+		out.Status = outError(path, err.Error(), idempotentStatus(err))
+		return nil
+	}
+	if len(uid) > 0 {
+		info := cache.Context().GetAsset(uid)
+		if info == nil {
+			out.Status = outError(path, "the asset of the idempotency key not found", pb.ResultStatus_NotExisted)
+			return nil
+		}
+		out.Info = switchAsset(in.Owner, info)
+		out.Status = outLog(path, out)
+		return nil
+	}
 	info, err := cache.Context().CreateAsset(in)
 	if err != nil {
+		cache.CancelIdempotent(key)
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
 		return nil
 	}
+	cache.FinishIdempotent(key, info.UID)
 	out.Info = switchAsset(in.Owner, info)
 	out.Status = outLog(path, out)
 	return nil
@@ -99,6 +128,10 @@ func (mine *AssetService) GetOne(ctx context.Context, in *pb.RequestInfo, out *p
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(info, cache.ActionRead) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	out.Info = switchAsset(in.Owner, info)
 	out.Status = outNonLog()
 	return nil
@@ -114,6 +147,10 @@ func (mine *AssetService) RemoveOne(ctx context.Context, in *pb.RequestInfo, out
 	var err error
 	info := cache.Context().GetAsset(in.Uid)
 	if info != nil {
+		if !getPrincipal(ctx).CanAsset(info, cache.ActionWrite) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
 		err = info.Remove(in.Operator)
 	}
 	if err != nil {
@@ -127,10 +164,11 @@ func (mine *AssetService) RemoveOne(ctx context.Context, in *pb.RequestInfo, out
 func (mine *AssetService) GetList(ctx context.Context, in *pb.ReqAssetList, out *pb.ReplyAssetList) error {
 	path := "asset.getList"
 	inLog(path, in)
+	who := getPrincipal(ctx)
 	out.List = make([]*pb.AssetInfo, 0, len(in.List))
 	for _, val := range in.List {
 		info := cache.Context().GetAsset(val)
-		if info != nil {
+		if info != nil && who.CanAsset(info, cache.ActionRead) {
 			out.List = append(out.List, switchAsset(info.Owner, info))
 		}
 	}
@@ -141,13 +179,89 @@ func (mine *AssetService) GetList(ctx context.Context, in *pb.ReqAssetList, out
 func (mine *AssetService) GetStatistic(ctx context.Context, in *pb.RequestFilter, out *pb.ReplyStatistic) error {
 	path := "asset.getStatistic"
 	inLog(path, in)
+	if cache.AccessEnable() && getPrincipal(ctx).Anonymous() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	if in.Key == "webhooks" || in.Key == "webhook_deliveries" {
+		getWebhookStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "revision" {
+		getRevisionStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "batch" {
+		previewBatch(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "batch_job" {
+		getBatchJob(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "fsck_report" {
+		getFsckStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "reconcile_report" {
+		getReconcileStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "persons" || in.Key == "person" {
+		getPersonStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "groups" {
+		getGroupStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "consents" {
+		getConsentStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "reviews" || in.Key == "review" {
+		getReviewStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "thumb_attribute" {
+		getAttributeStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
+	if in.Key == "crop" {
+		getCropStatistic(path, getPrincipal(ctx), in, out)
+		return nil
+	}
 	if in.Key == "quote" {
 		out.Count = cache.Context().GetAssetCount(in.Value)
 	} else if in.Key == "quote_creator" {
 		out.Count = cache.Context().GetAssetCountByQuoteCreator(in.Value, in.Operator)
 	} else if in.Key == "owner_creator" {
 		out.Count = cache.Context().GetAssetCountByOwnerCreator(in.Value, in.Operator)
+	} else if in.Key == "audit_target" || in.Key == "audit_actor" || in.Key == "audit_time" {
+		if !getPrincipal(ctx).CanManage() {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		var target, actor string
+		var from, to int64
+		if in.Key == "audit_target" {
+			target = in.Value
+		} else if in.Key == "audit_actor" {
+			actor = in.Value
+		}
+		if len(in.Numbers) == 2 {
+			from = in.Numbers[0]
+			to = in.Numbers[1]
+		}
+		total, _, list := cache.Context().GetAudits(target, actor, from, to, in.Page, in.Number)
+		out.Count = total
+		out.List = make([]*pb.PairInfo, 0, len(list))
+		for _, item := range list {
+			bts, _ := json.Marshal(item)
+			out.List = append(out.List, &pb.PairInfo{Key: item.UID, Value: string(bts)})
+		}
 	}
+	out.Key = in.Key
 	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
 	return nil
 }
@@ -198,10 +312,19 @@ func (mine *AssetService) GetByFilter(ctx context.Context, in *pb.RequestFilter,
 			return nil
 		}
 		total, pages, list = cache.Context().GetAssetsByQuoteStatus(in.Owner, uint32(st), in.Page, in.Number)
+	} else if in.Key == "quarantine" {
+		if !getPrincipal(ctx).CanManage() {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		list = cache.Context().GetQuarantineAssets()
 	}
+	who := getPrincipal(ctx)
 	out.List = make([]*pb.AssetInfo, 0, len(list))
 	for _, info := range list {
-		out.List = append(out.List, switchAsset(info.Owner, info))
+		if who.CanAsset(info, cache.ActionRead) {
+			out.List = append(out.List, switchAsset(info.Owner, info))
+		}
 	}
 	out.Total = total
 	out.Pages = pages
@@ -230,10 +353,13 @@ func (mine *AssetService) GetByOwner(ctx context.Context, in *pb.RequestInfo, ou
 		list = cache.Context().GetAssetsByOwner(in.Owner)
 	}
 
+	who := getPrincipal(ctx)
 	out.Owner = in.Owner
 	out.List = make([]*pb.AssetInfo, 0, len(list))
 	for _, val := range list {
-		out.List = append(out.List, switchAsset(in.Owner, val))
+		if who.CanAsset(val, cache.ActionRead) {
+			out.List = append(out.List, switchAsset(in.Owner, val))
+		}
 	}
 	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
 	return nil
@@ -242,6 +368,10 @@ func (mine *AssetService) GetByOwner(ctx context.Context, in *pb.RequestInfo, ou
 func (mine *AssetService) GetToken(ctx context.Context, in *pb.RequestInfo, out *pb.ReplyAssetToken) error {
 	path := "asset.getToken"
 	inLog(path, in)
+	if cache.AccessEnable() && getPrincipal(ctx).Anonymous() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	out.Expire = uint32(config.Schema.Storage.Expire)
 	out.Domain = config.Schema.Storage.Domain
 	out.Bucket = config.Schema.Storage.Bucket
@@ -265,6 +395,10 @@ func (mine *AssetService) UpdateSnapshot(ctx context.Context, in *pb.ReqAssetFla
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(info, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	err := info.UpdateSnapshot(in.Operator, in.Flag)
 	if err != nil {
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
@@ -286,6 +420,10 @@ func (mine *AssetService) UpdateSmall(ctx context.Context, in *pb.ReqAssetFlag,
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(info, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	err := info.UpdateSmall(in.Operator, in.Flag)
 	if err != nil {
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
@@ -307,9 +445,18 @@ func (mine *AssetService) UpdateBase(ctx context.Context, in *pb.ReqAssetUpdate,
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
-	err := info.UpdateBase(in.Operator, in.Name, in.Remark)
+	if !getPrincipal(ctx).CanAsset(info, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	revision, err := getRevision(ctx)
 	if err != nil {
-		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return nil
+	}
+	err = info.UpdateBase(in.Operator, in.Name, in.Remark, revision)
+	if err != nil {
+		out.Status = outError(path, err.Error(), writeStatus(err))
 		return nil
 	}
 	out.Status = outLog(path, out)
@@ -328,6 +475,10 @@ func (mine *AssetService) UpdateMeta(ctx context.Context, in *pb.ReqAssetFlag, o
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(info, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	err := info.UpdateMeta(in.Operator, in.Flag)
 	if err != nil {
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
@@ -349,6 +500,10 @@ func (mine *AssetService) UpdateWeight(ctx context.Context, in *pb.ReqAssetWeigh
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(info, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	err := info.UpdateWeight(in.Weight, in.Operator)
 	if err != nil {
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
@@ -370,9 +525,18 @@ func (mine *AssetService) UpdateStatus(ctx context.Context, in *pb.ReqAssetWeigh
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
-	err := info.UpdateStatus(uint8(in.Weight), in.Operator)
+	if !getPrincipal(ctx).CanAsset(info, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	revision, err := getRevision(ctx)
 	if err != nil {
-		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return nil
+	}
+	err = info.UpdateStatus(uint8(in.Weight), in.Operator, revision)
+	if err != nil {
+		out.Status = outError(path, err.Error(), writeStatus(err))
 		return nil
 	}
 	out.Uid = in.Uid
@@ -384,19 +548,51 @@ func (mine *AssetService) UpdateByFilter(ctx context.Context, in *pb.RequestUpda
 	path := "asset.updateByFilter"
 	inLog(path, in)
 	var err error
+	who := getPrincipal(ctx)
 	if in.Uid == "" {
 		if in.Field == "status" {
+			for _, uid := range in.Values {
+				if !who.CanAsset(cache.Context().GetAsset(uid), cache.ActionWrite) {
+					out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+					return nil
+				}
+			}
 			st, er := strconv.Atoi(in.Value)
 			if er != nil {
-				out.Status = outError(path, er.Error(), pb.ResultStatus_DBException)
+				out.Status = outError(path, "the status is invalid", ResultStatusInvalid)
+				return nil
 			}
 			err = cache.Context().UpdateAssetsStatus(in.Values, uint32(st), in.Operator)
 		} else if in.Field == "publish" {
+			if !who.CanScene(in.Value, cache.ActionWrite) {
+				out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+				return nil
+			}
 			err = cache.Context().PublishAssetsEntity(in.Value, in.Operator)
+		} else if in.Field == "webhook_add" || in.Field == "webhook_remove" || in.Field == "webhook_ping" {
+			updateWebhook(path, who, in, out)
+			return nil
+		} else if in.Field == "consent" {
+			updateConsent(path, who, in, out)
+			return nil
+		} else if in.Field == "batch" {
+			runBatch(path, who, in, out)
+			return nil
+		} else if in.Field == "fsck" {
+			startFsck(path, who, in, out)
+			return nil
+		} else if in.Field == "reconcile" {
+			startReconcile(path, who, in, out)
+			return nil
+		} else if !who.CanManage() {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
 		} else if in.Field == "batch_scope" {
 			err = cache.Context().BatchUpdateScope(in.Values)
+		} else if in.Field == "rescan" {
+			err = cache.Context().RescanAssets(in.Operator)
 		} else {
-			out.Status = outError(path, "not define the field", pb.ResultStatus_DBException)
+			out.Status = outError(path, "not define the field", ResultStatusInvalid)
 			return nil
 		}
 	} else {
@@ -405,28 +601,81 @@ func (mine *AssetService) UpdateByFilter(ctx context.Context, in *pb.RequestUpda
 			out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 			return nil
 		}
+		if !who.CanAsset(info, cache.ActionWrite) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		revision, er := getRevision(ctx)
+		if er != nil {
+			out.Status = outError(path, er.Error(), ResultStatusInvalid)
+			return nil
+		}
 
-		if in.Field == "type" {
-			tp, _ := strconv.ParseUint(in.Value, 10, 32)
-			err = info.UpdateType(uint8(tp), in.Operator)
+		if in.Field == "mask" {
+			values, er := parsePatch(in)
+			if er != nil {
+				out.Status = outError(path, er.Error(), ResultStatusInvalid)
+				return nil
+			}
+			if owner, ok := patchString(in, values, "owner"); ok && !who.CanScene(owner, cache.ActionWrite) {
+				out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+				return nil
+			}
+			if tool.HasItem(in.Values, "scope") && !who.CanManage() {
+				out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+				return nil
+			}
+			err = info.Patch(in.Operator, revision, in.Values, values)
+			if err != nil {
+				out.Status = outError(path, err.Error(), writeStatus(err))
+				return nil
+			}
+		} else if in.Field == "type" {
+			tp, er := strconv.ParseUint(in.Value, 10, 8)
+			if er != nil {
+				out.Status = outError(path, "the type is invalid", ResultStatusInvalid)
+				return nil
+			}
+			err = info.UpdateType(uint8(tp), in.Operator, revision)
 		} else if in.Field == "language" {
-			err = info.UpdateLanguage(in.Value, in.Operator)
+			err = info.UpdateLanguage(in.Value, in.Operator, revision)
 		} else if in.Field == "links" {
-			err = info.UpdateLinks(in.Operator, in.Values)
+			err = info.UpdateLinks(in.Operator, in.Values, revision)
 		} else if in.Field == "owner" {
-			err = info.UpdateOwner(in.Operator, in.Value)
+			if !who.CanScene(in.Value, cache.ActionWrite) {
+				out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+				return nil
+			}
+			err = info.UpdateOwner(in.Operator, in.Value, revision)
 		} else if in.Field == "quote" {
-			err = info.UpdateQuote(in.Operator, in.Value)
+			err = info.UpdateQuote(in.Operator, in.Value, revision)
 		} else if in.Field == "tags" {
-			err = info.UpdateTags(in.Operator, in.Values)
+			err = info.UpdateTags(in.Operator, in.Values, revision)
+		} else if revision != cache.RevisionAny {
+			//重新扫描和裁剪不修改资源的字段，不检查修订号
+			out.Status = outError(path, "the field does not support the revision", ResultStatusInvalid)
+			return nil
+		} else if in.Field == "rescan" {
+			if !who.CanManage() {
+				out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+				return nil
+			}
+			err = info.Rescan()
+		} else if in.Field == "small_crop" {
+			wid, hei, er := parseCropSize(in.Value)
+			if er != nil {
+				out.Status = outError(path, er.Error(), ResultStatusInvalid)
+				return nil
+			}
+			_, err = info.CreateSmall(in.Operator, wid, hei)
 		} else {
-			out.Status = outError(path, "not define the field", pb.ResultStatus_DBException)
+			out.Status = outError(path, "not define the field", ResultStatusInvalid)
 			return nil
 		}
 	}
 
 	if err != nil {
-		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		out.Status = outError(path, err.Error(), writeStatus(err))
 		return nil
 	}
 	out.Uid = in.Uid
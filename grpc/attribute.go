@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+)
+
+//attribute的value为人脸属性的查询条件
+func getThumbsByAttribute(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyThumbList) {
+	filter := new(cache.FaceFilter)
+	err := json.Unmarshal([]byte(in.Value), filter)
+	if err != nil {
+		out.Status = outError(path, "the attribute value is invalid", ResultStatusInvalid)
+		return
+	}
+	list, err := cache.Context().GetThumbsByAttribute(filter)
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return
+	}
+	out.Owner = in.Owner
+	out.List = make([]*pb.ThumbInfo, 0, len(list))
+	for _, item := range list {
+		if who.CanThumb(item, cache.ActionRead) {
+			out.List = append(out.List, switchThumb(item))
+		}
+	}
+	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
+}
+
+//thumb_attribute的values为人脸的uid，list的key为人脸uid，value为人脸属性
+func getAttributeStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	out.List = make([]*pb.PairInfo, 0, len(in.Values))
+	for _, uid := range in.Values {
+		info := cache.Context().GetThumb(uid)
+		if info == nil || !who.CanThumb(info, cache.ActionRead) {
+			continue
+		}
+		bts, _ := json.Marshal(info.Attribute)
+		out.List = append(out.List, &pb.PairInfo{Key: uid, Value: string(bts)})
+	}
+	out.Key = in.Key
+	out.Count = uint32(len(out.List))
+	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
+}
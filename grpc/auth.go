@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"github.com/micro/go-micro/v2/metadata"
+	"github.com/micro/go-micro/v2/server"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+	"reflect"
+	"strings"
+)
+
+type principalKey struct{}
+
+//认证调用者，认证通过后把身份放到context里，写接口用认证的用户覆盖请求里的Operator，
+//读接口的Operator是查询参数（比如GetOne的key、GetByOwner的类型），保持不变
+func AuthWrapper(fn server.HandlerFunc) server.HandlerFunc {
+	return func(ctx context.Context, req server.Request, rsp interface{}) error {
+		if !cache.AuthEnable() {
+			return fn(ctx, req, rsp)
+		}
+		md, _ := metadata.FromContext(ctx)
+		get := func(key string) string {
+			val, _ := md.Get(key)
+			return val
+		}
+		who, err := cache.Authenticate(get, req.Endpoint())
+		if err != nil {
+			setReplyStatus(rsp, outError(req.Endpoint(), err.Error(), ResultStatusUnauthenticated))
+			return nil
+		}
+		if isWriteEndpoint(req.Endpoint()) {
+			setOperator(req.Body(), who.User)
+		}
+		return fn(context.WithValue(ctx, principalKey{}, who), req, rsp)
+	}
+}
+
+//Add、Remove、Update开头的方法会修改数据，其余的方法（Get开头）只读
+func isWriteEndpoint(endpoint string) bool {
+	method := endpoint
+	if idx := strings.LastIndex(endpoint, "."); idx > -1 {
+		method = endpoint[idx+1:]
+	}
+	return strings.HasPrefix(method, "Add") || strings.HasPrefix(method, "Remove") ||
+		strings.HasPrefix(method, "Update")
+}
+
+func setOperator(body interface{}, operator string) {
+	val := reflect.ValueOf(body)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return
+	}
+	field := val.Elem().FieldByName("Operator")
+	if field.IsValid() && field.CanSet() && field.Kind() == reflect.String {
+		field.SetString(operator)
+	}
+}
+
+func setReplyStatus(rsp interface{}, status *pb.ReplyStatus) {
+	val := reflect.ValueOf(rsp)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return
+	}
+	field := val.Elem().FieldByName("Status")
+	if field.IsValid() && field.CanSet() && field.Type() == reflect.TypeOf(status) {
+		field.Set(reflect.ValueOf(status))
+	}
+}
@@ -0,0 +1,88 @@
+package grpc
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/micro/go-micro/v2/metadata"
+	"github.com/micro/go-micro/v2/server"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/config"
+	"testing"
+	"time"
+)
+
+type testRequest struct {
+	server.Request
+	endpoint string
+	body     interface{}
+}
+
+func (mine *testRequest) Endpoint() string {
+	return mine.endpoint
+}
+
+func (mine *testRequest) Body() interface{} {
+	return mine.body
+}
+
+func TestIsWriteEndpoint(t *testing.T) {
+	cases := map[string]bool{
+		"AssetService.AddOne":         true,
+		"AssetService.RemoveOne":      true,
+		"AssetService.UpdateByFilter": true,
+		"FolderService.UpdateBase":    true,
+		"AssetService.GetOne":         false,
+		"AssetService.GetByOwner":     false,
+		"AssetService.GetToken":       false,
+		"ThumbService.GetByFilter":    false,
+		"LabelService.GetStatistic":   false,
+	}
+	for endpoint, want := range cases {
+		if got := isWriteEndpoint(endpoint); got != want {
+			t.Errorf("isWriteEndpoint(%q) = %v, want %v", endpoint, got, want)
+		}
+	}
+}
+
+func TestAuthWrapperOperator(t *testing.T) {
+	auth := config.Schema.Auth
+	defer func() { config.Schema.Auth = auth }()
+	config.Schema.Auth = config.AuthConfig{
+		Enable: true,
+		Keys:   []config.AuthKey{{Kid: "test", Alg: "HS256", Key: "secret"}},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "alice",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewContext(context.Background(), metadata.Metadata{"Authorization": "Bearer " + signed})
+
+	cases := []struct {
+		endpoint string
+		operator string
+		want     string
+	}{
+		//GetOne没有uid时按Operator里的key查询
+		{"AssetService.GetOne", "asset-key", "asset-key"},
+		{"AssetService.GetByOwner", "publish", "publish"},
+		{"AssetService.UpdateBase", "mallory", "alice"},
+		{"AssetService.RemoveOne", "", "alice"},
+	}
+	for _, item := range cases {
+		in := &pb.RequestInfo{Operator: item.operator}
+		var got string
+		handler := AuthWrapper(func(ctx context.Context, req server.Request, rsp interface{}) error {
+			got = req.Body().(*pb.RequestInfo).Operator
+			return nil
+		})
+		err := handler(ctx, &testRequest{endpoint: item.endpoint, body: in}, &pb.ReplyInfo{})
+		if err != nil || got != item.want {
+			t.Errorf("%s: operator = %q %v, want %q", item.endpoint, got, err, item.want)
+		}
+	}
+}
@@ -1,11 +1,118 @@
 package grpc
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/metadata"
 	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+	"omo.msa.asset/tool"
+	"strconv"
 )
 
+//proto里面没有定义的状态码
+const (
+	ResultStatusInvalid         pb.ResultStatus = 11 //不符合资源类型的策略
+	ResultStatusForbidden       pb.ResultStatus = 12 //没有操作权限
+	ResultStatusUnauthenticated pb.ResultStatus = 13 //调用者认证失败
+	ResultStatusConflict        pb.ResultStatus = 14 //修订号不一致
+)
+
+//metadata里预期的修订号
+const headerRevision = "X-Revision"
+
+//字段掩码修改（field为mask）：values为字段名，value为包含这些字段的JSON对象
+func parsePatch(in *pb.RequestUpdate) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	if len(in.Value) > 0 {
+		err := json.Unmarshal([]byte(in.Value), &values)
+		if err != nil {
+			return nil, errors.New("the mask value is not a json object")
+		}
+	}
+	return values, nil
+}
+
+//掩码里的字符串字段，用于修改前的权限判断
+func patchString(in *pb.RequestUpdate, values map[string]json.RawMessage, key string) (string, bool) {
+	if !tool.HasItem(in.Values, key) {
+		return "", false
+	}
+	var val string
+	_ = json.Unmarshal(values[key], &val)
+	return val, true
+}
+
+//没有携带预期的修订号时不检查
+func getRevision(ctx context.Context) (int64, error) {
+	val, ok := metadata.Get(ctx, headerRevision)
+	if !ok || len(val) < 1 {
+		return cache.RevisionAny, nil
+	}
+	rev, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || rev < 0 {
+		return cache.RevisionAny, errors.New("the revision is invalid")
+	}
+	return rev, nil
+}
+
+//当前的修订号，owner为asset、thumb、folder或label，value为uid
+func getRevisionStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	var revision uint64
+	var allow bool
+	found := true
+	switch in.Owner {
+	case "", cache.AuditAsset:
+		info := cache.Context().GetAsset(in.Value)
+		if found = info != nil; found {
+			revision, allow = info.Revision, who.CanAsset(info, cache.ActionRead)
+		}
+	case cache.AuditThumb:
+		info := cache.Context().GetThumb(in.Value)
+		if found = info != nil; found {
+			revision, allow = info.Revision, who.CanThumb(info, cache.ActionRead)
+		}
+	case cache.AuditFolder:
+		info, _ := cache.Context().GetFolder(in.Value)
+		if found = info != nil; found {
+			revision, allow = info.Revision, who.CanFolder(info, cache.ActionRead)
+		}
+	case cache.AuditLabel:
+		info, _ := cache.Context().GetLabel(in.Value)
+		if found = info != nil; found {
+			revision, allow = info.Revision, who.CanLabel(info, cache.ActionRead)
+		}
+	default:
+		out.Status = outError(path, "not define the owner", ResultStatusInvalid)
+		return
+	}
+	if !found {
+		out.Status = outError(path, "not found the item", pb.ResultStatus_NotExisted)
+		return
+	}
+	if !allow {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	out.Key = in.Key
+	out.Owner = in.Owner
+	out.List = []*pb.PairInfo{{Key: in.Value, Value: strconv.FormatUint(revision, 10)}}
+	out.Status = outLog(path, out)
+}
+
+//写操作失败时的状态码
+func writeStatus(err error) pb.ResultStatus {
+	if errors.Is(err, cache.ErrPatchInvalid) {
+		return ResultStatusInvalid
+	}
+	if errors.Is(err, cache.ErrConflict) {
+		return ResultStatusConflict
+	}
+	return pb.ResultStatus_DBException
+}
+
 func outError(name, msg string, code pb.ResultStatus) *pb.ReplyStatus {
 	logger.Warnf("[error.%s]:code = %d, msg = %s", name, code, msg)
 	tmp := &pb.ReplyStatus{
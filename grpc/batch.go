@@ -0,0 +1,94 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+	"omo.msa.asset/proxy/nosql"
+)
+
+//GetStatistic的batch只预览（dry_run），value为操作的参数，values为资源的uid
+func previewBatch(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	spec := new(cache.BatchSpec)
+	err := json.Unmarshal([]byte(in.Value), spec)
+	if err != nil {
+		out.Status = outError(path, "the batch value is invalid", ResultStatusInvalid)
+		return
+	}
+	if !spec.DryRun {
+		out.Status = outError(path, "the batch must be run by UpdateByFilter", ResultStatusInvalid)
+		return
+	}
+	spec.Assets = in.Values
+	report, err := cache.Context().RunBatch(who, in.Operator, spec)
+	if err != nil {
+		out.Status = outError(path, err.Error(), batchStatus(err))
+		return
+	}
+	writeBatchReport(report, out)
+	out.Status = outLog(path, out)
+}
+
+//UpdateByFilter的batch执行批量操作，value为操作的参数，values为资源的uid；返回的uid为任务的uid
+func runBatch(path string, who *cache.Principal, in *pb.RequestUpdate, out *pb.ReplyInfo) {
+	spec := new(cache.BatchSpec)
+	err := json.Unmarshal([]byte(in.Value), spec)
+	if err != nil {
+		out.Status = outError(path, "the batch value is invalid", ResultStatusInvalid)
+		return
+	}
+	spec.DryRun = false
+	spec.Assets = in.Values
+	report, err := cache.Context().RunBatch(who, in.Operator, spec)
+	if err != nil {
+		out.Status = outError(path, err.Error(), batchStatus(err))
+		return
+	}
+	out.Uid = report.Job
+	out.Status = outLog(path, out)
+}
+
+func batchStatus(err error) pb.ResultStatus {
+	if errors.Is(err, cache.ErrBatchInvalid) {
+		return ResultStatusInvalid
+	}
+	if err.Error() == msgForbidden {
+		return ResultStatusForbidden
+	}
+	return pb.ResultStatus_DBException
+}
+
+//batch_job的value为后台任务的uid，只有创建者和管理员可以查看
+func getBatchJob(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	report, err := cache.Context().GetBatchJob(in.Value)
+	if err != nil {
+		out.Status = outError(path, "the batch job not found", pb.ResultStatus_NotExisted)
+		return
+	}
+	if cache.AccessEnable() && report.Creator != who.User && !who.CanManage() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	writeBatchReport(report, out)
+	out.Status = outLog(path, out)
+}
+
+//key为状态，count为总数，list里的每一项是一个资源的结果
+func writeBatchReport(report *cache.BatchReport, out *pb.ReplyStatistic) {
+	out.Owner = report.Job
+	out.Count = report.Total
+	out.Key = "running"
+	if report.Status == nosql.BatchFinished {
+		out.Key = "finished"
+	} else if report.Status == nosql.BatchFailed {
+		out.Key = "failed"
+	}
+	out.List = make([]*pb.PairInfo, 0, len(report.Results)+1)
+	out.List = append(out.List, &pb.PairInfo{Key: "done", Count: report.Done, Value: report.Error})
+	out.List = append(out.List, &pb.PairInfo{Key: "failed", Count: report.Failed})
+	for i, result := range report.Results {
+		bts, _ := json.Marshal(result)
+		out.List = append(out.List, &pb.PairInfo{Key: result.UID, Value: string(bts), Index: uint32(i)})
+	}
+}
@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"encoding/json"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+)
+
+type consentRequest struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	State  string `json:"state"`
+	Remark string `json:"remark"`
+}
+
+//范围的授权只有管理员可以修改，所有者和场景的授权需要对应场景的写权限
+func canConsent(who *cache.Principal, kind, key string) bool {
+	if who.CanManage() {
+		return true
+	}
+	return kind != cache.ConsentScope && who.CanScene(key, cache.ActionWrite)
+}
+
+//consent的value为{"kind","key","state","remark"}
+func updateConsent(path string, who *cache.Principal, in *pb.RequestUpdate, out *pb.ReplyInfo) {
+	req := new(consentRequest)
+	err := json.Unmarshal([]byte(in.Value), req)
+	if err != nil {
+		out.Status = outError(path, "the consent value is invalid", ResultStatusInvalid)
+		return
+	}
+	if !canConsent(who, req.Kind, req.Key) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	err = cache.Context().SetConsent(req.Kind, req.Key, req.State, req.Remark, in.Operator)
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return
+	}
+	out.Uid = req.Key
+	out.Status = outLog(path, out)
+}
+
+//consents的value为类型，为空时返回所有类型；只返回有权限修改的授权
+func getConsentStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	list, err := cache.Context().GetConsents(in.Value)
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		return
+	}
+	out.Key = in.Key
+	out.List = make([]*pb.PairInfo, 0, len(list))
+	for _, item := range list {
+		if !canConsent(who, item.Kind, item.Key) {
+			continue
+		}
+		bts, _ := json.Marshal(item)
+		out.List = append(out.List, &pb.PairInfo{Key: item.Kind + ":" + item.Key, Value: string(bts), Index: uint32(len(out.List))})
+	}
+	out.Count = uint32(len(out.List))
+	out.Status = outLog(path, out)
+}
@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+	"strconv"
+	"strings"
+)
+
+//宽高的格式为 宽x高，比如320x240，也可以只表示比例，比如16x9
+func parseCropSize(value string) (int, int, error) {
+	arr := strings.Split(strings.ToLower(value), "x")
+	if len(arr) != 2 {
+		return 0, 0, errors.New("the crop size is invalid")
+	}
+	wid, er1 := strconv.Atoi(strings.TrimSpace(arr[0]))
+	hei, er2 := strconv.Atoi(strings.TrimSpace(arr[1]))
+	if er1 != nil || er2 != nil || wid < 1 || hei < 1 {
+		return 0, 0, errors.New("the crop size is invalid")
+	}
+	return wid, hei, nil
+}
+
+//crop的value为资源uid，values[0]为宽高比（比如16x9），list里的value为裁剪区域的json
+func getCropStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	info := cache.Context().GetAsset(in.Value)
+	if info == nil {
+		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
+		return
+	}
+	if !who.CanAsset(info, cache.ActionRead) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	if len(in.Values) < 1 {
+		out.Status = outError(path, "the crop ratio is empty", ResultStatusInvalid)
+		return
+	}
+	wid, hei, err := parseCropSize(in.Values[0])
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return
+	}
+	crop, err := info.SmartCrop(float64(wid) / float64(hei))
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return
+	}
+	bts, _ := json.Marshal(crop)
+	out.Key = in.Key
+	out.Count = 1
+	out.List = []*pb.PairInfo{{Key: crop.Mode, Value: string(bts), Count: uint32(crop.Faces)}}
+	out.Status = outLog(path, out)
+}
@@ -0,0 +1,29 @@
+package grpc
+
+import "testing"
+
+func TestParseCropSize(t *testing.T) {
+	cases := []struct {
+		value  string
+		width  int
+		height int
+		ok     bool
+	}{
+		{"16x9", 16, 9, true},
+		{"16X9", 16, 9, true},
+		{" 4 x 3 ", 4, 3, true},
+		{"1x1", 1, 1, true},
+		{"16:9", 0, 0, false},
+		{"0x9", 0, 0, false},
+		{"16x-9", 0, 0, false},
+		{"ax9", 0, 0, false},
+		{"1x2x3", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, item := range cases {
+		wid, hei, err := parseCropSize(item.value)
+		if (err == nil) != item.ok || wid != item.width || hei != item.height {
+			t.Errorf("parseCropSize(%q) = %d %d %v, want %d %d", item.value, wid, hei, err, item.width, item.height)
+		}
+	}
+}
@@ -38,12 +38,45 @@ func switchFolder(info *cache.FolderInfo) *pb.FolderInfo {
 func (mine *FolderService) AddOne(ctx context.Context, in *pb.ReqFolderAdd, out *pb.ReplyFolderInfo) error {
 	path := "folder.addOne"
 	inLog(path, in)
+	who := getPrincipal(ctx)
+	if !who.CanScene(in.Owner, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	if len(in.Parent) > 0 {
+		parent, er := cache.Context().GetFolder(in.Parent)
+		if er != nil {
+			out.Status = outError(path, er.Error(), pb.ResultStatus_NotExisted)
+			return nil
+		}
+		if !who.CanFolder(parent, cache.ActionWrite) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+	}
 
+	key, uid, err := beginIdempotent(ctx, path, in)
+	if err != nil {
+		out.Status = outError(path, err.Error(), idempotentStatus(err))
+		return nil
+	}
+	if len(uid) > 0 {
+		info, er := cache.Context().GetFolder(uid)
+		if er != nil {
+			out.Status = outError(path, "the folder of the idempotency key not found", pb.ResultStatus_NotExisted)
+			return nil
+		}
+		out.Info = switchFolder(info)
+		out.Status = outLog(path, out)
+		return nil
+	}
 	info, err := cache.Context().CreateFolder(in)
 	if err != nil {
+		cache.CancelIdempotent(key)
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
 		return nil
 	}
+	cache.FinishIdempotent(key, info.UID)
 	out.Info = switchFolder(info)
 	out.Status = outLog(path, out)
 	return nil
@@ -61,6 +94,10 @@ func (mine *FolderService) GetOne(ctx context.Context, in *pb.RequestInfo, out *
 		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanFolder(folder, cache.ActionRead) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	out.Info = switchFolder(folder)
 	out.Status = outLog(path, out)
 	return nil
@@ -74,7 +111,16 @@ func (mine *FolderService) RemoveOne(ctx context.Context, in *pb.RequestInfo, ou
 		return nil
 	}
 
-	err := cache.Context().RemoveFolder(in.Uid, in.Operator)
+	folder, err := cache.Context().GetFolder(in.Uid)
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
+		return nil
+	}
+	if !getPrincipal(ctx).CanFolder(folder, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	err = cache.Context().RemoveFolder(in.Uid, in.Operator)
 	if err != nil {
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
@@ -96,9 +142,18 @@ func (mine *FolderService) UpdateBase(ctx context.Context, in *pb.ReqFolderUpdat
 		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
 		return nil
 	}
-	err = folder.UpdateBase(in.Name, in.Remark, in.Operator)
+	if !getPrincipal(ctx).CanFolder(folder, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	revision, err := getRevision(ctx)
 	if err != nil {
-		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return nil
+	}
+	err = folder.UpdateBase(in.Name, in.Remark, in.Operator, revision)
+	if err != nil {
+		out.Status = outError(path, err.Error(), writeStatus(err))
 		return nil
 	}
 	out.Status = outLog(path, out)
@@ -112,6 +167,10 @@ func (mine *FolderService) GetStatistic(ctx context.Context, in *pb.RequestFilte
 		out.Status = outError(path, "the uid is empty", pb.ResultStatus_Empty)
 		return nil
 	}
+	if cache.AccessEnable() && getPrincipal(ctx).Anonymous() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 
 	out.Status = outLog(path, out)
 	return nil
@@ -130,9 +189,18 @@ func (mine *FolderService) UpdateContents(ctx context.Context, in *pb.ReqFolderC
 		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
 		return nil
 	}
-	err = folder.UpdateContents(in.Operator, in.Contents)
+	if !getPrincipal(ctx).CanFolder(folder, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	revision, err := getRevision(ctx)
 	if err != nil {
-		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return nil
+	}
+	err = folder.UpdateContents(in.Operator, revision, in.Contents)
+	if err != nil {
+		out.Status = outError(path, err.Error(), writeStatus(err))
 		return nil
 	}
 	out.Info = switchFolder(folder)
@@ -148,15 +216,80 @@ func (mine *FolderService) UpdateByFilter(ctx context.Context, in *pb.RequestUpd
 		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanFolder(folder, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	revision, err := getRevision(ctx)
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return nil
+	}
 	if in.Field == "parent" {
-		err = folder.UpdateParent(in.Operator, in.Value)
+		if len(in.Value) > 0 {
+			parent, er := cache.Context().GetFolder(in.Value)
+			if er != nil {
+				out.Status = outError(path, er.Error(), pb.ResultStatus_NotExisted)
+				return nil
+			}
+			if !getPrincipal(ctx).CanFolder(parent, cache.ActionWrite) {
+				out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+				return nil
+			}
+		}
+		err = folder.UpdateParent(in.Operator, in.Value, revision)
 	} else if in.Field == "cover" {
-		err = folder.UpdateCover(in.Operator, in.Value)
+		err = folder.UpdateCover(in.Operator, in.Value, revision)
+	} else if in.Field == "cover_crop" {
+		asset := cache.Context().GetAsset(in.Value)
+		if asset == nil {
+			out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
+			return nil
+		}
+		if !getPrincipal(ctx).CanAsset(asset, cache.ActionRead) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		if len(in.Values) < 1 {
+			out.Status = outError(path, "the cover size is empty", ResultStatusInvalid)
+			return nil
+		}
+		wid, hei, er := parseCropSize(in.Values[0])
+		if er != nil {
+			out.Status = outError(path, er.Error(), ResultStatusInvalid)
+			return nil
+		}
+		_, err = folder.CreateCover(in.Operator, asset, wid, hei, revision)
 	} else if in.Field == "append" {
-		err = folder.AppendContent(in.Value, "", in.Operator)
+		err = folder.AppendContent(in.Value, "", in.Operator, revision)
+	} else if in.Field == "mask" {
+		values, er := parsePatch(in)
+		if er != nil {
+			out.Status = outError(path, er.Error(), ResultStatusInvalid)
+			return nil
+		}
+		if uid, ok := patchString(in, values, "parent"); ok && len(uid) > 0 {
+			parent, er := cache.Context().GetFolder(uid)
+			if er != nil {
+				out.Status = outError(path, er.Error(), pb.ResultStatus_NotExisted)
+				return nil
+			}
+			if !getPrincipal(ctx).CanFolder(parent, cache.ActionWrite) {
+				out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+				return nil
+			}
+		}
+		err = folder.Patch(in.Operator, revision, in.Values, values)
+		if err != nil {
+			out.Status = outError(path, err.Error(), writeStatus(err))
+			return nil
+		}
+	} else {
+		out.Status = outError(path, "not define the field", ResultStatusInvalid)
+		return nil
 	}
 	if err != nil {
-		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		out.Status = outError(path, err.Error(), writeStatus(err))
 		return nil
 	}
 
@@ -182,8 +315,12 @@ func (mine *FolderService) GetByFilter(ctx context.Context, in *pb.RequestFilter
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
 		return nil
 	}
+	who := getPrincipal(ctx)
 	out.List = make([]*pb.FolderInfo, 0, len(list))
 	for _, info := range list {
+		if !who.CanFolder(info, cache.ActionRead) {
+			continue
+		}
 		tmp := switchFolder(info)
 		out.List = append(out.List, tmp)
 	}
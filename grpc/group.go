@@ -0,0 +1,28 @@
+package grpc
+
+import (
+	"encoding/json"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+)
+
+//groups列出人脸库，list里每一项的key为人脸库，value为统计的JSON，count为人脸数量
+func getGroupStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	if !who.CanManage() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	list, err := cache.Context().GetFaceGroups()
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		return
+	}
+	out.Key = in.Key
+	out.Count = uint32(len(list))
+	out.List = make([]*pb.PairInfo, 0, len(list))
+	for i, item := range list {
+		bts, _ := json.Marshal(item)
+		out.List = append(out.List, &pb.PairInfo{Key: item.Group, Value: string(bts), Count: item.Faces, Index: uint32(i)})
+	}
+	out.Status = outLog(path, out)
+}
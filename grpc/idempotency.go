@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/micro/go-micro/v2/metadata"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+	"strings"
+)
+
+//go-micro的客户端在metadata里携带的调用方服务名
+const headerFromService = "Micro-From-Service"
+
+//metadata里有幂等键时占用它，返回键的id和之前请求创建的uid
+func beginIdempotent(ctx context.Context, path string, in interface{}) (string, string, error) {
+	key, _ := metadata.Get(ctx, cache.HeaderIdempotency)
+	key = strings.TrimSpace(key)
+	if len(key) < 1 {
+		return "", "", nil
+	}
+	bts, _ := json.Marshal(in)
+	sum := sha256.Sum256(bts)
+	return cache.BeginIdempotent(ctx, path, idempotentCaller(ctx, in), key, hex.EncodeToString(sum[:]))
+}
+
+//幂等键的命名空间为认证后的服务和用户；没有身份信息时（比如关闭了认证）使用调用方的服务名和请求里的操作者，
+//避免不同的调用方共用一个命名空间
+func idempotentCaller(ctx context.Context, in interface{}) string {
+	who := getPrincipal(ctx)
+	if len(who.Service) > 0 || len(who.User) > 0 {
+		return who.Service + "/" + who.User
+	}
+	from, _ := metadata.Get(ctx, headerFromService)
+	var operator string
+	if req, ok := in.(interface{ GetOperator() string }); ok {
+		operator = req.GetOperator()
+	}
+	return "anonymous/" + from + "/" + operator
+}
+
+func idempotentStatus(err error) pb.ResultStatus {
+	if errors.Is(err, cache.ErrIdempotencyReused) {
+		return ResultStatusInvalid
+	}
+	if errors.Is(err, cache.ErrIdempotencyPending) {
+		return ResultStatusConflict
+	}
+	return pb.ResultStatus_DBException
+}
@@ -28,22 +28,45 @@ func (mine *LabelService) AddOne(ctx context.Context, in *pb.ReqLabelAdd, out *p
 	path := "label.addOne"
 	inLog(path, in)
 
+	if !getPrincipal(ctx).CanScene(in.Scene, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	in.Name = strings.TrimSpace(in.Name)
+	key, uid, err := beginIdempotent(ctx, path, in)
+	if err != nil {
+		out.Status = outError(path, err.Error(), idempotentStatus(err))
+		return nil
+	}
+	if len(uid) > 0 {
+		info, er := cache.Context().GetLabel(uid)
+		if er != nil {
+			out.Status = outError(path, "the label of the idempotency key not found", pb.ResultStatus_NotExisted)
+			return nil
+		}
+		out.Info = switchLabel(info)
+		out.Status = outLog(path, out)
+		return nil
+	}
 	had, er := cache.Context().HadLabel(in.Name)
 	if er != nil {
+		cache.CancelIdempotent(key)
 		out.Status = outError(path, er.Error(), pb.ResultStatus_DBException)
 		return nil
 	}
 	if had {
+		cache.CancelIdempotent(key)
 		out.Status = outError(path, "the name had existed", pb.ResultStatus_Repeated)
 		return nil
 	}
 
 	info, err := cache.Context().CreateLabel(in)
 	if err != nil {
+		cache.CancelIdempotent(key)
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
 		return nil
 	}
+	cache.FinishIdempotent(key, info.UID)
 	out.Info = switchLabel(info)
 	out.Status = outLog(path, out)
 	return nil
@@ -61,6 +84,10 @@ func (mine *LabelService) GetOne(ctx context.Context, in *pb.RequestInfo, out *p
 		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanLabel(label, cache.ActionRead) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	out.Info = switchLabel(label)
 	out.Status = outLog(path, out)
 	return nil
@@ -74,7 +101,16 @@ func (mine *LabelService) RemoveOne(ctx context.Context, in *pb.RequestInfo, out
 		return nil
 	}
 
-	err := cache.Context().RemoveLabel(in.Uid, in.Operator)
+	label, err := cache.Context().GetLabel(in.Uid)
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
+		return nil
+	}
+	if !getPrincipal(ctx).CanLabel(label, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	err = cache.Context().RemoveLabel(in.Uid, in.Operator)
 	if err != nil {
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
@@ -90,6 +126,10 @@ func (mine *LabelService) GetStatistic(ctx context.Context, in *pb.RequestFilter
 		out.Status = outError(path, "the uid is empty", pb.ResultStatus_Empty)
 		return nil
 	}
+	if cache.AccessEnable() && getPrincipal(ctx).Anonymous() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 
 	out.Status = outLog(path, out)
 	return nil
@@ -103,13 +143,36 @@ func (mine *LabelService) UpdateByFilter(ctx context.Context, in *pb.RequestUpda
 		out.Status = outError(path, err.Error(), pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanLabel(info, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	revision, err := getRevision(ctx)
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return nil
+	}
 	if in.Field == "name" {
-		err = info.UpdateBase(in.Value, info.Remark, in.Operator)
+		err = info.UpdateBase(in.Value, info.Remark, in.Operator, revision)
 	} else if in.Field == "remark" {
-		err = info.UpdateBase(info.Name, in.Value, in.Operator)
+		err = info.UpdateBase(info.Name, in.Value, in.Operator, revision)
+	} else if in.Field == "mask" {
+		values, er := parsePatch(in)
+		if er != nil {
+			out.Status = outError(path, er.Error(), ResultStatusInvalid)
+			return nil
+		}
+		err = info.Patch(in.Operator, revision, in.Values, values)
+		if err != nil {
+			out.Status = outError(path, err.Error(), writeStatus(err))
+			return nil
+		}
+	} else {
+		out.Status = outError(path, "not define the field", ResultStatusInvalid)
+		return nil
 	}
 	if err != nil {
-		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		out.Status = outError(path, err.Error(), writeStatus(err))
 		return nil
 	}
 
@@ -131,8 +194,12 @@ func (mine *LabelService) GetByFilter(ctx context.Context, in *pb.RequestFilter,
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
 		return nil
 	}
+	who := getPrincipal(ctx)
 	out.List = make([]*pb.LabelInfo, 0, len(list))
 	for _, info := range list {
+		if !who.CanLabel(info, cache.ActionRead) {
+			continue
+		}
 		tmp := switchLabel(info)
 		out.List = append(out.List, tmp)
 	}
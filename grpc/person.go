@@ -0,0 +1,96 @@
+package grpc
+
+import (
+	"encoding/json"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+)
+
+//修改一个人需要对他的所有人脸都有写权限
+func canPerson(who *cache.Principal, info *cache.PersonInfo) bool {
+	if who.CanManage() {
+		return true
+	}
+	for _, thumb := range info.GetThumbs() {
+		if !who.CanThumb(thumb, cache.ActionWrite) {
+			return false
+		}
+	}
+	return true
+}
+
+//persons的value为场景，person的value为人的uid；list里每一项的value为人的JSON，count为人脸数量
+func getPersonStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	var list []*cache.PersonInfo
+	if in.Key == "persons" {
+		if !who.CanScene(in.Value, cache.ActionRead) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		var err error
+		list, err = cache.Context().GetPersonsByQuote(in.Value)
+		if err != nil {
+			out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+			return
+		}
+	} else {
+		info, err := cache.Context().GetPerson(in.Value)
+		if err != nil {
+			out.Status = outError(path, "the person not found", pb.ResultStatus_NotExisted)
+			return
+		}
+		if !who.CanScene(info.Quote, cache.ActionRead) && !canPerson(who, info) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		list = []*cache.PersonInfo{info}
+	}
+	out.Count = uint32(len(list))
+	out.List = make([]*pb.PairInfo, 0, len(list))
+	for i, item := range list {
+		bts, _ := json.Marshal(item)
+		out.List = append(out.List, &pb.PairInfo{Key: item.UID, Value: string(bts), Count: item.Count, Index: uint32(i)})
+	}
+	out.Status = outLog(path, out)
+}
+
+//person_rename（value为名字）、person_merge（value为被合并的人）、person_split（values为人脸，value为新的名字）
+func updatePerson(path string, who *cache.Principal, in *pb.RequestUpdate, out *pb.ReplyInfo) {
+	info, err := cache.Context().GetPerson(in.Uid)
+	if err != nil {
+		out.Status = outError(path, "the person not found", pb.ResultStatus_NotExisted)
+		return
+	}
+	if !canPerson(who, info) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	out.Uid = info.UID
+	switch in.Field {
+	case "person_rename":
+		err = info.Rename(in.Value, in.Operator)
+	case "person_merge":
+		from, er := cache.Context().GetPerson(in.Value)
+		if er != nil {
+			out.Status = outError(path, "the merged person not found", pb.ResultStatus_NotExisted)
+			return
+		}
+		if !canPerson(who, from) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		err = info.Merge(from, in.Operator)
+	case "person_split":
+		person, er := info.Split(in.Values, in.Value, in.Operator)
+		if er != nil {
+			out.Status = outError(path, er.Error(), ResultStatusInvalid)
+			return
+		}
+		out.Uid = person.UID
+	}
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		return
+	}
+	out.Status = outLog(path, out)
+}
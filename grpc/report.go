@@ -0,0 +1,109 @@
+package grpc
+
+import (
+	"encoding/json"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+	"omo.msa.asset/proxy/nosql"
+)
+
+//fsck_report查询一致性检查的报告（value为报告的uid，为空时返回最近的报告）
+func getFsckStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	if !who.CanManage() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	getReportStatistic(path, cache.ReportFsck, in, out)
+}
+
+//UpdateByFilter的fsck开始一致性检查（value为repair时修复），返回的uid为报告的uid
+func startFsck(path string, who *cache.Principal, in *pb.RequestUpdate, out *pb.ReplyInfo) {
+	if !who.CanManage() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	uid, err := cache.Context().StartFsck(in.Operator, in.Value == "repair")
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		return
+	}
+	out.Uid = uid
+	out.Status = outLog(path, out)
+}
+
+//reconcile_report查询人脸库对账的报告（value为报告的uid，为空时返回最近的报告）
+func getReconcileStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	if !who.CanManage() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	getReportStatistic(path, cache.ReportReconcile, in, out)
+}
+
+//UpdateByFilter的reconcile开始人脸库对账（value为repair时修复），返回的uid为报告的uid
+func startReconcile(path string, who *cache.Principal, in *pb.RequestUpdate, out *pb.ReplyInfo) {
+	if !who.CanManage() {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	uid, err := cache.Context().StartReconcile(in.Operator, in.Value == "repair")
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		return
+	}
+	out.Uid = uid
+	out.Status = outLog(path, out)
+}
+
+func getReportStatistic(path, kind string, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	if len(in.Value) < 1 {
+		list, err := cache.Context().GetReports(kind, int64(in.Number))
+		if err != nil {
+			out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+			return
+		}
+		out.Count = uint32(len(list))
+		out.List = make([]*pb.PairInfo, 0, len(list))
+		for i, item := range list {
+			bts, _ := json.Marshal(item)
+			out.List = append(out.List, &pb.PairInfo{Key: item.UID.Hex(), Value: string(bts), Index: uint32(i)})
+		}
+		out.Status = outLog(path, out)
+		return
+	}
+	report, err := cache.Context().GetReport(in.Value)
+	if err != nil || report.Kind != kind {
+		out.Status = outError(path, "the report not found", pb.ResultStatus_NotExisted)
+		return
+	}
+	writeReport(report, out)
+	out.Status = outLog(path, out)
+}
+
+//key为状态，count为问题的总数，list先是每种问题的数量，之后是具体的问题
+func writeReport(report *nosql.Report, out *pb.ReplyStatistic) {
+	out.Owner = report.UID.Hex()
+	out.Key = reportStatus(report.Status)
+	out.List = make([]*pb.PairInfo, 0, len(report.Counts)+len(report.Issues))
+	for kind, num := range report.Counts {
+		if kind != "repaired" {
+			out.Count += num
+		}
+		out.List = append(out.List, &pb.PairInfo{Key: kind, Count: num})
+	}
+	for i, issue := range report.Issues {
+		bts, _ := json.Marshal(issue)
+		out.List = append(out.List, &pb.PairInfo{Key: issue.Kind, Value: string(bts), Index: uint32(i + 1)})
+	}
+}
+
+func reportStatus(st uint8) string {
+	switch st {
+	case nosql.ReportFinished:
+		return "finished"
+	case nosql.ReportFailed:
+		return "failed"
+	default:
+		return "running"
+	}
+}
@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"encoding/json"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+	"omo.msa.asset/proxy/nosql"
+)
+
+//reviews的value为场景（为空时需要管理员权限），review的value为uid；list里每一项的value为待确认匹配的JSON
+func getReviewStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	var list []*cache.ReviewInfo
+	if in.Key == "reviews" {
+		if (len(in.Value) < 1 && !who.CanManage()) || !who.CanScene(in.Value, cache.ActionRead) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		total, arr, err := cache.Context().GetReviews(in.Value, nosql.ReviewPending, in.Page, in.Number)
+		if err != nil {
+			out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+			return
+		}
+		list = arr
+		out.Count = total
+	} else {
+		info, err := cache.Context().GetReview(in.Value)
+		if err != nil {
+			out.Status = outError(path, "the review not found", pb.ResultStatus_NotExisted)
+			return
+		}
+		if !who.CanScene(info.Quote, cache.ActionRead) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		list = []*cache.ReviewInfo{info}
+		out.Count = 1
+	}
+	out.Key = in.Key
+	out.List = make([]*pb.PairInfo, 0, len(list))
+	for i, item := range list {
+		bts, _ := json.Marshal(item)
+		out.List = append(out.List, &pb.PairInfo{Key: item.UID, Value: string(bts), Count: uint32(len(item.Candidates)), Index: uint32(i)})
+	}
+	out.Status = outLog(path, out)
+}
+
+//review_confirm（value为候选用户）、review_reject、review_reassign（value为用户），需要对人脸和目标用户的人脸有写权限
+func updateReview(path string, who *cache.Principal, in *pb.RequestUpdate, out *pb.ReplyInfo) {
+	info, err := cache.Context().GetReview(in.Uid)
+	if err != nil {
+		out.Status = outError(path, "the review not found", pb.ResultStatus_NotExisted)
+		return
+	}
+	thumb := info.GetThumb()
+	if thumb == nil {
+		out.Status = outError(path, "the thumb not found", pb.ResultStatus_NotExisted)
+		return
+	}
+	if !who.CanThumb(thumb, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	if info.Status != nosql.ReviewPending {
+		out.Status = outError(path, "the review had decided", ResultStatusConflict)
+		return
+	}
+	if in.Field != "review_reject" && !who.CanFaceUser(in.Value) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	switch in.Field {
+	case "review_confirm":
+		err = info.Confirm(in.Value, in.Operator)
+	case "review_reject":
+		err = info.Reject(in.Operator)
+	case "review_reassign":
+		err = info.Reassign(in.Value, in.Operator)
+	}
+	if err != nil {
+		out.Status = outError(path, err.Error(), ResultStatusInvalid)
+		return
+	}
+	out.Uid = info.UID
+	out.Status = outLog(path, out)
+}
@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+)
+
+//search的value为查询参数，返回的人脸的similar为相似度
+func searchFaces(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyThumbList) {
+	query := new(cache.FaceQuery)
+	err := json.Unmarshal([]byte(in.Value), query)
+	searchLog(path, in, query)
+	if err != nil {
+		out.Status = outError(path, "the search value is invalid", ResultStatusInvalid)
+		return
+	}
+	for _, group := range query.SearchGroups() {
+		if !who.CanFaceGroup(group) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+	}
+	if len(query.Image) < 1 && len(query.Asset) < 1 {
+		out.Status = outError(path, "the probe image is empty", ResultStatusInvalid)
+		return
+	}
+	if len(query.Image) < 1 {
+		asset := cache.Context().GetAsset(query.Asset)
+		if asset == nil {
+			out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
+			return
+		}
+		if !who.CanAsset(asset, cache.ActionRead) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+	}
+	list, err := cache.Context().SearchFaces(query, who)
+	if err != nil {
+		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+		return
+	}
+	out.Owner = in.Owner
+	out.List = make([]*pb.ThumbInfo, 0, len(list))
+	for _, item := range list {
+		tmp := switchThumb(item.Thumb)
+		tmp.Similar = item.Score
+		out.List = append(out.List, tmp)
+	}
+	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
+}
+
+//请求日志里不记录人脸图片，只记录图片的长度
+func searchLog(path string, in *pb.RequestFilter, query *cache.FaceQuery) {
+	tmp := *query
+	if len(tmp.Image) > 0 {
+		tmp.Image = fmt.Sprintf("<base64 %d bytes>", len(tmp.Image))
+	}
+	value, _ := json.Marshal(tmp)
+	inLog(path, &pb.RequestFilter{Owner: in.Owner, Key: in.Key, Uid: in.Uid, Operator: in.Operator, Value: string(value)})
+}
@@ -42,15 +42,36 @@ func (mine *ThumbService) AddOne(ctx context.Context, in *pb.ReqThumbAdd, out *p
 		out.Status = outError(path, "not found the asset", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(asset, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	//if asset.HadThumbByFace(in.Face) {
 	//	out.Status = outError(path, "the face repeated", pb.ResultStatus_Repeated)
 	//	return nil
 	//}
+	key, uid, err := beginIdempotent(ctx, path, in)
+	if err != nil {
+		out.Status = outError(path, err.Error(), idempotentStatus(err))
+		return nil
+	}
+	if len(uid) > 0 {
+		info := cache.Context().GetThumb(uid)
+		if info == nil {
+			out.Status = outError(path, "the thumb of the idempotency key not found", pb.ResultStatus_NotExisted)
+			return nil
+		}
+		out.Info = switchThumb(info)
+		out.Status = outLog(path, out)
+		return nil
+	}
 	info, err := asset.CreateThumb(in.Url, in.Operator, in.Owner, in.Probably, in.Similar, in.Blur)
 	if err != nil {
+		cache.CancelIdempotent(key)
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
 		return nil
 	}
+	cache.FinishIdempotent(key, info.UID)
 	out.Info = switchThumb(info)
 	out.Status = outLog(path, out)
 	return nil
@@ -78,6 +99,10 @@ func (mine *ThumbService) GetOne(ctx context.Context, in *pb.RequestInfo, out *p
 		out.Status = outError(path, "the thumb not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanThumb(thumb, cache.ActionRead) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	out.Info = switchThumb(thumb)
 	out.Status = outLog(path, out.Info.Uid)
 	return nil
@@ -99,6 +124,10 @@ func (mine *ThumbService) RemoveOne(ctx context.Context, in *pb.RequestInfo, out
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(asset, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	err := asset.RemoveThumb(in.Uid, in.Operator)
 	if err != nil {
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
@@ -120,6 +149,10 @@ func (mine *ThumbService) GetList(ctx context.Context, in *pb.ReqThumbList, out
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
+	if !getPrincipal(ctx).CanAsset(asset, cache.ActionRead) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
 	array, err := asset.GetThumbs()
 	if err != nil {
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
@@ -141,10 +174,13 @@ func (mine *ThumbService) GetByOwner(ctx context.Context, in *pb.RequestInfo, ou
 		return nil
 	}
 	list := cache.Context().GetThumbsByOwner(in.Owner)
+	who := getPrincipal(ctx)
 	out.Owner = in.Owner
 	out.List = make([]*pb.ThumbInfo, 0, len(list))
 	for _, val := range list {
-		out.List = append(out.List, switchThumb(val))
+		if who.CanThumb(val, cache.ActionRead) {
+			out.List = append(out.List, switchThumb(val))
+		}
 	}
 	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
 	return nil
@@ -173,7 +209,11 @@ func (mine *ThumbService) UpdateBase(ctx context.Context, in *pb.ReqThumbUpdate,
 		out.Status = outError(path, "the thumb not found", pb.ResultStatus_NotExisted)
 		return nil
 	}
-	err := thumb.UpdateBase(in.Owner, in.Similar)
+	if !getPrincipal(ctx).CanThumb(thumb, cache.ActionWrite) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return nil
+	}
+	err := thumb.UpdateBase(in.Owner, in.Operator, in.Similar)
 	if err != nil {
 		out.Status = outError(path, "the asset not found", pb.ResultStatus_NotExisted)
 		return nil
@@ -192,11 +232,76 @@ func (mine *ThumbService) UpdateByFilter(ctx context.Context, in *pb.RequestUpda
 	}
 
 	var err error
+	who := getPrincipal(ctx)
 	if in.Field == "meta" {
 		thumb := cache.Context().GetThumb(in.Uid)
+		if thumb == nil {
+			out.Status = outError(path, "the thumb not found", pb.ResultStatus_NotExisted)
+			return nil
+		}
+		if !who.CanThumb(thumb, cache.ActionWrite) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
 		err = thumb.UpdateInfo(in.Value, in.Operator)
 	} else if in.Field == "bind" {
+		if !who.CanFaceUser(in.Uid) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
 		err = cache.Context().BindFaceEntity(in.Uid, in.Value, in.Operator)
+	} else if in.Field == "person_rename" || in.Field == "person_merge" || in.Field == "person_split" {
+		updatePerson(path, who, in, out)
+		return nil
+	} else if in.Field == "erase" {
+		//删除人脸用户或者实体的生物特征数据，uid为用户或者实体
+		if !who.CanManage() {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		_, err = cache.Context().EraseFaceUser(in.Uid, in.Operator)
+	} else if in.Field == "group_remove" {
+		//删除机构的人脸库，uid为人脸库
+		if !who.CanManage() {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		err = cache.Context().RemoveFaceGroup(in.Uid, in.Operator)
+	} else if in.Field == "review_confirm" || in.Field == "review_reject" || in.Field == "review_reassign" {
+		updateReview(path, who, in, out)
+		return nil
+	} else if in.Field == "mask" {
+		thumb := cache.Context().GetThumb(in.Uid)
+		if thumb == nil {
+			out.Status = outError(path, "the thumb not found", pb.ResultStatus_NotExisted)
+			return nil
+		}
+		if !who.CanThumb(thumb, cache.ActionWrite) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		values, er := parsePatch(in)
+		if er != nil {
+			out.Status = outError(path, er.Error(), ResultStatusInvalid)
+			return nil
+		}
+		revision, er := getRevision(ctx)
+		if er != nil {
+			out.Status = outError(path, er.Error(), ResultStatusInvalid)
+			return nil
+		}
+		if owner, ok := patchString(in, values, "owner"); ok && !who.CanScene(owner, cache.ActionWrite) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return nil
+		}
+		err = thumb.Patch(in.Operator, revision, in.Values, values)
+		if err != nil {
+			out.Status = outError(path, err.Error(), writeStatus(err))
+			return nil
+		}
+	} else {
+		out.Status = outError(path, "not define the field", ResultStatusInvalid)
+		return nil
 	}
 	if err != nil {
 		out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
@@ -208,16 +313,28 @@ func (mine *ThumbService) UpdateByFilter(ctx context.Context, in *pb.RequestUpda
 
 func (mine *ThumbService) GetByFilter(ctx context.Context, in *pb.RequestFilter, out *pb.ReplyThumbList) error {
 	path := "thumb.getByFilter"
+	if in.Key == "search" {
+		//以图搜人的value里有人脸图片，在searchFaces里记录脱敏后的请求
+		searchFaces(path, getPrincipal(ctx), in, out)
+		return nil
+	}
 	inLog(path, in)
+	if in.Key == "attribute" {
+		getThumbsByAttribute(path, getPrincipal(ctx), in, out)
+		return nil
+	}
 	var list []*cache.ThumbInfo
 	if in.Key == "quote_users" {
 		list = cache.Context().GetUserThumbsByQuote(in.Value, in.Values)
 	} else if in.Key == "quote" {
 		list = cache.Context().GetThumbsByQuote(in.Value)
 	}
+	who := getPrincipal(ctx)
 	out.List = make([]*pb.ThumbInfo, 0, len(list))
 	for _, item := range list {
-		out.List = append(out.List, switchThumb(item))
+		if who.CanThumb(item, cache.ActionRead) {
+			out.List = append(out.List, switchThumb(item))
+		}
 	}
 	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
 	return nil
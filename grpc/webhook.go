@@ -0,0 +1,117 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/xtech-cloud/omo-msp-asset/proto/asset"
+	"omo.msa.asset/cache"
+)
+
+//webhook_add的value
+type webhookRequest struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Owner  string   `json:"owner"`
+	Quote  string   `json:"quote"`
+	Types  []uint32 `json:"types"`
+}
+
+//属于某个场景的webhook由场景管理，否则只有管理员可以管理
+func canWebhook(who *cache.Principal, owner string) bool {
+	if len(owner) > 0 {
+		return who.CanScene(owner, cache.ActionWrite)
+	}
+	return who.CanManage()
+}
+
+//webhook_add、webhook_remove、webhook_ping
+func updateWebhook(path string, who *cache.Principal, in *pb.RequestUpdate, out *pb.ReplyInfo) {
+	if in.Field == "webhook_add" {
+		req := new(webhookRequest)
+		err := json.Unmarshal([]byte(in.Value), req)
+		if err != nil {
+			out.Status = outError(path, "the webhook value is invalid", ResultStatusInvalid)
+			return
+		}
+		if !canWebhook(who, req.Owner) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		info, err := cache.Context().CreateWebhook(req.Name, req.URL, req.Secret, req.Owner, req.Quote, in.Operator, req.Events, req.Types)
+		if err != nil {
+			out.Status = outError(path, err.Error(), ResultStatusInvalid)
+			return
+		}
+		out.Uid = info.UID
+		out.Status = outLog(path, out)
+		return
+	}
+	info := cache.Context().GetWebhook(in.Value)
+	if info == nil {
+		out.Status = outError(path, "the webhook not found", pb.ResultStatus_NotExisted)
+		return
+	}
+	if !canWebhook(who, info.Owner) {
+		out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+		return
+	}
+	if in.Field == "webhook_remove" {
+		err := cache.Context().RemoveWebhook(info.UID, in.Operator)
+		if err != nil {
+			out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+			return
+		}
+		out.Uid = info.UID
+	} else if in.Field == "webhook_ping" {
+		delivery, err := info.Ping(in.Operator)
+		if delivery != nil {
+			out.Uid = delivery.UID
+		}
+		if err != nil {
+			out.Status = outError(path, err.Error(), pb.ResultStatus_DBException)
+			return
+		}
+	} else {
+		out.Status = outError(path, "not define the field", ResultStatusInvalid)
+		return
+	}
+	out.Status = outLog(path, out)
+}
+
+//webhooks（value为场景）和webhook_deliveries（value为webhook）
+func getWebhookStatistic(path string, who *cache.Principal, in *pb.RequestFilter, out *pb.ReplyStatistic) {
+	if in.Key == "webhooks" {
+		if !canWebhook(who, in.Value) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		list := cache.Context().GetWebhooks(in.Value)
+		out.Count = uint32(len(list))
+		out.List = make([]*pb.PairInfo, 0, len(list))
+		for _, item := range list {
+			bts, _ := json.Marshal(item)
+			out.List = append(out.List, &pb.PairInfo{Key: item.UID, Value: string(bts)})
+		}
+	} else {
+		info := cache.Context().GetWebhook(in.Value)
+		if info == nil {
+			out.Status = outError(path, "the webhook not found", pb.ResultStatus_NotExisted)
+			return
+		}
+		if !canWebhook(who, info.Owner) {
+			out.Status = outError(path, msgForbidden, ResultStatusForbidden)
+			return
+		}
+		total, _, list := cache.Context().GetDeliveries(info.UID, in.Page, in.Number)
+		out.Count = total
+		out.List = make([]*pb.PairInfo, 0, len(list))
+		for _, item := range list {
+			bts, _ := json.Marshal(item)
+			out.List = append(out.List, &pb.PairInfo{Key: item.UID, Value: string(bts), Count: item.Attempts})
+		}
+	}
+	out.Key = in.Key
+	out.Status = outLog(path, fmt.Sprintf("the length = %d", len(out.List)))
+}
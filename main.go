@@ -12,6 +12,7 @@ import (
 	"omo.msa.asset/cache"
 	"omo.msa.asset/config"
 	"omo.msa.asset/grpc"
+	"omo.msa.asset/web"
 	"os"
 	"path/filepath"
 	"time"
@@ -25,7 +26,11 @@ var (
 
 func main() {
 	config.Setup()
-	err := cache.InitData()
+	err := cache.CheckAccess()
+	if err != nil {
+		panic(err)
+	}
+	err = cache.InitData()
 	if err != nil {
 		panic(err)
 	}
@@ -36,6 +41,7 @@ func main() {
 		micro.RegisterTTL(time.Second*time.Duration(config.Schema.Service.TTL)),
 		micro.RegisterInterval(time.Second*time.Duration(config.Schema.Service.Interval)),
 		micro.Address(config.Schema.Service.Address),
+		micro.WrapHandler(grpc.AuthWrapper),
 	)
 	// Initialise service
 	service.Init()
@@ -45,6 +51,14 @@ func main() {
 	_ = proto.RegisterFolderServiceHandler(service.Server(), new(grpc.FolderService))
 	_ = proto.RegisterLabelServiceHandler(service.Server(), new(grpc.LabelService))
 
+	web.Start()
+	cache.StartEvents(service.Options().Broker)
+	cache.StartWebhooks()
+	cache.StartBatches()
+	cache.StartMigrations()
+	cache.StartFsck()
+	cache.StartReconcile()
+
 	app, _ := filepath.Abs(os.Args[0])
 
 	logger.Info("-------------------------------------------------------------")
@@ -13,3 +13,17 @@ type PairInfo struct {
 	Value string `json:"value" bson:"value"`
 	Count uint32 `json:"count" bson:"count"`
 }
+
+//人脸属性，Kind为空表示检测时没有保存属性
+type FaceAttribute struct {
+	Age     uint32  `json:"age" bson:"age"`
+	Gender  string  `json:"gender" bson:"gender"`
+	Glasses string  `json:"glasses" bson:"glasses"`
+	Emotion string  `json:"emotion" bson:"emotion"`
+	Shape   string  `json:"shape" bson:"shape"`
+	Kind    string  `json:"kind" bson:"kind"`
+	Mask    bool    `json:"mask" bson:"mask"`
+	Yaw     float32 `json:"yaw" bson:"yaw"`
+	Pitch   float32 `json:"pitch" bson:"pitch"`
+	Roll    float32 `json:"roll" bson:"roll"`
+}
@@ -21,11 +21,13 @@ type Asset struct {
 	Deleted     int64              `json:"deleted" bson:"deleted"`
 	Creator     string             `json:"creator" bson:"creator"`
 	Operator    string             `json:"operator" bson:"operator"`
+	Revision    uint64             `json:"revision" bson:"revision"`
 
 	Status   uint8    `json:"status" bson:"status"`
 	Type     uint8    `json:"type" bson:"type"`
 	Scope    uint8    `json:"scope" bson:"scope"`
 	Code     int      `json:"code" bson:"code"`
+	Mime     string   `json:"mime" bson:"mime"`
 	Owner    string   `json:"owner" bson:"owner"`
 	Size     uint64   `json:"size" bson:"size"`
 	UUID     string   `json:"uuid" bson:"uuid"`
@@ -43,10 +45,16 @@ type Asset struct {
 	Quote    string   `json:"quote" bson:"quote"`
 	Links    []string `json:"links" bson:"links"`
 	Tags     []string `json:"tags" bson:"tags"`
+
+	//病毒扫描的结果，被隔离的资源不出现在列表中
+	Scan       uint8  `json:"scan" bson:"scan"`
+	Virus      string `json:"virus" bson:"virus"`
+	Scanned    int64  `json:"scanned" bson:"scanned"`
+	Quarantine bool   `json:"quarantine" bson:"quarantine"`
 }
 
-func CreateAsset(info *Asset) error {
-	_, err := insertOne(TableAssets, &info)
+func CreateAsset(ctx context.Context, info *Asset) error {
+	_, err := insertOneIn(ctx, TableAssets, &info)
 	return err
 }
 
@@ -66,11 +74,16 @@ func GetAssetCount() int64 {
 }
 
 func GetAsset(uid string) (*Asset, error) {
+	return GetAssetIn(context.Background(), uid)
+}
+
+//在事务里读取，可以读到事务里还没有提交的修改
+func GetAssetIn(ctx context.Context, uid string) (*Asset, error) {
 	if len(uid) < 2 {
 		return nil, errors.New("db Asset uid is empty of GetAsset")
 	}
 
-	result, err := findOne(TableAssets, uid)
+	result, err := findOneIn(ctx, TableAssets, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -116,149 +129,170 @@ func GetAllAssets() ([]*Asset, error) {
 	return items, nil
 }
 
-func UpdateAssetSnapshot(uid, snapshot, operator string) error {
+func UpdateAssetSnapshot(ctx context.Context, uid, snapshot, operator string) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetSnapshot")
 	}
 
 	msg := bson.M{"snapshot": snapshot, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
 	return err
 }
 
-func UpdateAssetSmall(uid, small, operator string) error {
+func UpdateAssetSmall(ctx context.Context, uid, small, operator string) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetSmall")
 	}
 
 	msg := bson.M{"small": small, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
 	return err
 }
 
-func UpdateAssetCode(uid string, code int) error {
+func UpdateAssetCode(ctx context.Context, uid string, code int) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetCode")
 	}
 
 	msg := bson.M{"code": code, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
 	return err
 }
 
-func UpdateAssetBase(uid, name, remark, operator string) error {
+func UpdateAssetMime(ctx context.Context, uid, mime string) error {
+	if len(uid) < 2 {
+		return errors.New("db asset uid is empty of UpdateAssetMime")
+	}
+
+	msg := bson.M{"mime": mime, TimeUpdated: time.Now().Unix()}
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
+	return err
+}
+
+func UpdateAssetScan(ctx context.Context, uid, virus string, scan uint8, quarantine bool) error {
+	if len(uid) < 2 {
+		return errors.New("db asset uid is empty of UpdateAssetScan")
+	}
+
+	msg := bson.M{"scan": scan, "virus": virus, "scanned": time.Now().Unix(), FieldQuarantine: quarantine, TimeUpdated: time.Now().Unix()}
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
+	return err
+}
+
+//revision为预期的修订号，小于0时不检查
+func UpdateAssetBase(ctx context.Context, uid, name, remark, operator string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetBase")
 	}
 
 	msg := bson.M{"name": name, "remark": remark, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
 	return err
 }
 
-func UpdateAssetMeta(uid, meta, operator string) error {
+func UpdateAssetMeta(ctx context.Context, uid, meta, operator string) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetMeta")
 	}
 
 	msg := bson.M{"meta": meta, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
 	return err
 }
 
-func UpdateAssetWeight(uid, operator string, weight uint32) error {
+func UpdateAssetWeight(ctx context.Context, uid, operator string, weight uint32) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetWeight")
 	}
 
 	msg := bson.M{"weight": weight, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
 	return err
 }
 
-func UpdateAssetStatus(uid, operator string, status uint8) error {
+func UpdateAssetStatus(ctx context.Context, uid, operator string, status uint8, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetWeight")
 	}
 
 	msg := bson.M{"status": status, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
 	return err
 }
 
-func UpdateAssetScope(uid, operator string, scope uint8) error {
+func UpdateAssetScope(ctx context.Context, uid, operator string, scope uint8) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetWeight")
 	}
 
 	msg := bson.M{"scope": scope, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
 	return err
 }
 
-func UpdateAssetLinks(uid, operator string, arr []string) error {
+func UpdateAssetLinks(ctx context.Context, uid, operator string, arr []string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetWeight")
 	}
 
 	msg := bson.M{"links": arr, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
 	return err
 }
 
-func UpdateAssetTags(uid, operator string, arr []string) error {
+func UpdateAssetTags(ctx context.Context, uid, operator string, arr []string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetWeight")
 	}
 
 	msg := bson.M{"tags": arr, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
 	return err
 }
 
-func UpdateAssetType(uid, operator string, tp uint8) error {
+func UpdateAssetType(ctx context.Context, uid, operator string, tp uint8, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetType")
 	}
 
 	msg := bson.M{"type": tp, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
 	return err
 }
 
-func UpdateAssetOwner(uid, owner, operator string) error {
+func UpdateAssetOwner(ctx context.Context, uid, owner, operator string) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetType")
 	}
 
 	msg := bson.M{"owner": owner, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneIn(ctx, TableAssets, uid, msg)
 	return err
 }
 
-func UpdateAssetQuote(uid, quote, operator string) error {
+func UpdateAssetQuote(ctx context.Context, uid, quote, operator string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetType")
 	}
 
 	msg := bson.M{"quote": quote, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
 	return err
 }
 
-func UpdateAssetLanguage(uid, operator, lan string) error {
+func UpdateAssetLanguage(ctx context.Context, uid, operator, lan string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db asset uid is empty of UpdateAssetLanguage")
 	}
 
 	msg := bson.M{"language": lan, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableAssets, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
 	return err
 }
 
 func GetAssetsByOwner(owner string) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"owner": owner, TimeDeleted: 0}
+	filter := bson.M{"owner": owner, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -276,7 +310,7 @@ func GetAssetsByOwner(owner string) ([]*Asset, error) {
 
 func GetAssetsByOwnerStatus(owner string, st uint8) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"owner": owner, "status": st, TimeDeleted: 0}
+	filter := bson.M{"owner": owner, "status": st, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -294,7 +328,7 @@ func GetAssetsByOwnerStatus(owner string, st uint8) ([]*Asset, error) {
 
 func GetAssetsByStatus(st uint8) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"status": st, TimeDeleted: 0}
+	filter := bson.M{"status": st, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -312,7 +346,7 @@ func GetAssetsByStatus(st uint8) ([]*Asset, error) {
 
 func GetAssetsByQuoteStatus(quote string, st uint8, start, num int64) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"quote": quote, "status": st, TimeDeleted: 0}
+	filter := bson.M{"quote": quote, "status": st, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	opts := options.Find().SetSort(bson.D{{TimeCreated, -1}}).SetLimit(num).SetSkip(start)
 	cursor, err1 := findManyByOpts(TableAssets, filter, opts)
 	if err1 != nil {
@@ -331,7 +365,7 @@ func GetAssetsByQuoteStatus(quote string, st uint8, start, num int64) ([]*Asset,
 
 func GetAssetsByQuote(quote string, start, num int64) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"quote": quote, TimeDeleted: 0}
+	filter := bson.M{"quote": quote, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	opts := options.Find().SetSort(bson.D{{TimeCreated, -1}}).SetLimit(num).SetSkip(start)
 	cursor, err1 := findManyByOpts(TableAssets, filter, opts)
 	if err1 != nil {
@@ -349,38 +383,38 @@ func GetAssetsByQuote(quote string, start, num int64) ([]*Asset, error) {
 }
 
 func GetAssetsCountByQuote(quote string) int64 {
-	filter := bson.M{"quote": quote, TimeDeleted: 0}
+	filter := bson.M{"quote": quote, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	num, _ := getCountByFilter(TableAssets, filter)
 	return num
 }
 
 func GetAssetsCountByQuoteCreator(quote, creator string) int64 {
-	filter := bson.M{"quote": quote, "creator": creator, TimeDeleted: 0}
+	filter := bson.M{"quote": quote, "creator": creator, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	num, _ := getCountByFilter(TableAssets, filter)
 	return num
 }
 
 func GetAssetsCountByOwnerCreator(owner, creator string) int64 {
-	filter := bson.M{"owner": owner, "creator": creator, TimeDeleted: 0}
+	filter := bson.M{"owner": owner, "creator": creator, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	num, _ := getCountByFilter(TableAssets, filter)
 	return num
 }
 
 func GetAssetsCountByOwnerQuote(owner, quote string) int64 {
-	filter := bson.M{"quote": quote, "owner": owner, TimeDeleted: 0}
+	filter := bson.M{"quote": quote, "owner": owner, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	num, _ := getCountByFilter(TableAssets, filter)
 	return num
 }
 
 func GetAssetsCountByQuoteStatus(quote string, st uint32) int64 {
-	filter := bson.M{"quote": quote, "status": st, TimeDeleted: 0}
+	filter := bson.M{"quote": quote, "status": st, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	num, _ := getCountByFilter(TableAssets, filter)
 	return num
 }
 
 func GetAssetsByOwnerQuote(owner, quote string, start, num int64) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"owner": owner, "quote": quote, TimeDeleted: 0}
+	filter := bson.M{"owner": owner, "quote": quote, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	opts := options.Find().SetSort(bson.D{{TimeCreated, -1}}).SetLimit(num).SetSkip(start)
 	cursor, err1 := findManyByOpts(TableAssets, filter, opts)
 	if err1 != nil {
@@ -399,7 +433,7 @@ func GetAssetsByOwnerQuote(owner, quote string, start, num int64) ([]*Asset, err
 
 func GetAssetsByRegex(key string, from, to int64) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"name": bson.M{"$regex": key}, TimeCreated: bson.M{"$gt": from, "$lt": to}, TimeDeleted: 0}
+	filter := bson.M{"name": bson.M{"$regex": key}, TimeCreated: bson.M{"$gt": from, "$lt": to}, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -417,7 +451,7 @@ func GetAssetsByRegex(key string, from, to int64) ([]*Asset, error) {
 
 func GetAssetsByType(tp uint8) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"type": tp, TimeDeleted: 0}
+	filter := bson.M{"type": tp, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -435,7 +469,7 @@ func GetAssetsByType(tp uint8) ([]*Asset, error) {
 
 func GetAssetsByOwnerType(owner string, tp uint8) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"owner": owner, "type": tp, TimeDeleted: 0}
+	filter := bson.M{"owner": owner, "type": tp, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -453,7 +487,7 @@ func GetAssetsByOwnerType(owner string, tp uint8) ([]*Asset, error) {
 
 func GetAssetsByCreator(user string) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"creator": user, TimeDeleted: 0}
+	filter := bson.M{"creator": user, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -471,7 +505,7 @@ func GetAssetsByCreator(user string) ([]*Asset, error) {
 
 func GetAssetsByQuoteCreator(quote, user string, start, num int64) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"quote": quote, "creator": user, TimeDeleted: 0}
+	filter := bson.M{"quote": quote, "creator": user, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	opts := options.Find().SetSort(bson.D{{TimeCreated, -1}}).SetLimit(num).SetSkip(start)
 	cursor, err1 := findManyByOpts(TableAssets, filter, opts)
 	if err1 != nil {
@@ -490,7 +524,7 @@ func GetAssetsByQuoteCreator(quote, user string, start, num int64) ([]*Asset, er
 
 func GetAssetsByLink(link string) ([]*Asset, error) {
 	var items = make([]*Asset, 0, 20)
-	filter := bson.M{"links": link, TimeDeleted: 0}
+	filter := bson.M{"links": link, TimeDeleted: 0, FieldQuarantine: bson.M{"$ne": true}}
 	cursor, err1 := findMany(TableAssets, filter, 0)
 	if err1 != nil {
 		return nil, err1
@@ -505,3 +539,53 @@ func GetAssetsByLink(link string) ([]*Asset, error) {
 	}
 	return items, nil
 }
+
+func GetAssetsByQuarantine() ([]*Asset, error) {
+	var items = make([]*Asset, 0, 20)
+	filter := bson.M{FieldQuarantine: true, TimeDeleted: 0}
+	cursor, err1 := findMany(TableAssets, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Asset)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//一次写入多个字段，revision为预期的修订号
+func UpdateAssetFields(ctx context.Context, uid, operator string, revision int64, fields bson.M) error {
+	if len(uid) < 2 {
+		return errors.New("db asset uid is empty of UpdateAssetFields")
+	}
+	msg := bson.M{"operator": operator, TimeUpdated: time.Now().Unix()}
+	for key, val := range fields {
+		msg[key] = val
+	}
+	_, err := updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
+	return err
+}
+
+//存储里的文件是否还被资源、回收站、文件夹封面或者人脸使用，包括已经删除的记录
+func IsStorageReferenced(key string) bool {
+	files := bson.M{"$or": bson.A{bson.M{"uuid": key}, bson.M{"snapshot": key}, bson.M{"small": key}}}
+	filters := map[string]bson.M{
+		TableAssets:   files,
+		TableRecycles: files,
+		TableFolders:  {"cover": key},
+		TableThumbs:   {"file": key},
+	}
+	for table, filter := range filters {
+		num, err := getCountByFilter(table, filter)
+		//查询失败时当作还在使用，不删除
+		if err != nil || num > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package nosql
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//审计记录只允许写入和查询，不提供修改和删除；删除生物特征数据时例外，只脱敏不删除
+type Audit struct {
+	UID     primitive.ObjectID     `bson:"_id"`
+	Created int64                  `json:"created" bson:"created"`
+	Actor   string                 `json:"actor" bson:"actor"`
+	Action  string                 `json:"action" bson:"action"`
+	Kind    string                 `json:"kind" bson:"kind"`
+	Target  string                 `json:"target" bson:"target"`
+	Before  map[string]interface{} `json:"before" bson:"before"`
+	After   map[string]interface{} `json:"after" bson:"after"`
+}
+
+func CreateAudit(ctx context.Context, info *Audit) error {
+	_, err := insertOneIn(ctx, TableAudits, info)
+	return err
+}
+
+func auditFilter(target, actor string, from, to int64) bson.M {
+	filter := bson.M{}
+	if len(target) > 0 {
+		filter["target"] = target
+	}
+	if len(actor) > 0 {
+		filter["actor"] = actor
+	}
+	if from > 0 || to > 0 {
+		tm := bson.M{}
+		if from > 0 {
+			tm["$gte"] = from
+		}
+		if to > 0 {
+			tm["$lte"] = to
+		}
+		filter[TimeCreated] = tm
+	}
+	return filter
+}
+
+func GetAuditsCount(target, actor string, from, to int64) int64 {
+	num, _ := getCountByFilter(TableAudits, auditFilter(target, actor, from, to))
+	return num
+}
+
+func GetAudits(target, actor string, from, to, start, num int64) ([]*Audit, error) {
+	var items = make([]*Audit, 0, 20)
+	opts := options.Find().SetSort(bson.D{{Key: TimeCreated, Value: -1}}).SetLimit(num).SetSkip(start)
+	cursor, err1 := findManyByOpts(TableAudits, auditFilter(target, actor, from, to), opts)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Audit)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//按时间顺序遍历审计记录，用于导出大量数据
+func EachAudits(target, actor string, from, to int64, fun func(*Audit) error) error {
+	c := noSql.Collection(TableAudits)
+	opts := options.Find().SetSort(bson.D{{Key: TimeCreated, Value: 1}})
+	ctx := context.Background()
+	cursor, err := c.Find(ctx, auditFilter(target, actor, from, to), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var node = new(Audit)
+		if err = cursor.Decode(node); err != nil {
+			return err
+		}
+		if err = fun(node); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+//审计记录里被删除的用户替换成的值
+const AuditErased = "erased"
+
+//删除生物特征数据时脱敏审计记录：目标是这些人脸或者人的清空前后的值，前后的值里字段是这些用户的替换为erased
+func RedactAudits(targets, users, fields []string) (int64, error) {
+	var num int64 = 0
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	if len(targets) > 0 {
+		msg := bson.M{"$set": bson.M{"before": bson.M{}, "after": bson.M{}}}
+		result, err := noSql.Collection(TableAudits).UpdateMany(ctx, bson.M{"target": bson.M{"$in": targets}}, msg)
+		if err != nil {
+			return num, err
+		}
+		num += result.ModifiedCount
+	}
+	if len(users) < 1 {
+		return num, nil
+	}
+	for _, side := range []string{"before", "after"} {
+		for _, field := range fields {
+			key := side + "." + field
+			result, err := noSql.Collection(TableAudits).UpdateMany(ctx, bson.M{key: bson.M{"$in": users}}, bson.M{"$set": bson.M{key: AuditErased}})
+			if err != nil {
+				return num, err
+			}
+			num += result.ModifiedCount
+		}
+	}
+	return num, nil
+}
@@ -57,6 +57,26 @@ func initMongoDB(ip string, port string, db string) error {
 	for i := 0; i < len(tables); i++ {
 		log.Info("no sql table name = " + tables[i])
 	}
+	txSupported = detectTransaction(ctx)
+	if !txSupported {
+		log.Warn("the mongodb is standalone, compound writes will use compensation instead of transactions")
+	}
+	err = ensureIdempotencyIndex(ctx)
+	if err != nil {
+		log.Warn("create the ttl index of idempotency failed that msg = " + err.Error())
+	}
+	err = ensureMigrationIndex(ctx)
+	if err != nil {
+		log.Warn("create the unique index of migrations failed that msg = " + err.Error())
+	}
+	err = ensurePersonIndex(ctx)
+	if err != nil {
+		log.Warn("create the unique index of persons failed that msg = " + err.Error())
+	}
+	err = ensureOutboxIndex(ctx)
+	if err != nil {
+		log.Warn("create the index of outbox failed that msg = " + err.Error())
+	}
 	return nil
 }
 
@@ -0,0 +1,92 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	BatchRunning  uint8 = 0
+	BatchFinished uint8 = 1
+	BatchFailed   uint8 = 2 //进程退出或者心跳超时，没有执行完
+)
+
+//后台执行的批量操作以及每个资源的结果
+type Batch struct {
+	UID     primitive.ObjectID `bson:"_id"`
+	Created int64              `json:"created" bson:"created"`
+	Updated int64              `json:"updated" bson:"updated"`
+	Creator string             `json:"creator" bson:"creator"`
+
+	Operation string        `json:"operation" bson:"operation"`
+	Spec      string        `json:"spec" bson:"spec"`
+	Status    uint8         `json:"status" bson:"status"`
+	Total     uint32        `json:"total" bson:"total"`
+	Done      uint32        `json:"done" bson:"done"`
+	Failed    uint32        `json:"failed" bson:"failed"`
+	Error     string        `json:"error" bson:"error"`
+	Results   []BatchResult `json:"results" bson:"results"`
+}
+
+type BatchResult struct {
+	UID    string      `json:"uid" bson:"uid"`
+	OK     bool        `json:"ok" bson:"ok"`
+	Error  string      `json:"error,omitempty" bson:"error"`
+	Before interface{} `json:"before,omitempty" bson:"before"`
+	After  interface{} `json:"after,omitempty" bson:"after"`
+}
+
+func CreateBatch(info *Batch) error {
+	_, err := insertOne(TableBatches, info)
+	return err
+}
+
+func GetBatch(uid string) (*Batch, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db batch uid is empty of GetBatch")
+	}
+	result, err := findOne(TableBatches, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Batch)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+func UpdateBatchProgress(uid string, status uint8, done, failed uint32, results []BatchResult) error {
+	if len(uid) < 2 {
+		return errors.New("db batch uid is empty of UpdateBatchProgress")
+	}
+	msg := bson.M{"status": status, "done": done, "failed": failed, "results": results, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableBatches, uid, msg)
+	return err
+}
+
+//执行中的任务定时更新心跳（更新时间）
+func UpdateBatchHeartbeat(uid string) error {
+	if len(uid) < 2 {
+		return errors.New("db batch uid is empty of UpdateBatchHeartbeat")
+	}
+	_, err := updateOne(TableBatches, uid, bson.M{TimeUpdated: time.Now().Unix()})
+	return err
+}
+
+//心跳在before之前的执行中任务标记为失败，返回标记的数量
+func FailStaleBatches(before int64, msg string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	filter := bson.M{"status": BatchRunning, TimeUpdated: bson.M{"$lt": before}}
+	node := bson.M{"$set": bson.M{"status": BatchFailed, "error": msg, TimeUpdated: time.Now().Unix()}}
+	result, err := noSql.Collection(TableBatches).UpdateMany(ctx, filter, node)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
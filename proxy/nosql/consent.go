@@ -0,0 +1,94 @@
+package nosql
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+//人脸识别的授权，Kind为owner、scope或者quote，Key为对应的所有者、范围或者场景
+type Consent struct {
+	UID      primitive.ObjectID `bson:"_id"`
+	Created  int64              `json:"created" bson:"created"`
+	Updated  int64              `json:"updated" bson:"updated"`
+	Creator  string             `json:"creator" bson:"creator"`
+	Operator string             `json:"operator" bson:"operator"`
+
+	Kind   string `json:"kind" bson:"kind"`
+	Key    string `json:"key" bson:"key"`
+	State  string `json:"state" bson:"state"`
+	Remark string `json:"remark" bson:"remark"`
+}
+
+//同一个Kind和Key只有一条记录，返回修改前的记录，不存在时为nil
+func UpsertConsent(info *Consent) (*Consent, error) {
+	filter := bson.M{"kind": info.Kind, "key": info.Key}
+	now := time.Now().Unix()
+	update := bson.M{
+		"$set":         bson.M{"state": info.State, "remark": info.Remark, "operator": info.Operator, TimeUpdated: now},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), TimeCreated: now, "creator": info.Operator},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	result := noSql.Collection(TableConsents).FindOneAndUpdate(ctx, filter, update, opts)
+	model := new(Consent)
+	err := result.Decode(model)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return model, nil
+}
+
+//keys的key为Kind，value为Key
+func GetConsentsByKeys(keys map[string]string) ([]*Consent, error) {
+	var items = make([]*Consent, 0, len(keys))
+	arr := bson.A{}
+	for kind, key := range keys {
+		arr = append(arr, bson.M{"kind": kind, "key": key})
+	}
+	if len(arr) < 1 {
+		return items, nil
+	}
+	cursor, err1 := findMany(TableConsents, bson.M{"$or": arr}, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Consent)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//kind为空时返回所有的授权
+func GetConsentsByKind(kind string) ([]*Consent, error) {
+	var items = make([]*Consent, 0, 20)
+	filter := bson.M{}
+	if len(kind) > 0 {
+		filter["kind"] = kind
+	}
+	cursor, err1 := findMany(TableConsents, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Consent)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
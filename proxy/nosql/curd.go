@@ -18,6 +18,16 @@ const (
 	TimeDeleted = "deleted"
 )
 
+const FieldQuarantine = "quarantine"
+
+//每次写操作都会加一的修订号
+const FieldRevision = "revision"
+
+//不检查修订号
+const RevisionAny int64 = -1
+
+var ErrRevisionConflict = errors.New("the revision is conflict")
+
 func UpdateItemTime(table, uid string, created, updated, del time.Time) {
 	d := del.Unix()
 	if d < 0 {
@@ -52,6 +62,25 @@ func GetAll[T any](table string, items []*T) []*T {
 	return items
 }
 
+//逐个读取符合条件的文档，不会一次全部加载到内存；fn返回错误时停止
+func Each[T any](table string, filter bson.M, fn func(item *T) error) error {
+	cursor, err := findMany(table, filter, 0)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(context.Background()) {
+		var node = new(T)
+		if err = cursor.Decode(node); err != nil {
+			return err
+		}
+		if err = fn(node); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
 func findAll(collection string, limit int64) (*mongo.Cursor, error) {
 	if len(collection) < 1 {
 		return nil, errors.New("the collection is empty")
@@ -77,6 +106,10 @@ func findAll(collection string, limit int64) (*mongo.Cursor, error) {
 }
 
 func insertOne(collection string, info interface{}) (interface{}, error) {
+	return insertOneIn(context.Background(), collection, info)
+}
+
+func insertOneIn(ctx context.Context, collection string, info interface{}) (interface{}, error) {
 	if len(collection) < 1 {
 		return "", errors.New("the collection is empty")
 	}
@@ -84,7 +117,7 @@ func insertOne(collection string, info interface{}) (interface{}, error) {
 	if c == nil {
 		return "", errors.New("can not found the collection of" + collection)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 	result, err := c.InsertOne(ctx, info)
 	if err != nil {
@@ -171,6 +204,10 @@ func deleteOne(collection, uid string) (int64, error) {
 }
 
 func removeOne(collection, uid, operator string) (int64, error) {
+	return removeOneIn(context.Background(), collection, uid, operator)
+}
+
+func removeOneIn(ctx context.Context, collection, uid, operator string) (int64, error) {
 	if len(collection) < 1 {
 		return 0, errors.New("the collection is empty")
 	}
@@ -185,10 +222,10 @@ func removeOne(collection, uid, operator string) (int64, error) {
 	if c == nil {
 		return 0, errors.New("can not found the collection of" + collection)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 	filter := bson.M{"_id": objID}
-	node := bson.M{"$set": bson.M{"operator": operator, TimeDeleted: time.Now().Unix()}}
+	node := bson.M{"$set": bson.M{"operator": operator, TimeDeleted: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
 	result, err := c.UpdateOne(ctx, filter, node)
 	if err != nil {
 		return 0, err
@@ -206,7 +243,7 @@ func removeBy(collection, operator string, filter bson.M) (int64, error) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
 	defer cancel()
-	node := bson.M{"$set": bson.M{"operator": operator, TimeDeleted: time.Now().Unix()}}
+	node := bson.M{"$set": bson.M{"operator": operator, TimeDeleted: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
 	result, err := c.UpdateOne(ctx, filter, node)
 	if err != nil {
 		return 0, err
@@ -232,6 +269,10 @@ func hadOne(collection string, filter bson.M) (bool, error) {
 }
 
 func updateOne(collection, uid string, data bson.M) (int64, error) {
+	return updateOneIn(context.Background(), collection, uid, data)
+}
+
+func updateOneIn(ctx context.Context, collection, uid string, data bson.M) (int64, error) {
 	if len(collection) < 1 {
 		return 0, errors.New("the collection is empty")
 	}
@@ -246,10 +287,10 @@ func updateOne(collection, uid string, data bson.M) (int64, error) {
 	if c == nil {
 		return 0, errors.New("can not found the collection of" + collection)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 	filter := bson.M{"_id": objID}
-	node := bson.M{"$set": data}
+	node := bson.M{"$set": data, "$inc": bson.M{FieldRevision: 1}}
 	result, err := c.UpdateOne(ctx, filter, node)
 	if err != nil {
 		return 0, err
@@ -278,7 +319,7 @@ func appendElement(collection, uid string, data bson.M) (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
 	defer cancel()
 	filter := bson.M{"_id": objID}
-	node := bson.M{"$push": data, "$set": bson.M{TimeUpdated: time.Now().Unix()}}
+	node := bson.M{"$push": data, "$set": bson.M{TimeUpdated: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
 	result, err := c.UpdateOne(ctx, filter, node)
 	if err != nil {
 		return 0, err
@@ -290,6 +331,10 @@ func appendElement(collection, uid string, data bson.M) (int64, error) {
 从数组里面移除一个元素
 */
 func removeElement(collection, uid string, data bson.M) (int64, error) {
+	return removeElementIn(context.Background(), collection, uid, data)
+}
+
+func removeElementIn(ctx context.Context, collection, uid string, data bson.M) (int64, error) {
 	if len(collection) < 1 {
 		return 0, errors.New("the collection is empty")
 	}
@@ -304,10 +349,10 @@ func removeElement(collection, uid string, data bson.M) (int64, error) {
 	if c == nil {
 		return 0, errors.New("can not found the collection of" + collection)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 	filter := bson.M{"_id": objID}
-	node := bson.M{"$pull": data, "$set": bson.M{TimeUpdated: time.Now().Unix()}}
+	node := bson.M{"$pull": data, "$set": bson.M{TimeUpdated: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
 	result, err := c.UpdateOne(ctx, filter, node)
 	if err != nil {
 		return 0, err
@@ -315,6 +360,84 @@ func removeElement(collection, uid string, data bson.M) (int64, error) {
 	return result.ModifiedCount, nil
 }
 
+/**
+修订号和预期的一致时才修改，revision小于0时不检查；没有修订号的旧数据视为0
+*/
+func updateOneRevision(collection, uid string, revision int64, data bson.M) (int64, error) {
+	return updateOneRevisionIn(context.Background(), collection, uid, revision, data)
+}
+
+func updateOneRevisionIn(ctx context.Context, collection, uid string, revision int64, data bson.M) (int64, error) {
+	if revision < 0 {
+		return updateOneIn(ctx, collection, uid, data)
+	}
+	if len(collection) < 1 {
+		return 0, errors.New("the collection is empty")
+	}
+	objID, e := primitive.ObjectIDFromHex(uid)
+	if e != nil {
+		return 0, e
+	}
+	c := noSql.Collection(collection)
+	if c == nil {
+		return 0, errors.New("can not found the collection of" + collection)
+	}
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	filter := revisionCond(revision)
+	filter["_id"] = objID
+	node := bson.M{"$set": data, "$inc": bson.M{FieldRevision: 1}}
+	result, err := c.UpdateOne(ctx, filter, node)
+	if err != nil {
+		return 0, err
+	}
+	if result.MatchedCount < 1 {
+		return 0, ErrRevisionConflict
+	}
+	return result.ModifiedCount, nil
+}
+
+//修订号等于revision的条件，没有修订号的旧数据视为0
+func revisionCond(revision int64) bson.M {
+	if revision == 0 {
+		return bson.M{"$or": bson.A{bson.M{FieldRevision: 0}, bson.M{FieldRevision: bson.M{"$exists": false}}}}
+	}
+	return bson.M{FieldRevision: revision}
+}
+
+/**
+满足条件时才往数组里面追加一个元素，返回是否追加
+*/
+func appendElementBy(collection, uid string, cond bson.M, data bson.M) (bool, error) {
+	return appendElementByIn(context.Background(), collection, uid, cond, data)
+}
+
+func appendElementByIn(ctx context.Context, collection, uid string, cond bson.M, data bson.M) (bool, error) {
+	if len(collection) < 1 {
+		return false, errors.New("the collection is empty")
+	}
+	objID, e := primitive.ObjectIDFromHex(uid)
+	if e != nil {
+		return false, e
+	}
+	c := noSql.Collection(collection)
+	if c == nil {
+		return false, errors.New("can not found the collection of" + collection)
+	}
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	filter := bson.M{"_id": objID}
+	for key, val := range cond {
+		filter[key] = val
+	}
+	node := bson.M{"$push": data, "$set": bson.M{TimeUpdated: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
+	result, err := c.UpdateOne(ctx, filter, node)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
 func updateOneBy(collection string, filter bson.M, update bson.M) (int64, error) {
 	if len(collection) < 1 {
 		return 0, errors.New("the collection is empty")
@@ -333,6 +456,10 @@ func updateOneBy(collection string, filter bson.M, update bson.M) (int64, error)
 }
 
 func findOne(collection, uid string) (*mongo.SingleResult, error) {
+	return findOneIn(context.Background(), collection, uid)
+}
+
+func findOneIn(ctx context.Context, collection, uid string) (*mongo.SingleResult, error) {
 	if len(collection) < 2 {
 		return nil, errors.New("the collection is empty")
 	}
@@ -347,7 +474,7 @@ func findOne(collection, uid string) (*mongo.SingleResult, error) {
 	if c == nil {
 		return nil, errors.New("can not found the collection of" + collection)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	ctx, cancel := writeContext(ctx)
 	defer cancel()
 	filter := bson.M{"_id": objID}
 	result := c.FindOne(ctx, filter)
@@ -543,3 +670,11 @@ func copyOne(collection string) (*mongo.Collection, error) {
 	}
 	return tmp, nil
 }
+
+//事务里的会话已经有超时时间，直接使用，否则加上超时时间
+func writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeOut)
+}
@@ -18,6 +18,7 @@ type Folder struct {
 	Deleted  int64              `json:"deleted" bson:"deleted"`
 	Creator  string             `json:"creator" bson:"creator"`
 	Operator string             `json:"operator" bson:"operator"`
+	Revision uint64             `json:"revision" bson:"revision"`
 
 	Scene    string            `json:"scene" bson:"scene"`
 	Remark   string            `json:"face" bson:"face"`
@@ -30,8 +31,8 @@ type Folder struct {
 	Contents []*proxy.PairInfo `json:"contents" bson:"contents"`
 }
 
-func CreateFolder(info *Folder) error {
-	_, err := insertOne(TableFolders, &info)
+func CreateFolder(ctx context.Context, info *Folder) error {
+	_, err := insertOneIn(ctx, TableFolders, &info)
 	return err
 }
 
@@ -45,11 +46,11 @@ func GetFolderCount() int64 {
 	return num
 }
 
-func RemoveFolder(uid, operator string) error {
+func RemoveFolder(ctx context.Context, uid, operator string) error {
 	if len(uid) < 2 {
 		return errors.New("db thumb uid is empty ")
 	}
-	_, err := removeOne(TableFolders, uid, operator)
+	_, err := removeOneIn(ctx, TableFolders, uid, operator)
 	return err
 }
 
@@ -60,11 +61,15 @@ func GetFolderChildrenCount(parent string) int64 {
 }
 
 func GetFolder(uid string) (*Folder, error) {
+	return GetFolderIn(context.Background(), uid)
+}
+
+func GetFolderIn(ctx context.Context, uid string) (*Folder, error) {
 	if len(uid) < 2 {
 		return nil, errors.New("db thumb uid is empty of GetFolder")
 	}
 
-	result, err := findOne(TableFolders, uid)
+	result, err := findOneIn(ctx, TableFolders, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -112,62 +117,102 @@ func GetFoldersByParent(parent string) ([]*Folder, error) {
 	return items, nil
 }
 
-func UpdateFolderBase(uid, name, remark, operator string) error {
+func UpdateFolderBase(ctx context.Context, uid, name, remark, operator string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db folder uid is empty")
 	}
 
 	msg := bson.M{"name": name, "remark": remark, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableFolders, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableFolders, uid, revision, msg)
 	return err
 }
 
-func UpdateFolderAccess(uid, operator string, acc uint8) error {
+func UpdateFolderAccess(ctx context.Context, uid, operator string, acc uint8) error {
 	if len(uid) < 2 {
 		return errors.New("db folder uid is empty")
 	}
 
 	msg := bson.M{"access": acc, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableFolders, uid, msg)
+	_, err := updateOneIn(ctx, TableFolders, uid, msg)
 	return err
 }
 
-func UpdateFolderContents(uid, operator string, list []*proxy.PairInfo) error {
+func UpdateFolderContents(ctx context.Context, uid, operator string, revision int64, list []*proxy.PairInfo) error {
 	if len(uid) < 2 {
 		return errors.New("db folder uid is empty")
 	}
 
 	msg := bson.M{"contents": list, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableFolders, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableFolders, uid, revision, msg)
 	return err
 }
 
-func UpdateFolderParent(uid, parent, operator string) error {
+func UpdateFolderParent(ctx context.Context, uid, parent, operator string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db folder uid is empty")
 	}
 
 	msg := bson.M{"parent": parent, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableFolders, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableFolders, uid, revision, msg)
 	return err
 }
 
-func UpdateFolderCover(uid, cover, operator string) error {
+func UpdateFolderCover(ctx context.Context, uid, cover, operator string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db folder uid is empty")
 	}
 
 	msg := bson.M{"cover": cover, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableFolders, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableFolders, uid, revision, msg)
 	return err
 }
 
-func AppendFolderContent(uid, operator string, cont *proxy.PairInfo) error {
+//内容里没有相同的key时才追加，返回是否追加；revision小于0时不检查修订号
+func AppendFolderContent(ctx context.Context, uid, operator string, cont *proxy.PairInfo, revision int64) (bool, error) {
 	if len(uid) < 2 {
-		return errors.New("db folder uid is empty")
+		return false, errors.New("db folder uid is empty")
 	}
 
+	cond := bson.M{"contents.key": bson.M{"$ne": cont.Key}}
+	if revision >= 0 {
+		for key, val := range revisionCond(revision) {
+			cond[key] = val
+		}
+	}
 	msg := bson.M{"contents": cont}
-	_, err := appendElement(TableFolders, uid, msg)
+	ok, err := appendElementByIn(ctx, TableFolders, uid, cond, msg)
+	if err != nil || ok || revision < 0 {
+		return ok, err
+	}
+	//没有追加时区分是已经存在还是修订号不一致
+	db, err := GetFolderIn(ctx, uid)
+	if err != nil {
+		return false, err
+	}
+	if int64(db.Revision) != revision {
+		return false, ErrRevisionConflict
+	}
+	return false, nil
+}
+
+func RemoveFolderContent(ctx context.Context, uid, key string) error {
+	if len(uid) < 2 {
+		return errors.New("db folder uid is empty")
+	}
+	msg := bson.M{"contents": bson.M{"key": key}}
+	_, err := removeElementIn(ctx, TableFolders, uid, msg)
+	return err
+}
+
+//一次写入多个字段，revision为预期的修订号
+func UpdateFolderFields(ctx context.Context, uid, operator string, revision int64, fields bson.M) error {
+	if len(uid) < 2 {
+		return errors.New("db folder uid is empty of UpdateFolderFields")
+	}
+	msg := bson.M{"operator": operator, TimeUpdated: time.Now().Unix()}
+	for key, val := range fields {
+		msg[key] = val
+	}
+	_, err := updateOneRevisionIn(ctx, TableFolders, uid, revision, msg)
 	return err
 }
@@ -0,0 +1,92 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	IdempotencyPending uint8 = 0
+	IdempotencyDone    uint8 = 1
+)
+
+//创建请求的幂等键，Expire之后由TTL索引自动删除
+type Idempotency struct {
+	Key     string    `json:"key" bson:"_id"`
+	Method  string    `json:"method" bson:"method"`
+	Caller  string    `json:"caller" bson:"caller"`
+	Digest  string    `json:"digest" bson:"digest"`
+	Status  uint8     `json:"status" bson:"status"`
+	Result  string    `json:"result" bson:"result"`
+	Created int64     `json:"created" bson:"created"`
+	Lease   int64     `json:"lease" bson:"lease"` //处理中的请求占用键的截止时间，过期后其他请求可以接管
+	Expire  time.Time `json:"expire" bson:"expire"`
+}
+
+func ensureIdempotencyIndex(ctx context.Context) error {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expire", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := noSql.Collection(TableIdempotency).Indexes().CreateOne(ctx, model)
+	return err
+}
+
+//写入成功返回true，键已经存在时返回false
+func CreateIdempotency(info *Idempotency) (bool, error) {
+	_, err := insertOne(TableIdempotency, info)
+	if err == nil {
+		return true, nil
+	}
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, item := range we.WriteErrors {
+			if item.Code == 11000 {
+				return false, nil
+			}
+		}
+	}
+	return false, err
+}
+
+func GetIdempotency(key string) (*Idempotency, error) {
+	result, err := findOneBy(TableIdempotency, bson.M{"_id": key})
+	if err != nil {
+		return nil, err
+	}
+	model := new(Idempotency)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+func UpdateIdempotencyResult(key, result string) error {
+	msg := bson.M{"$set": bson.M{"status": IdempotencyDone, "result": result}}
+	_, err := updateOneBy(TableIdempotency, bson.M{"_id": key}, msg)
+	return err
+}
+
+//处理中的请求租约过期时接管幂等键，只有一个请求可以接管成功
+func TakeoverIdempotency(key string, old, lease int64) (bool, error) {
+	filter := bson.M{"_id": key, "status": IdempotencyPending, "lease": old}
+	msg := bson.M{"$set": bson.M{"lease": lease}}
+	num, err := updateOneBy(TableIdempotency, filter, msg)
+	if err != nil {
+		return false, err
+	}
+	return num > 0, nil
+}
+
+//创建失败时删除，允许客户端重试
+func RemoveIdempotency(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	_, err := noSql.Collection(TableIdempotency).DeleteOne(ctx, bson.M{"_id": key, "status": IdempotencyPending})
+	return err
+}
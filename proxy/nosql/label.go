@@ -17,6 +17,7 @@ type Label struct {
 	Deleted  int64              `json:"deleted" bson:"deleted"`
 	Creator  string             `json:"creator" bson:"creator"`
 	Operator string             `json:"operator" bson:"operator"`
+	Revision uint64             `json:"revision" bson:"revision"`
 
 	Scene  string `json:"scene" bson:"scene"`
 	Remark string `json:"face" bson:"face"`
@@ -69,6 +70,24 @@ func GetLabel(uid string) (*Label, error) {
 	return model, nil
 }
 
+//在事务里读取，可以读到事务里还没有提交的修改
+func GetLabelIn(ctx context.Context, uid string) (*Label, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db thumb uid is empty of GetLabel")
+	}
+
+	result, err := findOneIn(ctx, TableLabels, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Label)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
 func GetLabelsByScene(owner string) ([]*Label, error) {
 	var items = make([]*Label, 0, 20)
 	filter := bson.M{"scene": owner, TimeDeleted: 0}
@@ -101,12 +120,25 @@ func GetLabelByName(name string) (*Label, error) {
 	return model, nil
 }
 
-func UpdateLabelBase(uid, name, remark, operator string) error {
+func UpdateLabelBase(ctx context.Context, uid, name, remark, operator string, revision int64) error {
 	if len(uid) < 2 {
 		return errors.New("db folder uid is empty")
 	}
 
 	msg := bson.M{"name": name, "remark": remark, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableLabels, uid, msg)
+	_, err := updateOneRevisionIn(ctx, TableLabels, uid, revision, msg)
+	return err
+}
+
+//一次写入多个字段，revision为预期的修订号
+func UpdateLabelFields(ctx context.Context, uid, operator string, revision int64, fields bson.M) error {
+	if len(uid) < 2 {
+		return errors.New("db label uid is empty of UpdateLabelFields")
+	}
+	msg := bson.M{"operator": operator, TimeUpdated: time.Now().Unix()}
+	for key, val := range fields {
+		msg[key] = val
+	}
+	_, err := updateOneRevisionIn(ctx, TableLabels, uid, revision, msg)
 	return err
 }
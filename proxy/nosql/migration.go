@@ -0,0 +1,89 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+//资源的所有者或者范围修改后等待迁移人脸库，一个资源只有一条，迁移成功后删除；Sequence每次排队加一
+type Migration struct {
+	UID      primitive.ObjectID `bson:"_id"`
+	Created  int64              `json:"created" bson:"created"`
+	Updated  int64              `json:"updated" bson:"updated"`
+	Operator string             `json:"operator" bson:"operator"`
+
+	Asset    string `json:"asset" bson:"asset"`
+	Sequence uint64 `json:"sequence" bson:"sequence"`
+	Attempts uint32 `json:"attempts" bson:"attempts"`
+	Next     int64  `json:"next" bson:"next"`
+	Error    string `json:"error" bson:"error"`
+}
+
+//一个资源只有一条等待的迁移
+func ensureMigrationIndex(ctx context.Context) error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "asset", Value: 1}}, Options: options.Index().SetUnique(true)}
+	_, err := noSql.Collection(TableMigrations).Indexes().CreateOne(ctx, model)
+	return err
+}
+
+//资源已经在排队时只更新操作者，并且立即执行
+func UpsertMigration(asset, operator string) error {
+	if len(asset) < 2 {
+		return errors.New("db asset uid is empty of UpsertMigration")
+	}
+	now := time.Now().Unix()
+	update := bson.M{
+		"$set":         bson.M{"operator": operator, "next": now, TimeUpdated: now},
+		"$inc":         bson.M{"sequence": 1},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), TimeCreated: now, "attempts": 0, "error": ""},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	_, err := noSql.Collection(TableMigrations).UpdateOne(ctx, bson.M{"asset": asset}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+//到了执行时间的迁移，先排队的在前面
+func GetDueMigrations(now, num int64) ([]*Migration, error) {
+	var items = make([]*Migration, 0, 10)
+	opts := options.Find().SetSort(bson.M{"next": 1}).SetLimit(num)
+	cursor, err1 := findManyByOpts(TableMigrations, bson.M{"next": bson.M{"$lte": now}}, opts)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Migration)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+func UpdateMigrationFailed(uid, msg string, attempts uint32, next int64) error {
+	if len(uid) < 2 {
+		return errors.New("db migration uid is empty of UpdateMigrationFailed")
+	}
+	msg1 := bson.M{"attempts": attempts, "next": next, "error": msg, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableMigrations, uid, msg1)
+	return err
+}
+
+//执行期间资源又排队时保留，下次再迁移
+func RemoveMigration(uid string, sequence uint64) error {
+	objID, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	_, err = noSql.Collection(TableMigrations).DeleteOne(ctx, bson.M{"_id": objID, "sequence": sequence})
+	return err
+}
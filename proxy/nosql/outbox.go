@@ -0,0 +1,156 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	OutboxPending uint8 = 0
+	OutboxSent    uint8 = 1
+	OutboxDead    uint8 = 2 //重试次数用完，不再发布，也不阻塞后面的事件
+)
+
+//待发布的领域事件，发布成功后才标记为已发送
+type Outbox struct {
+	UID      primitive.ObjectID `bson:"_id"`
+	Created  int64              `json:"created" bson:"created"`
+	Updated  int64              `json:"updated" bson:"updated"`
+	Topic    string             `json:"topic" bson:"topic"`
+	Type     string             `json:"type" bson:"type"`
+	Version  uint32             `json:"version" bson:"version"`
+	Subject  string             `json:"subject" bson:"subject"`
+	Payload  string             `json:"payload" bson:"payload"`
+	Status   uint8              `json:"status" bson:"status"`
+	Attempts uint32             `json:"attempts" bson:"attempts"`
+	Error    string             `json:"error" bson:"error"`
+}
+
+//发件箱、审计和webhook投递会在事务里写入，4.4之前的mongodb不能在事务里创建集合，所以启动时通过创建索引提前创建
+func ensureOutboxIndex(ctx context.Context) error {
+	models := map[string]mongo.IndexModel{
+		TableOutbox:     {Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		TableAudits:     {Keys: bson.D{{Key: "target", Value: 1}, {Key: TimeCreated, Value: -1}}},
+		TableDeliveries: {Keys: bson.D{{Key: "status", Value: 1}, {Key: "next", Value: 1}}},
+	}
+	for table, model := range models {
+		_, err := noSql.Collection(table).Indexes().CreateOne(ctx, model)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CreateOutbox(ctx context.Context, info *Outbox) error {
+	_, err := insertOneIn(ctx, TableOutbox, info)
+	return err
+}
+
+//按写入的顺序取出待发布的事件
+func GetPendingOutbox(num int64) ([]*Outbox, error) {
+	var items = make([]*Outbox, 0, num)
+	filter := bson.M{"status": OutboxPending}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(num)
+	cursor, err1 := findManyByOpts(TableOutbox, filter, opts)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Outbox)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+func UpdateOutboxSent(uid string) error {
+	if len(uid) < 2 {
+		return errors.New("db outbox uid is empty of UpdateOutboxSent")
+	}
+	msg := bson.M{"status": OutboxSent, "error": "", TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableOutbox, uid, msg)
+	return err
+}
+
+func UpdateOutboxFailed(uid, msg string, attempts uint32) error {
+	if len(uid) < 2 {
+		return errors.New("db outbox uid is empty of UpdateOutboxFailed")
+	}
+	data := bson.M{"attempts": attempts, "error": msg, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableOutbox, uid, data)
+	return err
+}
+
+func UpdateOutboxDead(uid, msg string, attempts uint32) error {
+	if len(uid) < 2 {
+		return errors.New("db outbox uid is empty of UpdateOutboxDead")
+	}
+	data := bson.M{"status": OutboxDead, "attempts": attempts, "error": msg, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableOutbox, uid, data)
+	return err
+}
+
+//删除before之前已经发布的事件，返回删除的数量
+func PurgeOutbox(before int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	filter := bson.M{"status": OutboxSent, TimeUpdated: bson.M{"$lt": before}}
+	result, err := noSql.Collection(TableOutbox).DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+//删除生物特征数据时，删除提到这些人脸或者用户的事件，已经发布的也删除
+func PurgeOutboxByValues(values []string) (int64, error) {
+	return purgeByValues(TableOutbox, values, func(chunk []string) bson.M {
+		return bson.M{"$or": bson.A{bson.M{"subject": bson.M{"$in": chunk}}, bson.M{"payload": mentionRegex(chunk)}}}
+	})
+}
+
+//删除生物特征数据时，删除提到这些人脸或者用户的webhook投递
+func PurgeDeliveriesByValues(values []string) (int64, error) {
+	return purgeByValues(TableDeliveries, values, func(chunk []string) bson.M {
+		return bson.M{"payload": mentionRegex(chunk)}
+	})
+}
+
+//正则表达式不能太长，分批删除
+func purgeByValues(table string, values []string, filter func(chunk []string) bson.M) (int64, error) {
+	var num int64 = 0
+	for i := 0; i < len(values); i += 100 {
+		end := i + 100
+		if end > len(values) {
+			end = len(values)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+		result, err := noSql.Collection(table).DeleteMany(ctx, filter(values[i:end]))
+		cancel()
+		if err != nil {
+			return num, err
+		}
+		num += result.DeletedCount
+	}
+	return num, nil
+}
+
+func mentionRegex(values []string) bson.M {
+	arr := make([]string, 0, len(values))
+	for _, value := range values {
+		arr = append(arr, regexp.QuoteMeta(value))
+	}
+	//payload是json，按完整的字符串匹配，避免temp_1匹配到temp_12
+	return bson.M{"$regex": `"(` + strings.Join(arr, "|") + `)"`}
+}
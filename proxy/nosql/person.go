@@ -0,0 +1,367 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+//人脸库里的一个人，Key为人脸的user，Face为人脸库（服务商）里的用户ID
+type Person struct {
+	UID      primitive.ObjectID `bson:"_id"`
+	Created  int64              `json:"created" bson:"created"`
+	Updated  int64              `json:"updated" bson:"updated"`
+	Deleted  int64              `json:"deleted" bson:"deleted"`
+	Creator  string             `json:"creator" bson:"creator"`
+	Operator string             `json:"operator" bson:"operator"`
+	Revision uint64             `json:"revision" bson:"revision"`
+
+	Group  string `json:"group" bson:"group"`
+	Key    string `json:"key" bson:"key"`
+	Face   string `json:"face" bson:"face"`
+	Name   string `json:"name" bson:"name"`
+	Cover  string `json:"cover" bson:"cover"`
+	Entity string `json:"entity" bson:"entity"`
+	Quote  string `json:"quote" bson:"quote"`
+	Count  uint32 `json:"count" bson:"count"`
+}
+
+//人脸按人脸库和用户分组，Cover为最早的人脸，Quote为最早的人脸所在的场景
+type PersonKey struct {
+	Group string `bson:"group"`
+	User  string `bson:"user"`
+	Cover string `bson:"cover"`
+	Quote string `bson:"quote"`
+}
+
+//同一个人脸库里没有删除的人的key不能重复
+func ensurePersonIndex(ctx context.Context) error {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "group", Value: 1}, {Key: "key", Value: 1}, {Key: TimeDeleted, Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := noSql.Collection(TablePersons).Indexes().CreateOne(ctx, model)
+	return err
+}
+
+func CreatePerson(info *Person) error {
+	_, err := insertOne(TablePersons, info)
+	return err
+}
+
+func GetPerson(uid string) (*Person, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db person uid is empty of GetPerson")
+	}
+	result, err := findOne(TablePersons, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Person)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+func GetPersonByKey(group, key string) (*Person, error) {
+	filter := bson.M{"group": group, "key": key, TimeDeleted: 0}
+	result, err := findOneBy(TablePersons, filter)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Person)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+//不存在时创建，存在时只更新人脸数量
+func UpsertPerson(info *Person) (*Person, error) {
+	filter := bson.M{"group": info.Group, "key": info.Key, TimeDeleted: 0}
+	now := time.Now().Unix()
+	update := bson.M{
+		"$set": bson.M{"count": info.Count, TimeUpdated: now},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), TimeCreated: now, "creator": info.Creator,
+			"operator": info.Creator, "face": info.Face, "name": info.Name, "cover": info.Cover, "entity": info.Entity, "quote": info.Quote},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	model := new(Person)
+	err := noSql.Collection(TablePersons).FindOneAndUpdate(ctx, filter, update, opts).Decode(model)
+	if isDuplicateKey(err) {
+		//同时创建时唯一索引只允许一个插入成功，另一个重试时更新
+		model = new(Person)
+		err = noSql.Collection(TablePersons).FindOneAndUpdate(ctx, filter, update, opts).Decode(model)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func RemovePerson(uid, operator string) error {
+	if len(uid) < 2 {
+		return errors.New("db person uid is empty of RemovePerson")
+	}
+	_, err := removeOne(TablePersons, uid, operator)
+	return err
+}
+
+func UpdatePersonName(uid, name, operator string) error {
+	if len(uid) < 2 {
+		return errors.New("db person uid is empty of UpdatePersonName")
+	}
+	msg := bson.M{"name": name, "operator": operator, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TablePersons, uid, msg)
+	return err
+}
+
+func UpdatePersonBase(uid, cover, entity, operator string, count uint32) error {
+	if len(uid) < 2 {
+		return errors.New("db person uid is empty of UpdatePersonBase")
+	}
+	msg := bson.M{"cover": cover, "entity": entity, "count": count, "operator": operator, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TablePersons, uid, msg)
+	return err
+}
+
+//所有人脸库里key相同的人
+func GetPersonsByKey(key string) ([]*Person, error) {
+	var items = make([]*Person, 0, 2)
+	cursor, err1 := findMany(TablePersons, bson.M{"key": key, TimeDeleted: 0}, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Person)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//人脸的用户改为实体后，对应的人也跟着改变
+func UpdatePersonEntity(uid, entity, operator string) error {
+	if len(uid) < 2 {
+		return errors.New("db person uid is empty of UpdatePersonEntity")
+	}
+	msg := bson.M{"key": entity, "entity": entity, "operator": operator, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TablePersons, uid, msg)
+	return err
+}
+
+//按人脸库和用户分组，找出场景里出现的人
+func GetPersonKeysByQuote(quote string) ([]*PersonKey, error) {
+	return getPersonKeys(bson.M{"quote": quote, "user": bson.M{"$ne": ""}, TimeDeleted: 0})
+}
+
+//所有人脸对应的人，用于补齐没有创建的人
+func GetAllPersonKeys() ([]*PersonKey, error) {
+	return getPersonKeys(bson.M{"user": bson.M{"$ne": ""}, "group": bson.M{"$ne": ""}, TimeDeleted: 0})
+}
+
+func getPersonKeys(match bson.M) ([]*PersonKey, error) {
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$sort": bson.M{TimeCreated: 1}},
+		bson.M{"$group": bson.M{"_id": bson.M{"group": "$group", "user": "$user"}, "cover": bson.M{"$first": "$_id"}, "quote": bson.M{"$first": "$quote"}}},
+		bson.M{"$project": bson.M{"_id": 0, "group": "$_id.group", "user": "$_id.user", "cover": bson.M{"$toString": "$cover"}, "quote": 1}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	cursor, err := noSql.Collection(TableThumbs).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	items := make([]*PersonKey, 0, 20)
+	for cursor.Next(ctx) {
+		var node = new(PersonKey)
+		if err := cursor.Decode(node); err != nil {
+			return nil, err
+		}
+		items = append(items, node)
+	}
+	return items, nil
+}
+
+func GetThumbCountByPerson(group, user string) uint32 {
+	filter := bson.M{"group": group, "user": user, TimeDeleted: 0}
+	num, _ := getCountByFilter(TableThumbs, filter)
+	return uint32(num)
+}
+
+func GetThumbsByPerson(group, user string) ([]*Thumb, error) {
+	var items = make([]*Thumb, 0, 20)
+	filter := bson.M{"group": group, "user": user, TimeDeleted: 0}
+	cursor, err1 := findMany(TableThumbs, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Thumb)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//把人脸改为其他人，uids为空时修改这个人所有的人脸
+func UpdateThumbsPerson(group, from, to, operator string, uids []string) (int64, error) {
+	filter := bson.M{"group": group, "user": from, TimeDeleted: 0}
+	if len(uids) > 0 {
+		filter["_id"] = bson.M{"$in": objectIDs(uids)}
+	}
+	msg := bson.M{"$set": bson.M{"user": to, "operator": operator, TimeUpdated: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	result, err := noSql.Collection(TableThumbs).UpdateMany(ctx, filter, msg)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func UpdateThumbFace(uid, face string) error {
+	if len(uid) < 2 {
+		return errors.New("db thumb uid is empty of UpdateThumbFace")
+	}
+	msg := bson.M{"face": face, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableThumbs, uid, msg)
+	return err
+}
+
+//人脸库里的用户对应的人脸user，人脸绑定实体后两者不同
+func GetPersonKeysByFaces(group string, faces []string) (map[string]string, error) {
+	keys := make(map[string]string, len(faces))
+	filter := bson.M{"group": group, "face": bson.M{"$in": faces}, TimeDeleted: 0}
+	cursor, err1 := findMany(TablePersons, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Person)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		}
+		keys[node.Face] = node.Key
+	}
+	return keys, nil
+}
+
+//人脸库里的用户的人脸，可以限定场景、所有者和创建时间
+func GetThumbsByUsers(group string, users []string, quote, owner string, from, to int64) ([]*Thumb, error) {
+	var items = make([]*Thumb, 0, 20)
+	filter := bson.M{"group": group, "user": bson.M{"$in": users}, TimeDeleted: 0}
+	if len(quote) > 0 {
+		filter["quote"] = quote
+	}
+	if len(owner) > 0 {
+		filter["owner"] = owner
+	}
+	if from > 0 || to > 0 {
+		created := bson.M{}
+		if from > 0 {
+			created["$gte"] = from
+		}
+		if to > 0 {
+			created["$lte"] = to
+		}
+		filter[TimeCreated] = created
+	}
+	cursor, err1 := findMany(TableThumbs, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Thumb)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//key、人脸库用户或者实体为user的人，包括已经删除的
+func GetPersonsByUser(user string) ([]*Person, error) {
+	var items = make([]*Person, 0, 5)
+	filter := bson.M{"$or": bson.A{bson.M{"key": user}, bson.M{"face": user}, bson.M{"entity": user}}}
+	cursor, err1 := findMany(TablePersons, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Person)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+func DeletePersons(uids []string) (int64, error) {
+	if len(uids) < 1 {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	result, err := noSql.Collection(TablePersons).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(uids)}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func RemovePersonsByGroup(group, operator string) (int64, error) {
+	filter := bson.M{"group": group, TimeDeleted: 0}
+	msg := bson.M{"$set": bson.M{"operator": operator, TimeDeleted: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	result, err := noSql.Collection(TablePersons).UpdateMany(ctx, filter, msg)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+//每个人脸库里的人的数量
+func GetPersonCountByGroup(group string) uint32 {
+	num, _ := getCountByFilter(TablePersons, bson.M{"group": group, TimeDeleted: 0})
+	return uint32(num)
+}
+
+//唯一索引冲突，当前驱动版本没有IsDuplicateKeyError
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, item := range we.WriteErrors {
+			if item.Code == 11000 {
+				return true
+			}
+		}
+	}
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return ce.Code == 11000
+	}
+	return false
+}
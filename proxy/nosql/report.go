@@ -0,0 +1,113 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	ReportRunning  uint8 = 0
+	ReportFinished uint8 = 1
+	ReportFailed   uint8 = 2
+)
+
+//一致性检查的报告，Kind区分检查的类型
+type Report struct {
+	UID     primitive.ObjectID `bson:"_id"`
+	Created int64              `json:"created" bson:"created"`
+	Updated int64              `json:"updated" bson:"updated"`
+	Creator string             `json:"creator" bson:"creator"`
+
+	Kind    string            `json:"kind" bson:"kind"`
+	Repair  bool              `json:"repair" bson:"repair"`
+	Status  uint8             `json:"status" bson:"status"`
+	Error   string            `json:"error" bson:"error"`
+	Scanned map[string]uint32 `json:"scanned" bson:"scanned"`
+	Counts  map[string]uint32 `json:"counts" bson:"counts"`
+	Issues  []ReportIssue     `json:"issues" bson:"issues"`
+}
+
+type ReportIssue struct {
+	Kind     string `json:"kind" bson:"kind"`
+	Target   string `json:"target" bson:"target"`
+	Detail   string `json:"detail" bson:"detail"`
+	Action   string `json:"action" bson:"action"`
+	Repaired bool   `json:"repaired" bson:"repaired"`
+	Error    string `json:"error,omitempty" bson:"error"`
+}
+
+func CreateReport(info *Report) error {
+	_, err := insertOne(TableReports, info)
+	return err
+}
+
+func GetReport(uid string) (*Report, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db report uid is empty of GetReport")
+	}
+	result, err := findOne(TableReports, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Report)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+//最近的报告，不包含具体的问题
+func GetReportsByKind(kind string, num int64) ([]*Report, error) {
+	var items = make([]*Report, 0, num)
+	filter := bson.M{"kind": kind}
+	opts := options.Find().SetSort(bson.D{{Key: TimeCreated, Value: -1}}).SetLimit(num).SetProjection(bson.M{"issues": 0})
+	cursor, err1 := findManyByOpts(TableReports, filter, opts)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Report)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+func UpdateReportResult(info *Report) error {
+	if info == nil {
+		return errors.New("db report is nil of UpdateReportResult")
+	}
+	msg := bson.M{"status": info.Status, "error": info.Error, "scanned": info.Scanned, "counts": info.Counts,
+		"issues": info.Issues, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableReports, info.UID.Hex(), msg)
+	return err
+}
+
+func UpdateReportHeartbeat(uid string) error {
+	if len(uid) < 2 {
+		return errors.New("db report uid is empty of UpdateReportHeartbeat")
+	}
+	_, err := updateOne(TableReports, uid, bson.M{TimeUpdated: time.Now().Unix()})
+	return err
+}
+
+//心跳在before之前的执行中报告标记为失败，返回标记的数量
+func FailStaleReports(kinds []string, before int64, msg string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	filter := bson.M{"kind": bson.M{"$in": kinds}, "status": ReportRunning, TimeUpdated: bson.M{"$lt": before}}
+	node := bson.M{"$set": bson.M{"status": ReportFailed, "error": msg, TimeUpdated: time.Now().Unix()}}
+	result, err := noSql.Collection(TableReports).UpdateMany(ctx, filter, node)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
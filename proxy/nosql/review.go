@@ -0,0 +1,145 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	ReviewPending    uint8 = 0
+	ReviewConfirmed  uint8 = 1
+	ReviewRejected   uint8 = 2
+	ReviewReassigned uint8 = 3
+)
+
+//相似度在建议和自动接受之间的人脸匹配，User为人脸暂时注册的用户，Decision为最终的用户
+type Review struct {
+	UID      primitive.ObjectID `bson:"_id"`
+	Created  int64              `json:"created" bson:"created"`
+	Updated  int64              `json:"updated" bson:"updated"`
+	Creator  string             `json:"creator" bson:"creator"`
+	Operator string             `json:"operator" bson:"operator"`
+
+	Thumb      string            `json:"thumb" bson:"thumb"`
+	Asset      string            `json:"asset" bson:"asset"`
+	Quote      string            `json:"quote" bson:"quote"`
+	Group      string            `json:"group" bson:"group"`
+	User       string            `json:"user" bson:"user"`
+	Status     uint8             `json:"status" bson:"status"`
+	Decision   string            `json:"decision" bson:"decision"`
+	Candidates []ReviewCandidate `json:"candidates" bson:"candidates"`
+}
+
+type ReviewCandidate struct {
+	User  string  `json:"user" bson:"user"`
+	Score float32 `json:"score" bson:"score"`
+}
+
+func CreateReview(info *Review) error {
+	_, err := insertOne(TableReviews, info)
+	return err
+}
+
+func GetReview(uid string) (*Review, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db review uid is empty of GetReview")
+	}
+	result, err := findOne(TableReviews, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Review)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+//quote为空时不限定场景，早的在前面
+func GetReviewsByStatus(quote string, st uint8, skip, num int64) ([]*Review, int64, error) {
+	var items = make([]*Review, 0, num)
+	filter := bson.M{"status": st}
+	if len(quote) > 0 {
+		filter["quote"] = quote
+	}
+	total, err := getCountByFilter(TableReviews, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: TimeCreated, Value: 1}}).SetSkip(skip).SetLimit(num)
+	cursor, err1 := findManyByOpts(TableReviews, filter, opts)
+	if err1 != nil {
+		return nil, 0, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Review)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, 0, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, total, nil
+}
+
+//只能处理待确认的记录，已经处理过时返回错误
+func UpdateReviewDecision(uid string, st uint8, decision, operator string) error {
+	id, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": id, "status": ReviewPending}
+	msg := bson.M{"$set": bson.M{"status": st, "decision": decision, "operator": operator, TimeUpdated: time.Now().Unix()}}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	result, err := noSql.Collection(TableReviews).UpdateOne(ctx, filter, msg)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount < 1 {
+		return errors.New("the review had decided")
+	}
+	return nil
+}
+
+//删除人脸时一起删除待确认的匹配
+func DeleteReviewsByThumbs(thumbs []string) (int64, error) {
+	if len(thumbs) < 1 {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	result, err := noSql.Collection(TableReviews).DeleteMany(ctx, bson.M{"thumb": bson.M{"$in": thumbs}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+//删除生物特征数据时，从待确认的匹配里去掉这些候选用户，已经确认的匹配清除确认的用户
+func RedactReviewsByUsers(users []string) (int64, error) {
+	if len(users) < 1 {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	now := time.Now().Unix()
+	filter := bson.M{"candidates.user": bson.M{"$in": users}}
+	msg := bson.M{"$pull": bson.M{"candidates": bson.M{"user": bson.M{"$in": users}}}, "$set": bson.M{TimeUpdated: now}}
+	result, err := noSql.Collection(TableReviews).UpdateMany(ctx, filter, msg)
+	if err != nil {
+		return 0, err
+	}
+	num := result.ModifiedCount
+	filter = bson.M{"decision": bson.M{"$in": users}}
+	result, err = noSql.Collection(TableReviews).UpdateMany(ctx, filter, bson.M{"$set": bson.M{"decision": AuditErased, TimeUpdated: now}})
+	if err != nil {
+		return num, err
+	}
+	return num + result.ModifiedCount, nil
+}
@@ -24,4 +24,35 @@ const (
 
 	//文件夹
 	TableLabels = "labels"
+
+	//写操作的审计记录
+	TableAudits = "asset_audits"
+
+	//领域事件的发件箱
+	TableOutbox = "asset_outbox"
+
+	//webhook的订阅和投递记录
+	TableWebhooks   = "asset_webhooks"
+	TableDeliveries = "asset_deliveries"
+
+	//创建请求的幂等键
+	TableIdempotency = "asset_idempotency"
+
+	//后台执行的批量操作
+	TableBatches = "asset_batches"
+
+	//等待迁移人脸库的资源
+	TableMigrations = "asset_migrations"
+
+	//一致性检查的报告
+	TableReports = "asset_reports"
+
+	//人脸库里的人
+	TablePersons = "asset_persons"
+
+	//待人工确认的人脸匹配
+	TableReviews = "asset_reviews"
+
+	//人脸识别的授权
+	TableConsents = "asset_consents"
 )
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"omo.msa.asset/proxy"
 	"time"
 )
@@ -21,23 +22,26 @@ type Thumb struct {
 	Deleted     int64              `json:"deleted" bson:"deleted"`
 	Creator     string             `json:"creator" bson:"creator"`
 	Operator    string             `json:"operator" bson:"operator"`
+	Revision    uint64             `json:"revision" bson:"revision"`
 
-	Owner    string             `json:"owner" bson:"owner"`
-	Probably float32            `json:"probably" bson:"probably"`
-	Blur     float32            `json:"blur" bson:"blur"`
-	File     string             `json:"file" bson:"file"` //远程文件名
-	Asset    string             `json:"asset" bson:"asset"`
-	Similar  float32            `json:"similar" bson:"similar"`
-	Meta     string             `json:"meta" bson:"meta"`
-	User     string             `json:"user" bson:"user"`
-	Quote    string             `json:"quote" bson:"quote"`
-	Group    string             `json:"group" bson:"group"`
-	Status   uint32             `json:"status" bson:"status"`
-	Location proxy.LocationInfo `json:"location" bson:"location"`
-}
-
-func CreateThumb(info *Thumb) error {
-	_, err := insertOne(TableThumbs, &info)
+	Owner     string              `json:"owner" bson:"owner"`
+	Probably  float32             `json:"probably" bson:"probably"`
+	Blur      float32             `json:"blur" bson:"blur"`
+	File      string              `json:"file" bson:"file"` //远程文件名
+	Face      string              `json:"face" bson:"face"` //人脸库里的face_token
+	Asset     string              `json:"asset" bson:"asset"`
+	Similar   float32             `json:"similar" bson:"similar"`
+	Meta      string              `json:"meta" bson:"meta"`
+	User      string              `json:"user" bson:"user"`
+	Quote     string              `json:"quote" bson:"quote"`
+	Group     string              `json:"group" bson:"group"`
+	Status    uint32              `json:"status" bson:"status"`
+	Location  proxy.LocationInfo  `json:"location" bson:"location"`
+	Attribute proxy.FaceAttribute `json:"attribute" bson:"attribute"`
+}
+
+func CreateThumb(ctx context.Context, info *Thumb) error {
+	_, err := insertOneIn(ctx, TableThumbs, &info)
 	return err
 }
 
@@ -82,6 +86,24 @@ func GetThumb(uid string) (*Thumb, error) {
 	return model, nil
 }
 
+//在事务里读取，可以读到事务里还没有提交的修改
+func GetThumbIn(ctx context.Context, uid string) (*Thumb, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db thumb uid is empty of GetThumb")
+	}
+
+	result, err := findOneIn(ctx, TableThumbs, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Thumb)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
 func GetThumbCountByAsset(asset string) uint32 {
 	filter := bson.M{"asset": asset, TimeDeleted: 0}
 	num, err1 := getCountBy(TableThumbs, filter)
@@ -219,13 +241,13 @@ func UpdateThumbMeta(uid, meta, operator string) error {
 	return err
 }
 
-func UpdateThumbUser(uid, user, operator string) error {
+func UpdateThumbUser(ctx context.Context, uid, user, operator string) error {
 	if len(uid) < 2 {
 		return errors.New("db thumb uid is empty of GetAsset")
 	}
 
 	msg := bson.M{"user": user, "operator": operator, TimeUpdated: time.Now().Unix()}
-	_, err := updateOne(TableThumbs, uid, msg)
+	_, err := updateOneIn(ctx, TableThumbs, uid, msg)
 	return err
 }
 
@@ -248,3 +270,130 @@ func UpdateThumbStatus(uid string, st uint32) error {
 	_, err := updateOne(TableThumbs, uid, msg)
 	return err
 }
+
+//一次写入多个字段，revision为预期的修订号
+func UpdateThumbFields(ctx context.Context, uid, operator string, revision int64, fields bson.M) error {
+	if len(uid) < 2 {
+		return errors.New("db thumb uid is empty of UpdateThumbFields")
+	}
+	msg := bson.M{"operator": operator, TimeUpdated: time.Now().Unix()}
+	for key, val := range fields {
+		msg[key] = val
+	}
+	_, err := updateOneRevisionIn(ctx, TableThumbs, uid, revision, msg)
+	return err
+}
+
+//按照人脸属性查询，清晰的人脸在前面
+func GetThumbsByAttribute(filter bson.M, num int64) ([]*Thumb, error) {
+	var items = make([]*Thumb, 0, 20)
+	filter[TimeDeleted] = 0
+	//没有指定脸型时只查询有属性的人脸，指定时不能覆盖脸型的条件
+	if _, ok := filter["attribute.kind"]; !ok {
+		filter["attribute.kind"] = bson.M{"$gt": ""}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "blur", Value: 1}, {Key: "probably", Value: -1}}).SetLimit(num)
+	cursor, err1 := findManyByOpts(TableThumbs, filter, opts)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Thumb)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//人脸所在的人脸库
+func GetThumbGroups() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	arr, err := noSql.Collection(TableThumbs).Distinct(ctx, "group", bson.M{TimeDeleted: 0})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(arr))
+	for _, item := range arr {
+		if val, ok := item.(string); ok && len(val) > 0 {
+			list = append(list, val)
+		}
+	}
+	return list, nil
+}
+
+//用户的所有人脸，包括已经删除的
+func GetAllThumbsByUsers(users []string) ([]*Thumb, error) {
+	var items = make([]*Thumb, 0, 20)
+	filter := bson.M{"user": bson.M{"$in": users}}
+	cursor, err1 := findMany(TableThumbs, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Thumb)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+//彻底删除人脸记录，用于删除生物特征数据
+func DeleteThumbs(uids []string) (int64, error) {
+	if len(uids) < 1 {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	result, err := noSql.Collection(TableThumbs).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(uids)}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+//删除人脸库时删除其中的人脸
+func RemoveThumbsByGroup(group, operator string) (int64, error) {
+	filter := bson.M{"group": group, TimeDeleted: 0}
+	msg := bson.M{"$set": bson.M{"operator": operator, TimeDeleted: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	result, err := noSql.Collection(TableThumbs).UpdateMany(ctx, filter, msg)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+//每个人脸库里的人脸数量
+func GetThumbCountByGroups() (map[string]uint32, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{TimeDeleted: 0}},
+		bson.M{"$group": bson.M{"_id": "$group", "count": bson.M{"$sum": 1}}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	cursor, err := noSql.Collection(TableThumbs).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	counts := make(map[string]uint32)
+	for cursor.Next(ctx) {
+		var node struct {
+			Group string `bson:"_id"`
+			Count int32  `bson:"count"`
+		}
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		}
+		counts[node.Group] = uint32(node.Count)
+	}
+	return counts, nil
+}
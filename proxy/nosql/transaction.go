@@ -0,0 +1,271 @@
+package nosql
+
+import (
+	"context"
+	"github.com/labstack/gommon/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+//多文档事务需要副本集或者分片集群，单机部署时使用补偿的方式回滚
+var txSupported bool
+
+func TransactionEnable() bool {
+	return txSupported
+}
+
+func detectTransaction(ctx context.Context) bool {
+	var result bson.M
+	err := dbClient.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+	if err != nil {
+		return false
+	}
+	if _, ok := result["setName"]; ok {
+		return true
+	}
+	return result["msg"] == "isdbgrid"
+}
+
+func withTransaction(fn func(ctx mongo.SessionContext) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	session, err := dbClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+//非事务模式下记录已经完成的步骤，失败时倒序撤销
+type compensator struct {
+	steps []func(ctx context.Context) error
+}
+
+func (mine *compensator) push(fn func(ctx context.Context) error) {
+	mine.steps = append(mine.steps, fn)
+}
+
+func (mine *compensator) rollback(ctx context.Context) {
+	for i := len(mine.steps) - 1; i > -1; i-- {
+		if err := mine.steps[i](ctx); err != nil {
+			log.Warn("compensate the step failed that msg = " + err.Error())
+		}
+	}
+}
+
+func objectIDs(uids []string) []primitive.ObjectID {
+	list := make([]primitive.ObjectID, 0, len(uids))
+	for _, uid := range uids {
+		id, err := primitive.ObjectIDFromHex(uid)
+		if err == nil {
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+func setThumbsDeleted(ctx context.Context, uids []string, operator string, deleted int64) error {
+	filter := bson.M{"_id": bson.M{"$in": objectIDs(uids)}}
+	node := bson.M{"$set": bson.M{"operator": operator, TimeDeleted: deleted}, "$inc": bson.M{FieldRevision: 1}}
+	_, err := noSql.Collection(TableThumbs).UpdateMany(ctx, filter, node)
+	return err
+}
+
+func setThumbsField(ctx context.Context, uids []string, field, value, operator string) error {
+	filter := bson.M{"_id": bson.M{"$in": objectIDs(uids)}}
+	node := bson.M{"$set": bson.M{field: value, "operator": operator, TimeUpdated: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
+	_, err := noSql.Collection(TableThumbs).UpdateMany(ctx, filter, node)
+	return err
+}
+
+func setAssetOwner(ctx context.Context, uid, owner, operator string) error {
+	objID, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return err
+	}
+	node := bson.M{"$set": bson.M{"owner": owner, "operator": operator, TimeUpdated: time.Now().Unix()}, "$inc": bson.M{FieldRevision: 1}}
+	_, err = noSql.Collection(TableAssets).UpdateOne(ctx, bson.M{"_id": objID}, node)
+	return err
+}
+
+func findThumbs(ctx context.Context, filter bson.M) ([]*Thumb, error) {
+	cursor, err := noSql.Collection(TableThumbs).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	items := make([]*Thumb, 0, 10)
+	for cursor.Next(ctx) {
+		var node = new(Thumb)
+		if err := cursor.Decode(node); err != nil {
+			return nil, err
+		}
+		items = append(items, node)
+	}
+	return items, nil
+}
+
+func thumbUIDs(list []*Thumb) []string {
+	uids := make([]string, 0, len(list))
+	for _, item := range list {
+		uids = append(uids, item.UID.Hex())
+	}
+	return uids
+}
+
+//写入回收站记录，删除资源并软删除它的人脸，返回被删除的人脸；stage在同一个事务里写入审计记录和事件
+func RemoveAssetWithThumbs(recycle *Recycle, asset *Asset, operator string, stage func(ctx context.Context, thumbs []*Thumb) error) ([]*Thumb, error) {
+	var thumbs []*Thumb
+	steps := func(ctx context.Context, undo *compensator) error {
+		var err error
+		thumbs, err = findThumbs(ctx, bson.M{"asset": asset.UID.Hex(), TimeDeleted: 0})
+		if err != nil {
+			return err
+		}
+		_, err = noSql.Collection(TableRecycles).InsertOne(ctx, recycle)
+		if err != nil {
+			return err
+		}
+		undo.push(func(ctx context.Context) error {
+			_, er := noSql.Collection(TableRecycles).DeleteOne(ctx, bson.M{"_id": recycle.UID})
+			return er
+		})
+		_, err = noSql.Collection(TableAssets).DeleteOne(ctx, bson.M{"_id": asset.UID})
+		if err != nil {
+			return err
+		}
+		undo.push(func(ctx context.Context) error {
+			_, er := noSql.Collection(TableAssets).InsertOne(ctx, asset)
+			return er
+		})
+		uids := thumbUIDs(thumbs)
+		if len(uids) > 0 {
+			undo.push(func(ctx context.Context) error {
+				return setThumbsDeleted(ctx, uids, operator, 0)
+			})
+			err = setThumbsDeleted(ctx, uids, operator, time.Now().Unix())
+			if err != nil {
+				return err
+			}
+		}
+		return stage(ctx, thumbs)
+	}
+	err := runCompound(steps)
+	return thumbs, err
+}
+
+//把人脸的用户改为实体，同时把人脸所在资源的所有者改为实体，返回修改过的人脸和资源原来的所有者；stage在同一个事务里写入审计记录和事件
+func BindThumbsEntity(user, entity, operator string, stage func(ctx context.Context, thumbs []*Thumb, owners map[string]string) error) ([]*Thumb, map[string]string, error) {
+	var thumbs []*Thumb
+	var owners map[string]string
+	steps := func(ctx context.Context, undo *compensator) error {
+		var err error
+		owners = make(map[string]string)
+		thumbs, err = findThumbs(ctx, bson.M{"user": user, TimeDeleted: 0})
+		if err != nil {
+			return err
+		}
+		if len(thumbs) < 1 {
+			return nil
+		}
+		uids := thumbUIDs(thumbs)
+		undo.push(func(ctx context.Context) error {
+			return setThumbsField(ctx, uids, "user", user, operator)
+		})
+		err = setThumbsField(ctx, uids, "user", entity, operator)
+		if err != nil {
+			return err
+		}
+		for _, thumb := range thumbs {
+			if _, ok := owners[thumb.Asset]; ok {
+				continue
+			}
+			var asset Asset
+			objID, _ := primitive.ObjectIDFromHex(thumb.Asset)
+			er := noSql.Collection(TableAssets).FindOne(ctx, bson.M{"_id": objID}).Decode(&asset)
+			if er != nil {
+				//资源已经被删除，只修改人脸
+				continue
+			}
+			uid, old := thumb.Asset, asset.Owner
+			owners[uid] = old
+			undo.push(func(ctx context.Context) error {
+				return setAssetOwner(ctx, uid, old, operator)
+			})
+			err = setAssetOwner(ctx, uid, entity, operator)
+			if err != nil {
+				return err
+			}
+		}
+		return stage(ctx, thumbs, owners)
+	}
+	err := runCompound(steps)
+	if err != nil {
+		return nil, nil, err
+	}
+	return thumbs, owners, nil
+}
+
+//转移资源的所有者，资源下属于原所有者的人脸一起转移，返回转移的人脸；stage在同一个事务里写入审计记录和事件
+func TransferAssetOwner(uid, from, owner, operator string, revision int64, stage func(ctx context.Context, thumbs []string) error) ([]string, error) {
+	var uids []string
+	steps := func(ctx context.Context, undo *compensator) error {
+		thumbs, err := findThumbs(ctx, bson.M{"asset": uid, "owner": from, TimeDeleted: 0})
+		if err != nil {
+			return err
+		}
+		undo.push(func(ctx context.Context) error {
+			return setAssetOwner(ctx, uid, from, operator)
+		})
+		msg := bson.M{"owner": owner, "operator": operator, TimeUpdated: time.Now().Unix()}
+		_, err = updateOneRevisionIn(ctx, TableAssets, uid, revision, msg)
+		if err != nil {
+			return err
+		}
+		uids = thumbUIDs(thumbs)
+		if len(uids) > 0 {
+			undo.push(func(ctx context.Context) error {
+				return setThumbsField(ctx, uids, "owner", from, operator)
+			})
+			err = setThumbsField(ctx, uids, "owner", owner, operator)
+			if err != nil {
+				return err
+			}
+		}
+		return stage(ctx, uids)
+	}
+	err := runCompound(steps)
+	return uids, err
+}
+
+//支持事务时在一个事务里执行写操作，否则依次执行
+func RunWrite(fn func(ctx context.Context) error) error {
+	return runCompound(func(ctx context.Context, _ *compensator) error {
+		return fn(ctx)
+	})
+}
+
+//支持事务时在一个事务里执行所有步骤，否则依次执行，失败时撤销已经完成的步骤
+func runCompound(steps func(ctx context.Context, undo *compensator) error) error {
+	if txSupported {
+		return withTransaction(func(sc mongo.SessionContext) error {
+			return steps(sc, new(compensator))
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	undo := new(compensator)
+	err := steps(ctx, undo)
+	if err != nil {
+		back, done := context.WithTimeout(context.Background(), timeOut)
+		defer done()
+		undo.rollback(back)
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package nosql
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//资源和人脸集合的一次变更，Token可以用于断线后继续订阅
+type Change struct {
+	Token      string
+	Operation  string
+	Collection string
+	Key        string
+	Asset      *Asset
+	Thumb      *Thumb
+}
+
+type changeEvent struct {
+	Operation string   `bson:"operationType"`
+	NS        bson.M   `bson:"ns"`
+	Key       bson.Raw `bson:"documentKey"`
+	Document  bson.Raw `bson:"fullDocument"`
+}
+
+//监听资源和人脸集合的变更（需要MongoDB副本集），match为对fullDocument的过滤条件
+func WatchChanges(ctx context.Context, match bson.M, token string, fn func(change *Change) error) error {
+	if noSql == nil {
+		return errors.New("the database not connected")
+	}
+	filter := bson.M{"ns.coll": bson.M{"$in": bson.A{TableAssets, TableThumbs}}}
+	for key, val := range match {
+		filter["fullDocument."+key] = val
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if len(token) > 0 {
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return errors.New("the resume token is invalid")
+		}
+		opts.SetResumeAfter(bson.Raw(raw))
+	}
+	stream, err := noSql.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+	for stream.Next(ctx) {
+		event := new(changeEvent)
+		if er := stream.Decode(event); er != nil {
+			return er
+		}
+		change := &Change{
+			Token:     base64.RawURLEncoding.EncodeToString(stream.ResumeToken()),
+			Operation: event.Operation,
+		}
+		change.Collection, _ = event.NS["coll"].(string)
+		if id, ok := event.Key.Lookup("_id").ObjectIDOK(); ok {
+			change.Key = id.Hex()
+		}
+		if len(event.Document) > 0 {
+			if change.Collection == TableAssets {
+				change.Asset = new(Asset)
+				if er := bson.Unmarshal(event.Document, change.Asset); er != nil {
+					return er
+				}
+			} else {
+				change.Thumb = new(Thumb)
+				if er := bson.Unmarshal(event.Document, change.Thumb); er != nil {
+					return er
+				}
+			}
+		}
+		if er := fn(change); er != nil {
+			return er
+		}
+	}
+	return stream.Err()
+}
@@ -0,0 +1,184 @@
+package nosql
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	DeliveryPending uint8 = 0
+	DeliverySuccess uint8 = 1
+	DeliveryDead    uint8 = 2 //重试次数用完，不再投递
+)
+
+//外部系统订阅的webhook，Events、Owner、Quote、Types为空时表示不过滤
+type Webhook struct {
+	UID      primitive.ObjectID `bson:"_id"`
+	Name     string             `json:"name" bson:"name"`
+	Created  int64              `json:"created" bson:"created"`
+	Updated  int64              `json:"updated" bson:"updated"`
+	Deleted  int64              `json:"deleted" bson:"deleted"`
+	Creator  string             `json:"creator" bson:"creator"`
+	Operator string             `json:"operator" bson:"operator"`
+
+	URL    string   `json:"url" bson:"url"`
+	Secret string   `json:"secret" bson:"secret"`
+	Events []string `json:"events" bson:"events"`
+	Owner  string   `json:"owner" bson:"owner"`
+	Quote  string   `json:"quote" bson:"quote"`
+	Types  []uint32 `json:"types" bson:"types"`
+}
+
+//webhook的一次投递，Logs记录每次尝试的结果
+type Delivery struct {
+	UID     primitive.ObjectID `bson:"_id"`
+	Created int64              `json:"created" bson:"created"`
+	Updated int64              `json:"updated" bson:"updated"`
+
+	Webhook  string        `json:"webhook" bson:"webhook"`
+	Event    string        `json:"event" bson:"event"`
+	Type     string        `json:"type" bson:"type"`
+	Payload  string        `json:"payload" bson:"payload"`
+	Status   uint8         `json:"status" bson:"status"`
+	Attempts uint32        `json:"attempts" bson:"attempts"`
+	Next     int64         `json:"next" bson:"next"`
+	Logs     []DeliveryLog `json:"logs" bson:"logs"`
+}
+
+type DeliveryLog struct {
+	Time     int64  `json:"time" bson:"time"`
+	Code     int    `json:"code" bson:"code"`
+	Duration int64  `json:"duration" bson:"duration"`
+	Response string `json:"response" bson:"response"`
+	Error    string `json:"error" bson:"error"`
+}
+
+func CreateWebhook(info *Webhook) error {
+	_, err := insertOne(TableWebhooks, info)
+	return err
+}
+
+func RemoveWebhook(uid, operator string) error {
+	if len(uid) < 2 {
+		return errors.New("db webhook uid is empty ")
+	}
+	_, err := removeOne(TableWebhooks, uid, operator)
+	return err
+}
+
+func GetWebhook(uid string) (*Webhook, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db webhook uid is empty of GetWebhook")
+	}
+	result, err := findOne(TableWebhooks, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Webhook)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+func GetAllWebhooks() ([]*Webhook, error) {
+	var items = make([]*Webhook, 0, 10)
+	filter := bson.M{TimeDeleted: 0}
+	cursor, err1 := findMany(TableWebhooks, filter, 0)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Webhook)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+func CreateDelivery(ctx context.Context, info *Delivery) error {
+	_, err := insertOneIn(ctx, TableDeliveries, info)
+	return err
+}
+
+func GetDelivery(uid string) (*Delivery, error) {
+	if len(uid) < 2 {
+		return nil, errors.New("db delivery uid is empty of GetDelivery")
+	}
+	result, err := findOne(TableDeliveries, uid)
+	if err != nil {
+		return nil, err
+	}
+	model := new(Delivery)
+	err1 := result.Decode(&model)
+	if err1 != nil {
+		return nil, err1
+	}
+	return model, nil
+}
+
+//到了投递时间的待投递记录
+func GetDueDeliveries(now, num int64) ([]*Delivery, error) {
+	var items = make([]*Delivery, 0, num)
+	filter := bson.M{"status": DeliveryPending, "next": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(num)
+	cursor, err1 := findManyByOpts(TableDeliveries, filter, opts)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Delivery)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+func GetDeliveriesCount(webhook string) int64 {
+	num, _ := getCountByFilter(TableDeliveries, bson.M{"webhook": webhook})
+	return num
+}
+
+//最近的投递记录，新的在前
+func GetDeliveries(webhook string, start, num int64) ([]*Delivery, error) {
+	var items = make([]*Delivery, 0, num)
+	filter := bson.M{"webhook": webhook}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetSkip(start).SetLimit(num)
+	cursor, err1 := findManyByOpts(TableDeliveries, filter, opts)
+	if err1 != nil {
+		return nil, err1
+	}
+	for cursor.Next(context.Background()) {
+		var node = new(Delivery)
+		if err := cursor.Decode(&node); err != nil {
+			return nil, err
+		} else {
+			items = append(items, node)
+		}
+	}
+	return items, nil
+}
+
+func UpdateDeliveryAttempt(uid string, status uint8, attempts uint32, next int64, log DeliveryLog) error {
+	if len(uid) < 2 {
+		return errors.New("db delivery uid is empty of UpdateDeliveryAttempt")
+	}
+	msg := bson.M{"status": status, "attempts": attempts, "next": next, TimeUpdated: time.Now().Unix()}
+	_, err := updateOne(TableDeliveries, uid, msg)
+	if err != nil {
+		return err
+	}
+	_, err = appendElement(TableDeliveries, uid, bson.M{"logs": log})
+	return err
+}
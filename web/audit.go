@@ -0,0 +1,46 @@
+package web
+
+import (
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"net/http"
+	"omo.msa.asset/cache"
+	"strconv"
+	"time"
+)
+
+//GET /asset/audit?target=xxx&actor=xxx&from=unix&to=unix，以NDJSON格式导出审计记录
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "the method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	who, err := getPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !who.CanManage() {
+		http.Error(w, "the operator has no permission", http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	target := query.Get("target")
+	actor := query.Get("actor")
+	from, _ := strconv.ParseInt(query.Get("from"), 10, 64)
+	to, _ := strconv.ParseInt(query.Get("to"), 10, 64)
+	logger.Infof("[in.web.audit]:target = %s, actor = %s, from = %d, to = %d", target, actor, from, to)
+
+	name := fmt.Sprintf("audit-%s.ndjson", time.Now().Format("20060102150405"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	writer := &trackWriter{ResponseWriter: w}
+	err = cache.Context().ExportAudits(writer, target, actor, from, to)
+	if err != nil {
+		logger.Warn("[error.web.audit]:msg = " + err.Error())
+		if !writer.written {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"github.com/micro/go-micro/v2/logger"
+	"net/http"
+	"omo.msa.asset/cache"
+	"omo.msa.asset/config"
+)
+
+func Start() {
+	addr := config.Schema.Web.Address
+	if len(addr) < 1 {
+		logger.Warn("the web address is empty so the http server not start")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/asset/export", exportHandler)
+	mux.HandleFunc("/asset/audit", auditHandler)
+	mux.HandleFunc("/asset/watch", watchHandler)
+	go func() {
+		logger.Infof("the http server listen at %s", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			logger.Error("the http server stopped that msg = " + err.Error())
+		}
+	}()
+}
+
+//开启认证时使用认证后的身份，否则使用网关透传的身份；透传的身份没有校验，不读取角色
+func getPrincipal(r *http.Request) (*cache.Principal, error) {
+	if cache.AuthEnable() {
+		return cache.Authenticate(r.Header.Get, r.URL.Path)
+	}
+	return cache.NewPrincipal(r.Header.Get(cache.HeaderUser), cache.SplitHeader(r.Header.Get(cache.HeaderScenes)), nil), nil
+}
+
+//记录是否已经开始输出，便于在出错时判断还能不能返回错误码
+type trackWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (mine *trackWriter) Write(p []byte) (int, error) {
+	mine.written = true
+	return mine.ResponseWriter.Write(p)
+}
+
+func (mine *trackWriter) Flush() {
+	if flusher, ok := mine.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"fmt"
+	"github.com/micro/go-micro/v2/logger"
+	"net/http"
+	"omo.msa.asset/cache"
+	"strings"
+	"time"
+)
+
+//GET /asset/export?folder=xxx | quote=xxx | assets=a,b,c [&manifest=true]
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "the method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	who, err := getPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	kind := ""
+	key := ""
+	var list []string
+	if query.Get("folder") != "" {
+		kind = cache.ExportFolder
+		key = query.Get("folder")
+		folder, er := cache.Context().GetFolder(key)
+		if er != nil {
+			http.Error(w, er.Error(), http.StatusNotFound)
+			return
+		}
+		if !who.CanFolder(folder, cache.ActionRead) {
+			http.Error(w, "the operator has no permission", http.StatusForbidden)
+			return
+		}
+	} else if query.Get("quote") != "" {
+		kind = cache.ExportQuote
+		key = query.Get("quote")
+	} else if query.Get("assets") != "" {
+		kind = cache.ExportAssets
+		list = strings.Split(query.Get("assets"), ",")
+	} else {
+		http.Error(w, "the folder, quote or assets is empty", http.StatusBadRequest)
+		return
+	}
+	manifest := query.Get("manifest") == "true" || query.Get("manifest") == "1"
+	logger.Infof("[in.web.export]:kind = %s, key = %s, assets = %d", kind, key, len(list))
+
+	name := fmt.Sprintf("%s-%s.zip", kind, time.Now().Format("20060102150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	writer := &trackWriter{ResponseWriter: w}
+	err = cache.Context().ExportZip(r.Context(), writer, who, kind, key, list, manifest)
+	if err != nil {
+		logger.Warn("[error.web.export]:msg = " + err.Error())
+		if !writer.written {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/micro/go-micro/v2/logger"
+	"net/http"
+	"omo.msa.asset/cache"
+)
+
+//GET /asset/watch?owner=xxx&quote=xxx&folder=xxx&token=xxx，以NDJSON格式持续推送资源和人脸的变更，
+//每条变更都带有token，断线后用最后收到的token重新订阅不会丢失变更
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "the method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	who, err := getPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	filter := &cache.WatchFilter{
+		Owner:  query.Get("owner"),
+		Quote:  query.Get("quote"),
+		Folder: query.Get("folder"),
+		Token:  query.Get("token"),
+	}
+	if len(filter.Token) < 1 {
+		filter.Token = r.Header.Get("Last-Event-ID")
+	}
+	logger.Infof("[in.web.watch]:owner = %s, quote = %s, folder = %s, token = %s", filter.Owner, filter.Quote, filter.Folder, filter.Token)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	writer := &trackWriter{ResponseWriter: w}
+	encoder := json.NewEncoder(writer)
+	err = cache.Context().WatchChanges(r.Context(), who, filter, func(change *cache.WatchChange) error {
+		er := encoder.Encode(change)
+		if er == nil {
+			writer.Flush()
+		}
+		return er
+	})
+	if err != nil && r.Context().Err() == nil {
+		logger.Warn("[error.web.watch]:msg = " + err.Error())
+		if !writer.written {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+}